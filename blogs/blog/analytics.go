@@ -0,0 +1,361 @@
+package blog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// View counting settings.
+const (
+	viewDedupWindow   = 30 * time.Minute // A visitor re-reading a post within this window counts once
+	viewFlushSize     = 1000             // Pending views that trigger an early flush
+	maxReferrerLength = 255
+	topReferrerCount  = 10
+)
+
+// botPattern matches the user agents of crawlers, link previewers and scripts.
+var botPattern = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|archiver|facebookexternalhit|embedly|preview|headless|lighthouse|curl|wget|python-requests|go-http-client|java/|okhttp|libwww|httpclient|scrapy`)
+
+type viewKey struct {
+	blogID int
+	day    string // YYYY-MM-DD, UTC
+}
+
+type referrerKey struct {
+	viewKey
+	referrer string
+}
+
+// viewRecorder buffers views in memory until they are flushed to the database.
+type viewRecorder struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // Visitor and post to the end of its dedup window
+	views     map[viewKey]int
+	referrers map[referrerKey]int
+	pending   int
+	flushNow  chan struct{}
+}
+
+var views = &viewRecorder{
+	seen:      map[string]time.Time{},
+	views:     map[viewKey]int{},
+	referrers: map[referrerKey]int{},
+	flushNow:  make(chan struct{}, 1),
+}
+
+// RecordView counts a read of a post unless it comes from a bot, from the
+// post's author or from a visitor who already read it within the dedup window.
+func RecordView(r *http.Request, b *Blog, claims *JWTClaims) {
+	ua := r.UserAgent()
+	if ua == "" || botPattern.MatchString(ua) {
+		return
+	}
+	if claims != nil && claims.Username == b.Author {
+		return
+	}
+
+	now := time.Now()
+	seenKey := strconv.Itoa(b.ID) + "|" + visitorID(r, claims)
+	key := viewKey{blogID: b.ID, day: now.UTC().Format(time.DateOnly)}
+	referrer := referrerHost(r.Referer())
+
+	views.mu.Lock()
+	if until, ok := views.seen[seenKey]; ok && now.Before(until) {
+		views.mu.Unlock()
+		return
+	}
+	views.seen[seenKey] = now.Add(viewDedupWindow)
+	views.views[key]++
+	if referrer != "" {
+		views.referrers[referrerKey{key, referrer}]++
+	}
+	views.pending++
+	full := views.pending >= viewFlushSize
+	views.mu.Unlock()
+
+	if full {
+		select {
+		case views.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// visitorID identifies a reader: logged-in users by name, others by a hash of
+// their address and user agent.
+func visitorID(r *http.Request, claims *JWTClaims) string {
+	if claims != nil {
+		return "u:" + claims.Username
+	}
+	sum := sha256.Sum256([]byte(clientIP(r) + "|" + r.UserAgent()))
+	return "a:" + hex.EncodeToString(sum[:16])
+}
+
+// referrerHost returns the host of an external referrer, or "" for direct
+// visits and links within the site.
+func referrerHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if site, err := url.Parse(siteURL); err == nil && strings.TrimPrefix(strings.ToLower(site.Hostname()), "www.") == host {
+		return ""
+	}
+	if len(host) > maxReferrerLength {
+		return ""
+	}
+	return host
+}
+
+// FlushViews writes the buffered views to the database in two batched
+// statements of one transaction. If that fails the views are put back into
+// the buffer for the next flush.
+func FlushViews() error {
+	views.mu.Lock()
+	counts, referrers := views.views, views.referrers
+	views.views, views.referrers, views.pending = map[viewKey]int{}, map[referrerKey]int{}, 0
+	now := time.Now()
+	for key, until := range views.seen {
+		if now.After(until) {
+			delete(views.seen, key)
+		}
+	}
+	views.mu.Unlock()
+
+	if len(counts) == 0 && len(referrers) == 0 {
+		return nil
+	}
+	if err := writeViews(counts, referrers); err != nil {
+		views.mu.Lock()
+		for key, n := range counts {
+			views.views[key] += n
+			views.pending += n
+		}
+		for key, n := range referrers {
+			views.referrers[key] += n
+		}
+		views.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func writeViews(counts map[viewKey]int, referrers map[referrerKey]int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(counts) > 0 {
+		values := make([]string, 0, len(counts))
+		args := make([]interface{}, 0, 3*len(counts))
+		for key, n := range counts {
+			values = append(values, "(?, ?, ?)")
+			args = append(args, key.blogID, key.day, n)
+		}
+		// IGNORE skips views of posts deleted since they were read
+		query := `INSERT IGNORE INTO blog_views_daily (blog_id, day, views) VALUES ` + strings.Join(values, ", ") +
+			` ON DUPLICATE KEY UPDATE views = views + VALUES(views)`
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+
+	if len(referrers) > 0 {
+		values := make([]string, 0, len(referrers))
+		args := make([]interface{}, 0, 4*len(referrers))
+		for key, n := range referrers {
+			values = append(values, "(?, ?, ?, ?)")
+			args = append(args, key.blogID, key.day, key.referrer, n)
+		}
+		query := `INSERT IGNORE INTO blog_referrers_daily (blog_id, day, referrer, views) VALUES ` + strings.Join(values, ", ") +
+			` ON DUPLICATE KEY UPDATE views = views + VALUES(views)`
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// StartViewFlushWorker flushes buffered views every interval, early when the
+// buffer fills up, and once more when ctx is cancelled. The returned channel
+// is closed after that last flush.
+func StartViewFlushWorker(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := FlushViews(); err != nil {
+					log.Printf("Failed to flush views: %v", err)
+				}
+				return
+			case <-ticker.C:
+			case <-views.flushNow:
+			}
+			if err := FlushViews(); err != nil {
+				log.Printf("Failed to flush views: %v", err)
+			}
+		}
+	}()
+	return done
+}
+
+// ViewPoint is the number of views in one day or week.
+type ViewPoint struct {
+	Start string `json:"start"` // First day of the period, YYYY-MM-DD
+	Views int    `json:"views"`
+}
+
+// ReferrerCount is the number of views that came from one site.
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Views    int    `json:"views"`
+}
+
+// ViewStats summarises the views of a post.
+type ViewStats struct {
+	BlogID       int             `json:"blog_id"`
+	TotalViews   int             `json:"total_views"`
+	Period       string          `json:"period"` // daily or weekly
+	Series       []ViewPoint     `json:"series"`
+	TopReferrers []ReferrerCount `json:"top_referrers"`
+}
+
+// GetViewStats builds the view time series of the last points days or weeks,
+// oldest first, and the top referrers over the same range.
+func GetViewStats(blogID int, period string, points int) (*ViewStats, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	step := 1
+	start := today.AddDate(0, 0, 1-points)
+	if period == "weekly" {
+		step = 7
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		start = monday.AddDate(0, 0, -7*(points-1))
+	}
+
+	stats := &ViewStats{BlogID: blogID, Period: period, Series: make([]ViewPoint, points), TopReferrers: []ReferrerCount{}}
+	for i := range stats.Series {
+		stats.Series[i].Start = start.AddDate(0, 0, i*step).Format(time.DateOnly)
+	}
+
+	if err := db.QueryRow(`SELECT COALESCE(SUM(views), 0) FROM blog_views_daily WHERE blog_id = ?`, blogID).Scan(&stats.TotalViews); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT day, views FROM blog_views_daily WHERE blog_id = ? AND day >= ?`, blogID, start.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day time.Time
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if i := int(day.UTC().Sub(start).Hours()/24) / step; i >= 0 && i < points {
+			stats.Series[i].Views += n
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT referrer, SUM(views) AS total FROM blog_referrers_daily WHERE blog_id = ? AND day >= ?
+		GROUP BY referrer ORDER BY total DESC, referrer LIMIT ?`, blogID, start.Format(time.DateOnly), topReferrerCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rc ReferrerCount
+		if err := rows.Scan(&rc.Referrer, &rc.Views); err != nil {
+			return nil, err
+		}
+		stats.TopReferrers = append(stats.TopReferrers, rc)
+	}
+	return stats, rows.Err()
+}
+
+// GetBlogStats returns view analytics of a post to its author and Admins.
+// @Summary Get view analytics of a blog post
+// @Description Daily (last 30 days by default) or weekly (last 12 weeks by default) view counts, total views and the top referring sites of a post. Views are flushed from memory in batches, so the newest ones may take a few seconds to appear. Author and Admins only.
+// @Tags Analytics
+// @Produce  json
+// @Param   id      path   int     true   "Blog ID"
+// @Param   period  query  string  false  "daily (default) or weekly"
+// @Param   points  query  int     false  "Number of days (at most 365) or weeks (at most 104)"
+// @Success 200 {object} ViewStats
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /analytics/blogs/{id} [get]
+func GetBlogStats(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	points, maxPoints := 30, 365
+	switch period {
+	case "", "daily":
+		period = "daily"
+	case "weekly":
+		points, maxPoints = 12, 104
+	default:
+		http.Error(w, "Invalid period: use daily or weekly", http.StatusBadRequest)
+		return
+	}
+	if v := r.URL.Query().Get("points"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPoints {
+			http.Error(w, "Invalid points", http.StatusBadRequest)
+			return
+		}
+		points = n
+	}
+
+	blog, err := GetBlogByID(blogID)
+	if err != nil || blog == nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	if !canManage(blog, claims) {
+		http.Error(w, "Forbidden: only the author and Admins can see analytics", http.StatusForbidden)
+		return
+	}
+
+	stats, err := GetViewStats(blogID, period, points)
+	if err != nil {
+		log.Printf("Failed to load view stats: %v", err)
+		http.Error(w, "Failed to load analytics", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,208 @@
+package blog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"shared/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes a personal access token needs to call the blog endpoints. They match the
+// scopes issued by the user management service.
+const (
+	ScopeBlogsRead  = "blogs:read"
+	ScopeBlogsWrite = "blogs:write"
+	ScopeAdmin      = auth.ScopeAdmin
+)
+
+// tokenPrefix marks a bearer token as a personal access token rather than a JWT.
+const tokenPrefix = "pat_"
+
+// Secret key used to verify tokens; it must match the user management service.
+var jwtKey = []byte("my_secret_key")
+
+// userServiceURL is the base URL of the user management service.
+var userServiceURL = "http://localhost:8000"
+
+var userServiceClient = &http.Client{Timeout: 5 * time.Second}
+
+var errTokenInvalid = errors.New("invalid or expired token")
+
+// introspectionTTL is how long the outcome of a token introspection is reused.
+// A revoked token keeps working for at most this long.
+const introspectionTTL = 30 * time.Second
+
+// introspected caches introspection outcomes by the SHA-256 of the token, so
+// the token itself is not kept in memory.
+var introspected = struct {
+	sync.Mutex
+	entries map[[sha256.Size]byte]introspection
+}{entries: map[[sha256.Size]byte]introspection{}}
+
+type introspection struct {
+	claims  *JWTClaims // nil when the token was rejected
+	expires time.Time
+}
+
+// SetUserServiceURL sets the base URL of the user management service.
+func SetUserServiceURL(url string) {
+	userServiceURL = strings.TrimSuffix(url, "/")
+}
+
+// ContextWithClaims adds JWT claims to the request context.
+func ContextWithClaims(ctx context.Context, claims *JWTClaims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// HasScope reports whether the claims grant the given scope. Sessions created
+// by /login carry no scopes and are not restricted.
+func (c *JWTClaims) HasScope(scope string) bool {
+	return auth.HasScope(c.Scopes, c.PersonalAccessToken, scope)
+}
+
+// IsAdmin reports whether the caller has the Admin role.
+func (c *JWTClaims) IsAdmin() bool {
+	return c.Role == auth.RoleAdmin
+}
+
+// ProtectedRoute authenticates the request with either a JWT issued by the user
+// management service or a personal access token, and stores the claims in the
+// request context.
+func ProtectedRoute(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+		var claims *JWTClaims
+		var err error
+		if strings.HasPrefix(tokenString, tokenPrefix) {
+			claims, err = cachedIntrospectToken(r.Context(), tokenString)
+		} else {
+			claims, err = parseJWT(tokenString)
+		}
+		if err == errTokenInvalid {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Printf("Failed to authenticate request: %v", err)
+			http.Error(w, "Authentication service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		r = r.WithContext(ContextWithClaims(r.Context(), claims))
+		next(w, r)
+	}
+}
+
+// OptionalAuth authenticates requests that carry an Authorization header like
+// ProtectedRoute, and lets anonymous requests through without claims.
+func OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	protected := ProtectedRoute(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+		protected(w, r)
+	}
+}
+
+// RequireScope rejects personal access tokens that were not granted scope.
+// It must be wrapped by ProtectedRoute.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return auth.RequireScope(scope, ClaimsFromContext, next)
+}
+
+func parseJWT(tokenString string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errTokenInvalid
+	}
+	return claims, nil
+}
+
+// cachedIntrospectToken introspects a personal access token, reusing the
+// outcome of a recent introspection of the same token. Only valid and invalid
+// outcomes are cached; failures to reach the service are retried every time.
+func cachedIntrospectToken(ctx context.Context, tokenString string) (*JWTClaims, error) {
+	key := sha256.Sum256([]byte(tokenString))
+	now := time.Now()
+
+	introspected.Lock()
+	entry, ok := introspected.entries[key]
+	introspected.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.claims == nil {
+			return nil, errTokenInvalid
+		}
+		claims := *entry.claims
+		return &claims, nil
+	}
+
+	claims, err := introspectToken(ctx, tokenString)
+	if err != nil && err != errTokenInvalid {
+		return nil, err
+	}
+
+	introspected.Lock()
+	for k, e := range introspected.entries {
+		if now.After(e.expires) {
+			delete(introspected.entries, k)
+		}
+	}
+	entry = introspection{expires: now.Add(introspectionTTL)}
+	if claims != nil {
+		cached := *claims
+		entry.claims = &cached
+	}
+	introspected.entries[key] = entry
+	introspected.Unlock()
+	return claims, err
+}
+
+// introspectToken asks the user management service who a personal access token belongs to.
+func introspectToken(ctx context.Context, tokenString string) (*JWTClaims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userServiceURL+"/tokens/introspect", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	resp, err := userServiceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, errTokenInvalid
+	default:
+		return nil, fmt.Errorf("token introspection returned %s", resp.Status)
+	}
+
+	claims := &JWTClaims{}
+	if err := json.NewDecoder(resp.Body).Decode(claims); err != nil {
+		return nil, err
+	}
+	claims.PersonalAccessToken = true
+	return claims, nil
+}
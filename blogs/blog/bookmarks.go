@@ -0,0 +1,571 @@
+package blog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shared/mysqlerr"
+	"shared/validation"
+)
+
+// Pagination defaults of bookmark and reading list listings.
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// maxReadingListName is the longest reading list name, in characters.
+const maxReadingListName = 100
+
+// ErrReadingListExists is returned when a user already has a list with the same name.
+var ErrReadingListExists = errors.New("reading list already exists")
+
+// errOrderMismatch is returned when a new order does not list exactly the saved posts.
+var errOrderMismatch = errors.New("order must list every saved post exactly once")
+
+// ReadingList is a named, ordered collection of posts saved by a user.
+type ReadingList struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Items     int       `json:"items"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SavedPage is one page of saved posts.
+type SavedPage struct {
+	Blogs   []Blog `json:"blogs"`
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+	Total   int    `json:"total"`
+}
+
+// OrderRequest lists saved post IDs in their new order.
+type OrderRequest struct {
+	BlogIDs []int `json:"blog_ids"`
+}
+
+// ReadingListRequest names a reading list.
+type ReadingListRequest struct {
+	Name string `json:"name"`
+}
+
+// savedCollection is either a user's bookmarks or one of their reading lists:
+// the table holding its items and the condition selecting them.
+type savedCollection struct {
+	table string
+	where string
+	args  []interface{}
+	// insert adds blogID at position
+	insert func(tx *sql.Tx, blogID, position int) error
+}
+
+func bookmarksOf(username string) savedCollection {
+	return savedCollection{
+		table: "bookmarks",
+		where: "username = ?",
+		args:  []interface{}{username},
+		insert: func(tx *sql.Tx, blogID, position int) error {
+			_, err := tx.Exec(`INSERT IGNORE INTO bookmarks (username, blog_id, position) VALUES (?, ?, ?)`, username, blogID, position)
+			return err
+		},
+	}
+}
+
+func readingList(listID int) savedCollection {
+	return savedCollection{
+		table: "reading_list_items",
+		where: "list_id = ?",
+		args:  []interface{}{listID},
+		insert: func(tx *sql.Tx, blogID, position int) error {
+			_, err := tx.Exec(`INSERT IGNORE INTO reading_list_items (list_id, blog_id, position) VALUES (?, ?, ?)`, listID, blogID, position)
+			return err
+		},
+	}
+}
+
+// add appends a post to the collection unless it is already there.
+func (c savedCollection) add(blogID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the collection's rows so concurrent additions get distinct positions
+	var position int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM `+c.table+` WHERE `+c.where+` FOR UPDATE`, c.args...).Scan(&position); err != nil {
+		return err
+	}
+	if err := c.insert(tx, blogID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// remove takes a post out of the collection.
+func (c savedCollection) remove(blogID int) error {
+	_, err := db.Exec(`DELETE FROM `+c.table+` WHERE `+c.where+` AND blog_id = ?`, append(c.args, blogID)...)
+	return err
+}
+
+// reorder gives the saved posts the order of blogIDs.
+func (c savedCollection) reorder(blogIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT blog_id FROM `+c.table+` WHERE `+c.where+` FOR UPDATE`, c.args...)
+	if err != nil {
+		return err
+	}
+	saved := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		saved[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(blogIDs) != len(saved) {
+		return errOrderMismatch
+	}
+	seen := map[int]bool{}
+	for _, id := range blogIDs {
+		if !saved[id] || seen[id] {
+			return errOrderMismatch
+		}
+		seen[id] = true
+	}
+	for i, id := range blogIDs {
+		if _, err := tx.Exec(`UPDATE `+c.table+` SET position = ? WHERE `+c.where+` AND blog_id = ?`, append(append([]interface{}{i + 1}, c.args...), id)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// page returns the saved posts the user may still read, in their saved order.
+func (c savedCollection) page(username string, page, perPage int) (*SavedPage, error) {
+	visible := ` AND (b.visibility <> 'private' OR b.author = ? OR ` + acceptedCollaborator("?") + `)`
+	args := append(append([]interface{}{}, c.args...), username, username)
+
+	result := &SavedPage{Page: page, PerPage: perPage}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM `+c.table+` s JOIN blogs b ON b.id = s.blog_id WHERE s.`+c.where+visible, args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT s.blog_id FROM `+c.table+` s JOIN blogs b ON b.id = s.blog_id WHERE s.`+c.where+visible+
+		` ORDER BY s.position, s.blog_id LIMIT ? OFFSET ?`, append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if result.Blogs, err = GetBlogsByIDs(ids); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// acceptedCollaborator returns a condition that holds when user, a column or
+// placeholder, has accepted an invitation to collaborate on the post b.
+func acceptedCollaborator(user string) string {
+	return `EXISTS (SELECT 1 FROM blog_collaborators c WHERE c.blog_id = b.id AND c.username = ` + user + ` AND c.status = 'accepted')`
+}
+
+// removeSavedForOthers drops a post from the bookmarks and reading lists of
+// everyone who can no longer read it, used when the post is made private.
+// Its author and accepted collaborators keep theirs. Admins are not known
+// here, as roles live in the user service, so theirs are dropped too.
+func removeSavedForOthers(tx *sql.Tx, blogID int, author string) error {
+	if _, err := tx.Exec(`DELETE s FROM bookmarks s JOIN blogs b ON b.id = s.blog_id
+		WHERE s.blog_id = ? AND s.username <> ? AND NOT `+acceptedCollaborator("s.username"),
+		blogID, author); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE i FROM reading_list_items i JOIN reading_lists l ON l.id = i.list_id JOIN blogs b ON b.id = i.blog_id
+		WHERE i.blog_id = ? AND l.username <> ? AND NOT `+acceptedCollaborator("l.username"),
+		blogID, author)
+	return err
+}
+
+// CreateReadingList creates a named reading list for a user.
+func CreateReadingList(username, name string) (*ReadingList, error) {
+	res, err := db.Exec(`INSERT INTO reading_lists (username, name) VALUES (?, ?)`, username, name)
+	if mysqlerr.IsDuplicateEntry(err) {
+		return nil, ErrReadingListExists
+	} else if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &ReadingList{ID: int(id), Name: name, CreatedAt: time.Now().UTC().Truncate(time.Second)}, nil
+}
+
+// GetReadingLists returns a user's reading lists with their number of posts.
+func GetReadingLists(username string) ([]ReadingList, error) {
+	rows, err := db.Query(`SELECT l.id, l.name, COUNT(i.blog_id), l.created_at FROM reading_lists l
+		LEFT JOIN reading_list_items i ON i.list_id = l.id
+		WHERE l.username = ? GROUP BY l.id, l.name, l.created_at ORDER BY l.name`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []ReadingList{}
+	for rows.Next() {
+		var l ReadingList
+		if err := rows.Scan(&l.ID, &l.Name, &l.Items, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+// readingListOwned reports whether a reading list exists and belongs to username.
+func readingListOwned(listID int, username string) (bool, error) {
+	var owner string
+	err := db.QueryRow(`SELECT username FROM reading_lists WHERE id = ?`, listID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil && owner == username, err
+}
+
+// DeleteReadingList deletes a reading list and its items.
+func DeleteReadingList(listID int) error {
+	_, err := db.Exec(`DELETE FROM reading_lists WHERE id = ?`, listID)
+	return err
+}
+
+// paginationParams reads the page and per_page query parameters.
+func paginationParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	page, perPage := 1, defaultPerPage
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			http.Error(w, "Invalid per_page: must be between 1 and 100", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+// savedTarget resolves the collection a request refers to: the caller's
+// bookmarks, or the reading list in the path if the caller owns it.
+func savedTarget(w http.ResponseWriter, r *http.Request) (savedCollection, *JWTClaims, bool) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return savedCollection{}, nil, false
+	}
+	if r.PathValue("list") == "" {
+		return bookmarksOf(claims.Username), claims, true
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("list"))
+	if err != nil {
+		http.Error(w, "Reading list not found", http.StatusNotFound)
+		return savedCollection{}, nil, false
+	}
+	owned, err := readingListOwned(listID, claims.Username)
+	if err != nil {
+		log.Printf("Failed to retrieve reading list: %v", err)
+		http.Error(w, "Failed to retrieve reading list", http.StatusInternalServerError)
+		return savedCollection{}, nil, false
+	}
+	if !owned {
+		http.Error(w, "Reading list not found", http.StatusNotFound)
+		return savedCollection{}, nil, false
+	}
+	return readingList(listID), claims, true
+}
+
+// GetSaved lists one page of the caller's bookmarks or of one of their reading lists.
+// @Summary List saved posts
+// @Description Lists the caller's bookmarks (/bookmarks) or the posts of one of their reading lists (/reading-lists/{list}), in saved order. Posts that were deleted or made private disappear automatically.
+// @Tags Bookmarks
+// @Produce  json
+// @Param   list      path   int  false  "Reading list ID"
+// @Param   page      query  int  false  "Page, starting at 1"
+// @Param   per_page  query  int  false  "Posts per page, at most 100 (default 20)"
+// @Success 200 {object} SavedPage
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /bookmarks [get]
+// @Router /reading-lists/{list} [get]
+func GetSaved(w http.ResponseWriter, r *http.Request) {
+	collection, claims, ok := savedTarget(w, r)
+	if !ok {
+		return
+	}
+	page, perPage, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := collection.page(claims.Username, page, perPage)
+	if err != nil {
+		log.Printf("Failed to retrieve saved posts: %v", err)
+		http.Error(w, "Failed to retrieve saved posts", http.StatusInternalServerError)
+		return
+	}
+	lockListed(result.Blogs, claims)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// SavePost adds a post to the caller's bookmarks or to one of their reading lists.
+// @Summary Save a post
+// @Description Adds a post at the end of the caller's bookmarks or of one of their reading lists; saving a post twice changes nothing
+// @Tags Bookmarks
+// @Produce  json
+// @Param   list  path  int  false  "Reading list ID"
+// @Param   id    path  int  true   "Blog ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /bookmarks/{id} [put]
+// @Router /reading-lists/{list}/items/{id} [put]
+func SavePost(w http.ResponseWriter, r *http.Request) {
+	collection, claims, ok := savedTarget(w, r)
+	if !ok {
+		return
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return
+	}
+	if blog == nil || (blog.Visibility == VisibilityPrivate && !canManage(blog, claims)) {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+
+	if err := collection.add(blogID); err != nil {
+		log.Printf("Failed to save blog: %v", err)
+		http.Error(w, "Failed to save blog post", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Blog post saved",
+	})
+}
+
+// UnsavePost removes a post from the caller's bookmarks or from one of their reading lists.
+// @Summary Remove a saved post
+// @Description Removes a post from the caller's bookmarks or from one of their reading lists
+// @Tags Bookmarks
+// @Produce  json
+// @Param   list  path  int  false  "Reading list ID"
+// @Param   id    path  int  true   "Blog ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /bookmarks/{id} [delete]
+// @Router /reading-lists/{list}/items/{id} [delete]
+func UnsavePost(w http.ResponseWriter, r *http.Request) {
+	collection, _, ok := savedTarget(w, r)
+	if !ok {
+		return
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+
+	if err := collection.remove(blogID); err != nil {
+		log.Printf("Failed to remove saved blog: %v", err)
+		http.Error(w, "Failed to remove saved blog post", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Blog post removed",
+	})
+}
+
+// ReorderSaved changes the order of the caller's bookmarks or of one of their reading lists.
+// @Summary Reorder saved posts
+// @Description Sets the order of the caller's bookmarks or of one of their reading lists. The request must list every saved post exactly once.
+// @Tags Bookmarks
+// @Accept  json
+// @Produce  json
+// @Param   list   path  int           false  "Reading list ID"
+// @Param   order  body  OrderRequest  true   "Saved post IDs in their new order"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /bookmarks/order [put]
+// @Router /reading-lists/{list}/order [put]
+func ReorderSaved(w http.ResponseWriter, r *http.Request) {
+	collection, _, ok := savedTarget(w, r)
+	if !ok {
+		return
+	}
+	var req OrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	err := collection.reorder(req.BlogIDs)
+	if err == errOrderMismatch {
+		http.Error(w, "The order must list every saved post exactly once", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Failed to reorder saved blogs: %v", err)
+		http.Error(w, "Failed to reorder saved blog posts", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Order updated",
+	})
+}
+
+// GetReadingListsHandler lists the caller's reading lists.
+// @Summary List reading lists
+// @Description Lists the caller's reading lists with the number of posts in each
+// @Tags Bookmarks
+// @Produce  json
+// @Success 200 {array} ReadingList
+// @Failure 401 {object} map[string]string
+// @Router /reading-lists [get]
+func GetReadingListsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	lists, err := GetReadingLists(claims.Username)
+	if err != nil {
+		log.Printf("Failed to retrieve reading lists: %v", err)
+		http.Error(w, "Failed to retrieve reading lists", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(lists)
+}
+
+// CreateReadingListHandler creates a reading list for the caller.
+// @Summary Create a reading list
+// @Description Creates a named reading list; names are unique per user
+// @Tags Bookmarks
+// @Accept  json
+// @Produce  json
+// @Param   list  body  ReadingListRequest  true  "Reading list name"
+// @Success 201 {object} ReadingList
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /reading-lists [post]
+func CreateReadingListHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req ReadingListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	switch {
+	case req.Name == "":
+		validation.Write(w, validation.Errors{"name": "is required"})
+		return
+	case utf8.RuneCountInString(req.Name) > maxReadingListName:
+		validation.Write(w, validation.Errors{"name": validation.AtMost(maxReadingListName, "characters")})
+		return
+	}
+
+	list, err := CreateReadingList(claims.Username, req.Name)
+	if err == ErrReadingListExists {
+		http.Error(w, "A reading list with this name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Failed to create reading list: %v", err)
+		http.Error(w, "Failed to create reading list", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(list)
+}
+
+// DeleteReadingListHandler deletes one of the caller's reading lists.
+// @Summary Delete a reading list
+// @Description Deletes one of the caller's reading lists; the posts themselves are not affected
+// @Tags Bookmarks
+// @Produce  json
+// @Param   list  path  int  true  "Reading list ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /reading-lists/{list} [delete]
+func DeleteReadingListHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := savedTarget(w, r); !ok {
+		return
+	}
+	listID, _ := strconv.Atoi(r.PathValue("list"))
+	if err := DeleteReadingList(listID); err != nil {
+		log.Printf("Failed to delete reading list: %v", err)
+		http.Error(w, "Failed to delete reading list", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Reading list deleted",
+	})
+}
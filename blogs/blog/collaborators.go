@@ -0,0 +1,433 @@
+package blog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/validation"
+)
+
+// Collaborator roles. Co-authors share the byline and may edit and delete the
+// post; editors may edit its content but not its visibility; viewers may read
+// it whatever its visibility. Only the author manages collaborators.
+const (
+	RoleCoAuthor = "co-author"
+	RoleEditor   = "editor"
+	RoleViewer   = "viewer"
+)
+
+// Invitation states.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+)
+
+// maxCollaborators is the most collaborators, invited or accepted, a post may have.
+const maxCollaborators = 20
+
+// Errors returned when inviting collaborators.
+var (
+	ErrCollaboratorLimit = errors.New("too many collaborators")
+	ErrNoInvitation      = errors.New("no pending invitation")
+)
+
+// Collaborator is a user invited to work on a post.
+type Collaborator struct {
+	BlogID     int        `json:"blog_id"`
+	Username   string     `json:"username"`
+	Role       string     `json:"role"`
+	Status     string     `json:"status"` // pending or accepted
+	InvitedBy  string     `json:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+// Invitation is a pending collaborator invitation with the post it is for.
+type Invitation struct {
+	Collaborator
+	Title string `json:"title"`
+}
+
+// InviteRequest sets the role of an invited collaborator.
+type InviteRequest struct {
+	Role string `json:"role"`
+}
+
+func isCollaboratorRole(role string) bool {
+	return role == RoleCoAuthor || role == RoleEditor || role == RoleViewer
+}
+
+// roleOf returns "author" for the post's author, their accepted collaborator
+// role for collaborators and "" for everyone else. It uses the collaborators
+// loaded with the post.
+func roleOf(b *Blog, claims *JWTClaims) string {
+	if claims == nil {
+		return ""
+	}
+	if claims.Username == b.Author {
+		return "author"
+	}
+	return b.collaborators[claims.Username]
+}
+
+// InviteCollaborator invites a user to a post with a role, or changes the role
+// of an existing collaborator without resetting their acceptance.
+func InviteCollaborator(blogID int, username, role, invitedBy string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	var exists bool
+	if err := tx.QueryRow(`SELECT COUNT(*), COALESCE(SUM(username = ?), 0) > 0 FROM blog_collaborators WHERE blog_id = ? FOR UPDATE`,
+		username, blogID).Scan(&count, &exists); err != nil {
+		return err
+	}
+	if !exists && count >= maxCollaborators {
+		return ErrCollaboratorLimit
+	}
+	if _, err := tx.Exec(`INSERT INTO blog_collaborators (blog_id, username, role, status, invited_by) VALUES (?, ?, ?, 'pending', ?)
+		ON DUPLICATE KEY UPDATE role = VALUES(role)`, blogID, username, role, invitedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AcceptInvitation accepts the pending invitation of username to a post.
+func AcceptInvitation(blogID int, username string) error {
+	res, err := db.Exec(`UPDATE blog_collaborators SET status = 'accepted', accepted_at = CURRENT_TIMESTAMP
+		WHERE blog_id = ? AND username = ? AND status = 'pending'`, blogID, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNoInvitation
+	}
+	return nil
+}
+
+// RemoveCollaborator withdraws an invitation or removes a collaborator.
+func RemoveCollaborator(blogID int, username string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM blog_collaborators WHERE blog_id = ? AND username = ?`, blogID, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetCollaborators lists the collaborators of a post in invitation order.
+func GetCollaborators(blogID int) ([]Collaborator, error) {
+	rows, err := db.Query(`SELECT blog_id, username, role, status, invited_by, created_at, accepted_at
+		FROM blog_collaborators WHERE blog_id = ? ORDER BY created_at, username`, blogID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []Collaborator{}
+	for rows.Next() {
+		var c Collaborator
+		var acceptedAt sql.NullTime
+		if err := rows.Scan(&c.BlogID, &c.Username, &c.Role, &c.Status, &c.InvitedBy, &c.CreatedAt, &acceptedAt); err != nil {
+			return nil, err
+		}
+		if acceptedAt.Valid {
+			c.AcceptedAt = &acceptedAt.Time
+		}
+		collaborators = append(collaborators, c)
+	}
+	return collaborators, rows.Err()
+}
+
+// GetInvitations lists the pending invitations of a user, newest first.
+func GetInvitations(username string) ([]Invitation, error) {
+	rows, err := db.Query(`SELECT c.blog_id, c.username, c.role, c.status, c.invited_by, c.created_at, b.title
+		FROM blog_collaborators c JOIN blogs b ON b.id = c.blog_id
+		WHERE c.username = ? AND c.status = 'pending' ORDER BY c.created_at DESC, c.blog_id DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(&inv.BlogID, &inv.Username, &inv.Role, &inv.Status, &inv.InvitedBy, &inv.CreatedAt, &inv.Title); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// attachCollaborators loads the accepted collaborators of posts and sets their
+// bylines: the author followed by the co-authors in the order they accepted.
+func attachCollaborators(blogs []Blog) error {
+	if len(blogs) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(blogs))
+	index := make(map[int][]int, len(blogs))
+	for i := range blogs {
+		ids[i] = blogs[i].ID
+		index[blogs[i].ID] = append(index[blogs[i].ID], i)
+		blogs[i].Bylines = []string{blogs[i].Author}
+		blogs[i].collaborators = map[string]string{}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := db.Query(`SELECT blog_id, username, role FROM blog_collaborators
+		WHERE status = 'accepted' AND blog_id IN (`+placeholders+`)
+		ORDER BY accepted_at, username`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var blogID int
+		var username, role string
+		if err := rows.Scan(&blogID, &username, &role); err != nil {
+			return err
+		}
+		for _, i := range index[blogID] {
+			blogs[i].collaborators[username] = role
+			if role == RoleCoAuthor {
+				blogs[i].Bylines = append(blogs[i].Bylines, username)
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// collaborationTarget loads the post in the path for the caller.
+func collaborationTarget(w http.ResponseWriter, r *http.Request) (*Blog, *JWTClaims, bool) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if blog == nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return blog, claims, true
+}
+
+// GetCollaboratorsHandler lists the collaborators of a post.
+// @Summary List collaborators
+// @Description Lists the invited and accepted collaborators of a post. Visible to the author, Admins and the post's collaborators.
+// @Tags Collaborators
+// @Produce  json
+// @Param   id  path  int  true  "Blog ID"
+// @Success 200 {array} Collaborator
+// @Failure 404 {object} map[string]string
+// @Router /blogs/{id}/collaborators [get]
+func GetCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	blog, claims, ok := collaborationTarget(w, r)
+	if !ok {
+		return
+	}
+	if roleOf(blog, claims) == "" && !canManage(blog, claims) {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+
+	collaborators, err := GetCollaborators(blog.ID)
+	if err != nil {
+		log.Printf("Failed to retrieve collaborators: %v", err)
+		http.Error(w, "Failed to retrieve collaborators", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(collaborators)
+}
+
+// InviteCollaboratorHandler invites a user to collaborate on a post.
+// @Summary Invite a collaborator
+// @Description Invites a user as co-author, editor or viewer of a post, or changes the role of an existing collaborator. The user must exist in the user management service. The invitation takes effect once accepted. Author only.
+// @Tags Collaborators
+// @Accept  json
+// @Produce  json
+// @Param   id        path  int            true  "Blog ID"
+// @Param   username  path  string         true  "Username to invite"
+// @Param   role      body  InviteRequest  true  "Role"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /blogs/{id}/collaborators/{username} [put]
+func InviteCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	blog, claims, ok := collaborationTarget(w, r)
+	if !ok {
+		return
+	}
+	if claims.Username != blog.Author {
+		http.Error(w, "Forbidden: only the author can invite collaborators", http.StatusForbidden)
+		return
+	}
+	var req InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !isCollaboratorRole(req.Role) {
+		validation.Write(w, validation.Errors{"role": "must be co-author, editor or viewer"})
+		return
+	}
+	username := r.PathValue("username")
+	if username == blog.Author {
+		http.Error(w, "The author cannot be a collaborator", http.StatusBadRequest)
+		return
+	}
+	exists, err := userExists(r.Context(), username)
+	if err != nil {
+		log.Printf("Failed to look up user: %v", err)
+		http.Error(w, "Failed to look up user", http.StatusBadGateway)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = InviteCollaborator(blog.ID, username, req.Role, claims.Username)
+	if err == ErrCollaboratorLimit {
+		http.Error(w, fmt.Sprintf("A post can have at most %d collaborators", maxCollaborators), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Failed to invite collaborator: %v", err)
+		http.Error(w, "Failed to invite collaborator", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Invitation sent to " + username,
+	})
+}
+
+// RemoveCollaboratorHandler removes a collaborator from a post.
+// @Summary Remove a collaborator
+// @Description Withdraws an invitation or removes a collaborator. The author may remove anyone; collaborators may remove themselves, which also declines an invitation.
+// @Tags Collaborators
+// @Produce  json
+// @Param   id        path  int     true  "Blog ID"
+// @Param   username  path  string  true  "Collaborator username"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /blogs/{id}/collaborators/{username} [delete]
+func RemoveCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	blog, claims, ok := collaborationTarget(w, r)
+	if !ok {
+		return
+	}
+	username := r.PathValue("username")
+	if claims.Username != blog.Author && claims.Username != username {
+		http.Error(w, "Forbidden: only the author can remove other collaborators", http.StatusForbidden)
+		return
+	}
+
+	removed, err := RemoveCollaborator(blog.ID, username)
+	if err != nil {
+		log.Printf("Failed to remove collaborator: %v", err)
+		http.Error(w, "Failed to remove collaborator", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Collaborator not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Collaborator removed",
+	})
+}
+
+// GetInvitationsHandler lists the caller's pending invitations.
+// @Summary List collaboration invitations
+// @Description Lists the pending invitations of the authenticated user to collaborate on posts
+// @Tags Collaborators
+// @Produce  json
+// @Success 200 {array} Invitation
+// @Failure 401 {object} map[string]string
+// @Router /invitations [get]
+func GetInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	invitations, err := GetInvitations(claims.Username)
+	if err != nil {
+		log.Printf("Failed to retrieve invitations: %v", err)
+		http.Error(w, "Failed to retrieve invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// AcceptInvitationHandler accepts an invitation to collaborate on a post.
+// @Summary Accept a collaboration invitation
+// @Description Accepts the caller's pending invitation to a post; the role takes effect immediately. Decline with DELETE /blogs/{id}/collaborators/{username}.
+// @Tags Collaborators
+// @Produce  json
+// @Param   id  path  int  true  "Blog ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /invitations/{id}/accept [post]
+func AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	err = AcceptInvitation(blogID, claims.Username)
+	if err == ErrNoInvitation {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to accept invitation: %v", err)
+		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Invitation accepted",
+	})
+}
@@ -0,0 +1,258 @@
+package blog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shared/validation"
+)
+
+// maxCommentLength is the longest comment, in characters.
+const maxCommentLength = 5000
+
+// commentLimiter limits how many comments each user may write, so that a
+// single account cannot flood posts or inflate their trending score.
+var commentLimiter = newAttemptLimiter(10, 10*time.Minute)
+
+// Comment is a reader's comment on a post.
+type Comment struct {
+	ID        int       `json:"id"`
+	BlogID    int       `json:"blog_id"`
+	Author    string    `json:"author"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommentRequest carries the text of a new comment.
+type CommentRequest struct {
+	Content string `json:"content"`
+}
+
+// Validate checks the comment text.
+func (req CommentRequest) Validate() validation.Errors {
+	errs := validation.Errors{}
+	if strings.TrimSpace(req.Content) == "" {
+		errs["content"] = "is required"
+	} else if utf8.RuneCountInString(req.Content) > maxCommentLength {
+		errs["content"] = validation.AtMost(maxCommentLength, "characters")
+	}
+	return errs
+}
+
+// AddComment stores a comment of username on a post.
+func AddComment(blogID int, username, content string) (*Comment, error) {
+	res, err := db.Exec(`INSERT INTO blog_comments (blog_id, username, content) VALUES (?, ?, ?)`, blogID, username, content)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	c := &Comment{ID: int(id), BlogID: blogID, Author: username, Content: content}
+	err = db.QueryRow(`SELECT created_at FROM blog_comments WHERE id = ?`, id).Scan(&c.CreatedAt)
+	return c, err
+}
+
+// GetComments lists the comments on a post, oldest first.
+func GetComments(blogID int) ([]Comment, error) {
+	rows, err := db.Query(`SELECT id, blog_id, username, content, created_at FROM blog_comments WHERE blog_id = ? ORDER BY id`, blogID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.BlogID, &c.Author, &c.Content, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// getComment retrieves one comment on a post, or nil if it does not exist.
+func getComment(blogID, id int) (*Comment, error) {
+	var c Comment
+	err := db.QueryRow(`SELECT id, blog_id, username, content, created_at FROM blog_comments WHERE id = ? AND blog_id = ?`, id, blogID).
+		Scan(&c.ID, &c.BlogID, &c.Author, &c.Content, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// DeleteComment removes a comment.
+func DeleteComment(id int) error {
+	_, err := db.Exec(`DELETE FROM blog_comments WHERE id = ?`, id)
+	return err
+}
+
+// commentTarget loads the post a comment request refers to, answering 404 for
+// posts the caller may not read.
+func commentTarget(w http.ResponseWriter, r *http.Request) (*Blog, bool) {
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return nil, false
+	}
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return nil, false
+	}
+	if blog == nil || !visibleTo(blog, optionalClaims(r), grantFromRequest(r, blog.ID)) || blog.Locked {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return nil, false
+	}
+	return blog, true
+}
+
+// GetCommentsHandler lists the comments on a post.
+// @Summary List comments
+// @Description Lists the comments on a post, oldest first. Password-protected posts must be unlocked first.
+// @Tags Comments
+// @Produce  json
+// @Param   id  path  int  true  "Blog ID"
+// @Success 200 {array} Comment
+// @Failure 404 {object} map[string]string
+// @Router /blogs/{id}/comments [get]
+func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	blog, ok := commentTarget(w, r)
+	if !ok {
+		return
+	}
+	comments, err := GetComments(blog.ID)
+	if err != nil {
+		log.Printf("Failed to fetch comments: %v", err)
+		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(comments)
+}
+
+// AddCommentHandler adds the caller's comment to a post.
+// @Summary Comment on a blog post
+// @Description Adds a comment of the logged-in user to a post they can read. Comments count towards trending.
+// @Description Each user may write 10 comments in 10 minutes.
+// @Tags Comments
+// @Accept  json
+// @Produce  json
+// @Param   id       path  int             true  "Blog ID"
+// @Param   comment  body  CommentRequest  true  "Comment"
+// @Success 201 {object} Comment
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /blogs/{id}/comments [post]
+func AddCommentHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if wait := commentLimiter.retryAfter(claims.Username); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many comments, try again later", http.StatusTooManyRequests)
+		return
+	}
+	blog, ok := commentTarget(w, r)
+	if !ok {
+		return
+	}
+	var req CommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
+
+	comment, err := AddComment(blog.ID, claims.Username, req.Content)
+	if err != nil {
+		log.Printf("Failed to add comment: %v", err)
+		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
+		return
+	}
+	commentLimiter.add(claims.Username)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
+
+// DeleteCommentHandler removes a comment.
+// @Summary Delete a comment
+// @Description Removes a comment. Allowed for the comment's author, even once they can no longer read the post,
+// @Description and for the post's author and Admins.
+// @Tags Comments
+// @Produce  json
+// @Param   id       path  int  true  "Blog ID"
+// @Param   comment  path  int  true  "Comment ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /blogs/{id}/comments/{comment} [delete]
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("comment"))
+	if err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	comment, err := getComment(blogID, id)
+	if err != nil {
+		log.Printf("Failed to fetch comment: %v", err)
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	if comment == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	// Authors may remove their own comments even after the post was hidden
+	// or locked; anyone else has to be able to manage the post
+	if comment.Author != claims.Username {
+		blog, ok := commentTarget(w, r)
+		if !ok {
+			return
+		}
+		if !canManage(blog, claims) {
+			http.Error(w, "Forbidden: You can only delete your own comments", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := DeleteComment(comment.ID); err != nil {
+		log.Printf("Failed to delete comment: %v", err)
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Comment deleted successfully",
+	})
+}
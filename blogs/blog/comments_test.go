@@ -0,0 +1,88 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommentRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"text", "Nice post!", ""},
+		{"longest", strings.Repeat("é", maxCommentLength), ""},
+		{"empty", "", "is required"},
+		{"only whitespace", " \n\t", "is required"},
+		{"too long", strings.Repeat("é", maxCommentLength+1), "must be at most 5000 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (CommentRequest{Content: tt.content}).Validate()["content"]; got != tt.want {
+				t.Errorf("Validate() content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	l := newAttemptLimiter(2, time.Minute)
+	l.add("alice")
+	if wait := l.retryAfter("alice"); wait != 0 {
+		t.Fatalf("retryAfter() after 1 of 2 attempts = %v, want 0", wait)
+	}
+	l.add("alice")
+	if wait := l.retryAfter("alice"); wait <= 0 || wait > time.Minute {
+		t.Fatalf("retryAfter() after 2 of 2 attempts = %v, want up to a minute", wait)
+	}
+	if wait := l.retryAfter("bob"); wait != 0 {
+		t.Errorf("retryAfter() of another key = %v, want 0", wait)
+	}
+
+	// A window that has ended starts over
+	l.entries["alice"].ends = time.Now().Add(-time.Second)
+	if wait := l.retryAfter("alice"); wait != 0 {
+		t.Errorf("retryAfter() after the window = %v, want 0", wait)
+	}
+	l.add("alice")
+	if got := l.entries["alice"].attempts; got != 1 {
+		t.Errorf("attempts in the new window = %d, want 1", got)
+	}
+}
+
+func TestAddCommentHandlerRateLimit(t *testing.T) {
+	t.Cleanup(func() { commentLimiter = newAttemptLimiter(10, 10*time.Minute) })
+	commentLimiter = newAttemptLimiter(1, time.Minute)
+	commentLimiter.add("alice")
+
+	tests := []struct {
+		name       string
+		claims     *JWTClaims
+		wantStatus int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"over the limit", &JWTClaims{Username: "alice", Role: "Reader"}, http.StatusTooManyRequests},
+		{"admins are limited too", &JWTClaims{Username: "alice", Role: "Admin"}, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/blogs/1/comments", strings.NewReader(`{"content":"Hi"}`))
+			r.SetPathValue("id", "1")
+			if tt.claims != nil {
+				r = r.WithContext(ContextWithClaims(r.Context(), tt.claims))
+			}
+			w := httptest.NewRecorder()
+			AddCommentHandler(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After header is missing")
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// EraseAuthor removes everything the blog service keeps about a user whose
+// account was erased, in one transaction. Their posts and series are
+// reattributed to AnonymousAuthor when anonymize is set and deleted otherwise.
+// Their bookmarks, reading lists, reactions, comments and collaborations are
+// deleted, and so are their uploads, except those used by remaining posts,
+// which are reattributed. It returns the number of posts anonymised or deleted.
+func EraseAuthor(ctx context.Context, username string, anonymize bool) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var posts int64
+	if anonymize {
+		if posts, err = anonymizeBlogs(tx, username); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE series SET author = ? WHERE author = ?`, AnonymousAuthor, username); err != nil {
+			return 0, err
+		}
+	} else {
+		if posts, err = deleteBlogs(tx, `author = ?`, username); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM series WHERE author = ?`, username); err != nil {
+			return 0, err
+		}
+	}
+
+	statements := []string{
+		// Take the user's reactions out of the counters before removing them
+		`UPDATE blog_reaction_counts c JOIN blog_reactions r ON r.blog_id = c.blog_id AND r.reaction = c.reaction
+            SET c.count = c.count - 1 WHERE r.username = ? AND c.count > 0`,
+		`DELETE FROM blog_reactions WHERE username = ?`,
+		`DELETE FROM blog_comments WHERE username = ?`,
+		`DELETE FROM bookmarks WHERE username = ?`,
+		`DELETE FROM reading_lists WHERE username = ?`,
+		`DELETE FROM blog_collaborators WHERE username = ?`,
+	}
+	for _, query := range statements {
+		if _, err := tx.ExecContext(ctx, query, username); err != nil {
+			return 0, err
+		}
+	}
+	for _, query := range []string{
+		`UPDATE blog_collaborators SET invited_by = ? WHERE invited_by = ?`,
+		`UPDATE webhooks SET created_by = ? WHERE created_by = ?`,
+		`UPDATE media m SET m.author = ? WHERE m.author = ? AND EXISTS (SELECT 1 FROM blog_media bm WHERE bm.media_id = m.id)`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, AnonymousAuthor, username); err != nil {
+			return 0, err
+		}
+	}
+
+	media, err := eraseMedia(tx, username)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// Files cannot be part of the transaction; a failure leaves them behind
+	// but no longer reachable.
+	for _, m := range media {
+		if err := m.deleteBlobs(ctx); err != nil {
+			log.Printf("Failed to delete files of media %d: %v", m.ID, err)
+		}
+	}
+	return posts, nil
+}
+
+// anonymizeBlogs reattributes the posts of username to AnonymousAuthor in tx
+// and records a BlogUpdated event for each.
+func anonymizeBlogs(tx *sql.Tx, username string) (int64, error) {
+	rows, err := tx.Query(`SELECT `+blogColumns+` FROM blogs WHERE author = ? FOR UPDATE`, username)
+	if err != nil {
+		return 0, err
+	}
+	var blogs []Blog
+	for rows.Next() {
+		b, err := scanBlog(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		blogs = append(blogs, *b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := attachTags(blogs); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`UPDATE blogs SET author = ? WHERE author = ?`, AnonymousAuthor, username)
+	if err != nil {
+		return 0, err
+	}
+	for i := range blogs {
+		blogs[i].Author = AnonymousAuthor
+		if err := recordSaved(tx, &blogs[i], false, blogs[i].Visibility); err != nil {
+			return 0, err
+		}
+	}
+	return res.RowsAffected()
+}
+
+// eraseMedia deletes the uploads still owned by username in tx and returns
+// them so their files can be removed once tx commits.
+func eraseMedia(tx *sql.Tx, username string) ([]*Media, error) {
+	rows, err := tx.Query(`SELECT `+mediaColumns+` FROM media WHERE author = ? FOR UPDATE`, username)
+	if err != nil {
+		return nil, err
+	}
+	var media []*Media
+	for rows.Next() {
+		m, err := scanMedia(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		media = append(media, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM media WHERE author = ?`, username); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
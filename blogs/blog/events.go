@@ -0,0 +1,103 @@
+package blog
+
+import (
+	"database/sql"
+	"time"
+
+	"shared/events"
+)
+
+// Blog event types.
+const (
+	EventBlogCreated     = "BlogCreated"
+	EventBlogUpdated     = "BlogUpdated"
+	EventBlogPublished   = "BlogPublished"   // The post became public
+	EventBlogUnpublished = "BlogUnpublished" // A public post was made unlisted, private or password-protected
+	EventBlogDeleted     = "BlogDeleted"
+)
+
+// EventTypes lists the event types emitted by the blog service.
+var EventTypes = []string{EventBlogCreated, EventBlogUpdated, EventBlogPublished, EventBlogUnpublished, EventBlogDeleted}
+
+// BlogEvent is the data of blog events. Deleted posts only carry their ID,
+// slug, title and author.
+type BlogEvent struct {
+	ID         int        `json:"id"`
+	Slug       string     `json:"slug"`
+	Title      string     `json:"title"`
+	Author     string     `json:"author"`
+	Visibility string     `json:"visibility,omitempty"`
+	Excerpt    string     `json:"excerpt,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	URL        string     `json:"url"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// newBlogEvent returns the event data of a post. Private and
+// password-protected posts only carry their summary as excerpt, since an
+// automatic excerpt would reveal the content to every subscriber.
+func newBlogEvent(b *Blog) BlogEvent {
+	excerpt := b.Excerpt
+	if b.Visibility == VisibilityPrivate || b.Visibility == VisibilityPassword {
+		excerpt = b.Summary
+	}
+	return BlogEvent{ID: b.ID, Slug: b.Slug, Title: b.Title, Author: b.Author, Visibility: b.Visibility, Excerpt: excerpt,
+		Tags: b.Tags, URL: BlogURL(b), CreatedAt: &b.CreatedAt, UpdatedAt: &b.UpdatedAt}
+}
+
+// recordSaved records the events of a post that was created, or updated from
+// oldVisibility, in tx. It reads the post's dates as stored.
+func recordSaved(tx *sql.Tx, b *Blog, created bool, oldVisibility string) error {
+	if err := tx.QueryRow(`SELECT created_at, updated_at FROM blogs WHERE id = ?`, b.ID).Scan(&b.CreatedAt, &b.UpdatedAt); err != nil {
+		return err
+	}
+	data := newBlogEvent(b)
+	eventType := EventBlogUpdated
+	if created {
+		eventType = EventBlogCreated
+	}
+	if err := events.Record(tx, eventType, data); err != nil {
+		return err
+	}
+	switch {
+	case b.Visibility == VisibilityPublic && oldVisibility != VisibilityPublic:
+		return events.Record(tx, EventBlogPublished, data)
+	case b.Visibility != VisibilityPublic && oldVisibility == VisibilityPublic:
+		return events.Record(tx, EventBlogUnpublished, data)
+	}
+	return nil
+}
+
+// deleteBlogs deletes the posts matching a condition in tx and records a
+// BlogDeleted event for each.
+func deleteBlogs(tx *sql.Tx, condition string, args ...interface{}) (int64, error) {
+	rows, err := tx.Query(`SELECT id, COALESCE(slug, ''), title, author FROM blogs WHERE `+condition+` FOR UPDATE`, args...)
+	if err != nil {
+		return 0, err
+	}
+	var deleted []BlogEvent
+	for rows.Next() {
+		var b Blog
+		if err := rows.Scan(&b.ID, &b.Slug, &b.Title, &b.Author); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deleted = append(deleted, BlogEvent{ID: b.ID, Slug: b.Slug, Title: b.Title, Author: b.Author, URL: BlogURL(&b)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM blogs WHERE `+condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	for _, data := range deleted {
+		if err := events.Record(tx, EventBlogDeleted, data); err != nil {
+			return 0, err
+		}
+	}
+	return res.RowsAffected()
+}
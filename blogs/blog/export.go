@@ -0,0 +1,370 @@
+package blog
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/storage"
+)
+
+// Export formats.
+const (
+	ExportJSONLines = "jsonl"
+	ExportMarkdown  = "markdown"
+	ExportHTML      = "html"
+)
+
+// ExportFormats lists the supported export formats.
+var ExportFormats = []string{ExportJSONLines, ExportMarkdown, ExportHTML}
+
+// exportBatchSize is the number of posts read from the database at a time.
+const exportBatchSize = 100
+
+// eachBlog calls fn with every post, or every post of author, in ID order,
+// reading them in batches so exports of any size use little memory.
+func eachBlog(ctx context.Context, author string, fn func(*Blog) error) error {
+	lastID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		query := `SELECT ` + blogColumns + ` FROM blogs WHERE id > ?`
+		args := []interface{}{lastID}
+		if author != "" {
+			query += ` AND author = ?`
+			args = append(args, author)
+		}
+		blogs, err := queryBlogs(query+` ORDER BY id LIMIT ?`, append(args, exportBatchSize)...)
+		if err != nil {
+			return err
+		}
+		for i := range blogs {
+			if err := fn(&blogs[i]); err != nil {
+				return err
+			}
+		}
+		if len(blogs) < exportBatchSize {
+			return nil
+		}
+		lastID = blogs[len(blogs)-1].ID
+	}
+}
+
+// ExportContentType returns the media type and file name extension of an export format.
+func ExportContentType(format string) (string, string) {
+	if format == ExportJSONLines {
+		return "application/x-ndjson", ".jsonl"
+	}
+	return "application/zip", ".zip"
+}
+
+// ExportPosts writes every post, or every post of author, to w in format.
+func ExportPosts(ctx context.Context, w io.Writer, format, author string) error {
+	switch format {
+	case ExportJSONLines:
+		encoder := json.NewEncoder(w)
+		return eachBlog(ctx, author, func(b *Blog) error {
+			return encoder.Encode(b)
+		})
+	case ExportMarkdown:
+		archive := zip.NewWriter(w)
+		err := eachBlog(ctx, author, func(b *Blog) error {
+			f, err := archive.CreateHeader(&zip.FileHeader{Name: "posts/" + exportFileName(b) + ".md", Method: zip.Deflate, Modified: b.UpdatedAt})
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(f, markdownExport(b))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return archive.Close()
+	case ExportHTML:
+		return exportSite(ctx, w, author)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// exportFileName names a post's file after its date and slug.
+func exportFileName(b *Blog) string {
+	slug := b.Slug
+	if slug == "" {
+		slug = strconv.Itoa(b.ID)
+	}
+	return b.CreatedAt.UTC().Format(time.DateOnly) + "-" + slug
+}
+
+// markdownExport renders a post as a Markdown file with YAML front matter
+// that the importer reads back. Strings are double-quoted YAML scalars.
+// Password-protected posts are exported as private, since their password
+// cannot be recovered from its hash.
+func markdownExport(b *Blog) string {
+	var out strings.Builder
+	field := func(key, value string) {
+		out.WriteString(key + ": " + strconv.Quote(value) + "\n")
+	}
+	out.WriteString("---\n")
+	field("title", b.Title)
+	field("slug", b.Slug)
+	field("date", b.CreatedAt.UTC().Format(time.RFC3339))
+	field("lastmod", b.UpdatedAt.UTC().Format(time.RFC3339))
+	field("author", b.Author)
+	if len(b.Tags) > 0 {
+		quoted := make([]string, len(b.Tags))
+		for i, t := range b.Tags {
+			quoted[i] = strconv.Quote(t)
+		}
+		out.WriteString("tags: [" + strings.Join(quoted, ", ") + "]\n")
+	}
+	if b.Summary != "" {
+		field("summary", b.Summary)
+	}
+	if b.Visibility == VisibilityPassword {
+		out.WriteString("# Password-protected when exported; set a new password after importing\n")
+		field("visibility", VisibilityPrivate)
+	} else {
+		field("visibility", b.Visibility)
+	}
+	field("content_format", b.ContentFormat)
+	out.WriteString("---\n\n")
+	out.WriteString(b.Content)
+	if !strings.HasSuffix(b.Content, "\n") {
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+var sitePostTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="../style.css">
+</head>
+<body>
+<nav><a href="../index.html">All posts</a></nav>
+<article>
+<h1>{{.Title}}</h1>
+<p class="meta">By {{range $i, $a := .Bylines}}{{if $i}}, {{end}}{{$a}}{{end}} · <time datetime="{{.CreatedAt.Format "2006-01-02"}}">{{.CreatedAt.Format "January 2, 2006"}}</time> · {{.ReadingTime}} min read</p>
+{{.HTML}}
+{{if .Tags}}<p class="tags">{{range .Tags}}<span>#{{.}}</span> {{end}}</p>{{end}}
+</article>
+</body>
+</html>
+`))
+
+var siteIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul class="posts">
+{{range .Posts}}<li><a href="posts/{{.File}}.html">{{.Title}}</a> <span class="meta">{{.Date.Format "2006-01-02"}} · {{.Author}}</span>{{if .Excerpt}}<p>{{.Excerpt}}</p>{{end}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+const siteStylesheet = `body{max-width:42rem;margin:2rem auto;padding:0 1rem;font:18px/1.6 Georgia,serif;color:#222}
+a{color:#0645ad}.meta{color:#666;font-size:.9em}.posts{list-style:none;padding:0}.posts li{margin:1.5rem 0}
+.tags span{margin-right:.5em;color:#666}img{max-width:100%;height:auto}pre{overflow:auto;background:#f6f6f6;padding:1em}
+`
+
+// siteMediaPattern matches the upload URLs in sanitized post HTML.
+var siteMediaPattern = regexp.MustCompile(`"/media/(\d+)/(original|\d+)"`)
+
+// siteMedia copies the uploads a static site links to into its archive, once each.
+type siteMedia struct {
+	ctx     context.Context
+	archive *zip.Writer
+	files   map[string]string // Upload URL to its path in the archive, "" when it is gone
+}
+
+// rewrite bundles the uploads linked from a post page's HTML and points the
+// links at the copies. It must not be called while a file of the archive is
+// being written.
+func (s *siteMedia) rewrite(content string) (string, error) {
+	var err error
+	content = siteMediaPattern.ReplaceAllStringFunc(content, func(match string) string {
+		if err != nil {
+			return match
+		}
+		file, ok := s.files[match]
+		if !ok {
+			m := siteMediaPattern.FindStringSubmatch(match)
+			id, _ := strconv.Atoi(m[1])
+			file, err = s.bundle(id, m[2])
+			s.files[match] = file
+		}
+		if file == "" {
+			return match
+		}
+		// Post pages live in posts/
+		return `"../` + file + `"`
+	})
+	return content, err
+}
+
+// bundle writes a variant of an upload into the archive and returns its path
+// there, or "" if the upload no longer exists.
+func (s *siteMedia) bundle(id int, variant string) (string, error) {
+	m, err := GetMediaByID(id)
+	if err != nil || m == nil || !m.HasVariant(variant) {
+		return "", err
+	}
+	key := m.blobKey(variant)
+	blob, _, err := blobStore.Get(s.ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+	f, err := s.archive.CreateHeader(&zip.FileHeader{Name: key, Method: zip.Store, Modified: m.CreatedAt})
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, blob); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+type siteIndexEntry struct {
+	File, Title, Author, Excerpt string
+	Date                         time.Time
+}
+
+// exportSite writes a ZIP of a static HTML site: one page per public or
+// unlisted post and an index of the public ones, newest first. Private and
+// password-protected posts are left out. Uploads the pages show or link to
+// are copied under media/ and linked relatively, so the site works offline.
+func exportSite(ctx context.Context, w io.Writer, author string) error {
+	archive := zip.NewWriter(w)
+	media := &siteMedia{ctx: ctx, archive: archive, files: map[string]string{}}
+	var index []siteIndexEntry
+
+	err := eachBlog(ctx, author, func(b *Blog) error {
+		if b.Visibility != VisibilityPublic && b.Visibility != VisibilityUnlisted {
+			return nil
+		}
+		// ContentHTML was sanitized when the post was saved
+		content, err := media.rewrite(b.ContentHTML)
+		if err != nil {
+			return err
+		}
+		name := exportFileName(b)
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: "posts/" + name + ".html", Method: zip.Deflate, Modified: b.UpdatedAt})
+		if err != nil {
+			return err
+		}
+		err = sitePostTemplate.Execute(f, struct {
+			*Blog
+			HTML template.HTML
+		}{b, template.HTML(content)})
+		if err != nil {
+			return err
+		}
+		if b.Visibility == VisibilityPublic {
+			index = append(index, siteIndexEntry{File: name, Title: b.Title, Author: b.Author, Excerpt: b.Excerpt, Date: b.CreatedAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Posts were read in ID order; the index lists the newest first
+	for i, j := 0, len(index)-1; i < j; i, j = i+1, j-1 {
+		index[i], index[j] = index[j], index[i]
+	}
+	title := "Blog"
+	if author != "" {
+		title = "Posts by " + author
+	}
+	f, err := archive.Create("index.html")
+	if err != nil {
+		return err
+	}
+	if err := siteIndexTemplate.Execute(f, struct {
+		Title string
+		Posts []siteIndexEntry
+	}{title, index}); err != nil {
+		return err
+	}
+	f, err = archive.Create("style.css")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, siteStylesheet); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// ExportPostsHandler streams an export of all posts, or of one author's.
+// @Summary Export blog posts
+// @Description Streams posts as JSON Lines (jsonl), a ZIP of Markdown files with YAML front matter that /admin/import reads back (markdown), or a ZIP of a static HTML site with an index, a page per public or unlisted post and the uploads they use (html). Admins export every post or one author's; other users export their own.
+// @Tags Blog
+// @Produce  application/x-ndjson
+// @Produce  application/zip
+// @Param   format  path   string  true   "jsonl, markdown or html"
+// @Param   author  query  string  false  "Only this author's posts"
+// @Success 200 {file} file
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /blogs/export/{format} [get]
+func ExportPostsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	format := r.PathValue("format")
+	if !slices.Contains(ExportFormats, format) {
+		http.Error(w, "Unknown export format: use jsonl, markdown or html", http.StatusNotFound)
+		return
+	}
+	author := r.URL.Query().Get("author")
+	if claims.Role != "Admin" {
+		if author != "" && author != claims.Username {
+			http.Error(w, "Forbidden: you can only export your own posts", http.StatusForbidden)
+			return
+		}
+		author = claims.Username
+	}
+
+	contentType, ext := ExportContentType(format)
+	name := "blog-export"
+	if author != "" {
+		name = author + "-export"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+"-"+format+ext+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the download short
+	if err := ExportPosts(r.Context(), w, format, author); err != nil {
+		log.Printf("Failed to export blogs: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package blog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feed item limits.
+const (
+	defaultFeedItems = 20
+	maxFeedItems     = 50
+)
+
+// siteURL is the public base URL used for links and IDs in feeds.
+var siteURL = "http://localhost:8001"
+
+// SetSiteURL sets the public base URL of the blog.
+func SetSiteURL(url string) {
+	siteURL = strings.TrimSuffix(url, "/")
+}
+
+// GetRecentBlogs retrieves the newest public posts, optionally limited to one
+// author and/or one tag.
+func GetRecentBlogs(author, tag string, limit int) ([]Blog, error) {
+	query := `SELECT ` + blogColumns + ` FROM blogs WHERE ` + publicCondition
+	var args []interface{}
+	if author != "" {
+		query += ` AND author = ?`
+		args = append(args, author)
+	}
+	if tag != "" {
+		query += ` AND id IN (SELECT blog_id FROM blog_tags WHERE tag = ?)`
+		args = append(args, tag)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+	return queryBlogs(query, args...)
+}
+
+// BlogURL returns the public URL of a post, its slug permalink when it has one.
+func BlogURL(b *Blog) string {
+	if b.Slug != "" {
+		return siteURL + "/blogs/by-slug/" + b.Slug
+	}
+	return siteURL + "/blogs/" + strconv.Itoa(b.ID)
+}
+
+// blogGUID returns a stable tag URI identifying a post in feeds. It does not
+// change when the post's URL does.
+func blogGUID(b *Blog) string {
+	host := siteURL
+	if u, err := url.Parse(siteURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	return fmt.Sprintf("tag:%s,%s:blogs/%d", host, b.CreatedAt.UTC().Format("2006-01-02"), b.ID)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Link       atomLink       `xml:"link"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// ServeFeed serves the RSS or Atom feed of all posts, one author's posts or one tag's posts.
+// @Summary Get a feed of recent blog posts
+// @Description RSS 2.0 (rss.xml) or Atom (atom.xml) feed of the newest posts. Supports conditional GET with ETag and Last-Modified.
+// @Tags Feeds
+// @Produce  xml
+// @Param   format  path   string  true   "rss.xml or atom.xml"
+// @Param   limit   query  int     false  "Number of items, at most 50 (default 20)"
+// @Success 200 {string} string "Feed XML"
+// @Success 304 {string} string "Not Modified"
+// @Failure 404 {string} string "Feed not found"
+// @Failure 500 {string} string "Failed to build feed"
+// @Router /feeds/{format} [get]
+func ServeFeed(w http.ResponseWriter, r *http.Request) {
+	serveFeed(w, r, "", "")
+}
+
+// ServeAuthorFeed serves the feed of one author's posts.
+// @Summary Get an author's feed
+// @Description RSS 2.0 (rss.xml) or Atom (atom.xml) feed of an author's newest posts
+// @Tags Feeds
+// @Produce  xml
+// @Param   username  path   string  true   "Author username"
+// @Param   format    path   string  true   "rss.xml or atom.xml"
+// @Param   limit     query  int     false  "Number of items, at most 50 (default 20)"
+// @Success 200 {string} string "Feed XML"
+// @Success 304 {string} string "Not Modified"
+// @Failure 404 {string} string "Feed not found"
+// @Router /feeds/authors/{username}/{format} [get]
+func ServeAuthorFeed(w http.ResponseWriter, r *http.Request) {
+	serveFeed(w, r, r.PathValue("username"), "")
+}
+
+// ServeTagFeed serves the feed of one tag's posts.
+// @Summary Get a tag's feed
+// @Description RSS 2.0 (rss.xml) or Atom (atom.xml) feed of the newest posts with a tag
+// @Tags Feeds
+// @Produce  xml
+// @Param   tag     path   string  true   "Tag"
+// @Param   format  path   string  true   "rss.xml or atom.xml"
+// @Param   limit   query  int     false  "Number of items, at most 50 (default 20)"
+// @Success 200 {string} string "Feed XML"
+// @Success 304 {string} string "Not Modified"
+// @Failure 404 {string} string "Feed not found"
+// @Router /feeds/tags/{tag}/{format} [get]
+func ServeTagFeed(w http.ResponseWriter, r *http.Request) {
+	serveFeed(w, r, "", NormalizeTag(r.PathValue("tag")))
+}
+
+func serveFeed(w http.ResponseWriter, r *http.Request, author, tag string) {
+	format := r.PathValue("format")
+	if format != "rss.xml" && format != "atom.xml" {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultFeedItems
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxFeedItems)
+	}
+
+	blogs, err := GetRecentBlogs(author, tag, limit)
+	if err != nil {
+		log.Printf("Failed to build feed: %v", err)
+		http.Error(w, "Failed to build feed", http.StatusInternalServerError)
+		return
+	}
+
+	// The feed changes whenever a post in it is added, edited or removed
+	var updated time.Time
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s|%d|", r.URL.Path, limit)
+	for _, b := range blogs {
+		if b.UpdatedAt.After(updated) {
+			updated = b.UpdatedAt
+		}
+		fmt.Fprintf(hash, "%d:%d,", b.ID, b.UpdatedAt.Unix())
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+	updated = updated.UTC().Truncate(time.Second)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if !updated.IsZero() {
+		w.Header().Set("Last-Modified", updated.Format(http.TimeFormat))
+	}
+	if notModified(r, etag, updated) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	title, path := "All posts", "/feeds/"
+	switch {
+	case author != "":
+		title, path = "Posts by "+author, "/feeds/authors/"+url.PathEscape(author)+"/"
+	case tag != "":
+		title, path = "Posts tagged "+tag, "/feeds/tags/"+url.PathEscape(tag)+"/"
+	}
+	if updated.IsZero() {
+		updated = time.Now().UTC().Truncate(time.Second)
+	}
+
+	var feed interface{}
+	if format == "rss.xml" {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		feed = buildRSS(blogs, title, siteURL+path+format, updated)
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		feed = buildAtom(blogs, title, siteURL+path+format, updated)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("Failed to write feed: %v", err)
+	}
+}
+
+// notModified evaluates the conditional GET headers. If-None-Match takes
+// precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func buildRSS(blogs []Blog, title, selfURL string, updated time.Time) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          siteURL,
+			Description:   title,
+			AtomLink:      rssAtomLink{Href: selfURL, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: updated.Format(time.RFC1123Z),
+		},
+	}
+	for i := range blogs {
+		b := &blogs[i]
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       b.Title,
+			Link:        BlogURL(b),
+			GUID:        rssGUID{IsPermaLink: false, Value: blogGUID(b)},
+			PubDate:     b.CreatedAt.UTC().Format(time.RFC1123Z),
+			Categories:  b.Tags,
+			Description: b.ContentHTML,
+		})
+	}
+	return feed
+}
+
+func buildAtom(blogs []Blog, title, selfURL string, updated time.Time) atomFeed {
+	feed := atomFeed{
+		Title:   title,
+		ID:      selfURL,
+		Updated: updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: siteURL, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for i := range blogs {
+		b := &blogs[i]
+		entry := atomEntry{
+			Title:     b.Title,
+			ID:        blogGUID(b),
+			Updated:   b.UpdatedAt.UTC().Format(time.RFC3339),
+			Published: b.CreatedAt.UTC().Format(time.RFC3339),
+			Link:      atomLink{Href: BlogURL(b), Rel: "alternate", Type: "text/html"},
+			Author:    atomAuthor{Name: b.Author},
+			Content:   atomContent{Type: "html", Body: b.ContentHTML},
+		}
+		for _, tag := range b.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
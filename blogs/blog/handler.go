@@ -7,14 +7,21 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+
+	"shared/validation"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // JWTClaims defines the claims structure for the JWT token.
 type JWTClaims struct {
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Scopes   []string `json:"scopes,omitempty"` // Only set for personal access tokens
+	// PersonalAccessToken is true when the request was authenticated with a personal access token
+	PersonalAccessToken bool `json:"-"`
 	jwt.RegisteredClaims
 }
 
@@ -42,6 +49,7 @@ func ClaimsFromContext(ctx context.Context) (*JWTClaims, error) {
 // @Param   blog  body  Blog  true  "Blog Post"
 // @Success 201 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 409 {string} string "Slug already in use"
 // @Failure 500 {object} map[string]string
 // @Router /blogs/create [post]
 func CreateBlog(w http.ResponseWriter, r *http.Request) {
@@ -51,6 +59,13 @@ func CreateBlog(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
+	// Only imports may set the dates of a post
+	blog.CreatedAt, blog.UpdatedAt = time.Time{}, time.Time{}
+
+	if errs := blog.Validate(); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
 
 	claims, err := ClaimsFromContext(r.Context())
 	if err != nil {
@@ -58,8 +73,20 @@ func CreateBlog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	blog.Author = claims.Username
+	if blog.Visibility == VisibilityPassword && blog.Password == "" {
+		validation.Write(w, validation.Errors{"password": "is required for password-protected posts"})
+		return
+	}
+
+	if !checkMediaRefs(w, blog.Author, blog.Content) {
+		return
+	}
 
 	err = blog.CreateBlog()
+	if errors.Is(err, ErrSlugTaken) {
+		http.Error(w, "Slug already in use", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to create blog: %v", err)
 		http.Error(w, "Failed to create blog", http.StatusInternalServerError)
@@ -69,24 +96,195 @@ func CreateBlog(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Blog post created successfully",
+		"slug":    blog.Slug,
 	})
 }
 
 // GetBlogs retrieves all blog posts.
 // @Summary Get all blog posts
-// @Description Retrieves all blog posts from the database
+// @Description Retrieves the public and password-protected posts plus the caller's own posts; Admins see every post. Password-protected posts of other authors are returned locked, without content.
 // @Tags Blog
 // @Produce  json
+// @Param   fields  query  string  false  "Comma-separated fields to return, e.g. title,slug,excerpt,reading_time; the id is always included"
 // @Success 200 {array} Blog
+// @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /blogs [get]
 func GetBlogs(w http.ResponseWriter, r *http.Request) {
-	blogs, err := GetAllBlogs()
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fields, unknown := parseFields(r.URL.Query().Get("fields"))
+	if len(unknown) > 0 {
+		http.Error(w, "Unknown fields: "+strings.Join(unknown, ", "), http.StatusBadRequest)
+		return
+	}
+
+	var blogs []Blog
+	if claims.Role == "Admin" {
+		blogs, err = GetAllBlogs()
+	} else {
+		blogs, err = GetListedBlogs(claims.Username)
+	}
 	if err != nil {
 		log.Printf("Failed to retrieve blogs: %v", err)
 		http.Error(w, "Failed to retrieve blogs", http.StatusInternalServerError)
 		return
 	}
+	lockListed(blogs, claims)
+
+	if fields != nil {
+		sparse, err := sparseBlogs(blogs, fields)
+		if err != nil {
+			log.Printf("Failed to select blog fields: %v", err)
+			http.Error(w, "Failed to retrieve blogs", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sparse)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(blogs)
+}
+
+// GetBlog retrieves a single blog post.
+// @Summary Get a blog post
+// @Description Retrieves one blog post, including its sanitized HTML rendering. Private posts are only shown to their author and Admins; password-protected posts are returned locked, without content, unless a grant from /blogs/{id}/unlock is sent.
+// @Tags Blog
+// @Produce  json
+// @Param   id            path    int     true   "Blog ID"
+// @Param   X-Post-Grant  header  string  false  "Unlock grant of a password-protected post"
+// @Success 200 {object} Blog
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /blogs/{id} [get]
+func GetBlog(w http.ResponseWriter, r *http.Request) {
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return
+	}
+	if blog == nil || !visibleTo(blog, optionalClaims(r), grantFromRequest(r, blog.ID)) {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	if err := attachViewerReactions(blog, optionalClaims(r)); err != nil {
+		log.Printf("Failed to load reactions: %v", err)
+	}
+	if err := attachSeriesNav(blog, optionalClaims(r)); err != nil {
+		log.Printf("Failed to load series: %v", err)
+	}
+	if !blog.Locked {
+		RecordView(r, blog, optionalClaims(r))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(blog)
+}
+
+// GetBlogBySlugHandler retrieves a single blog post by its slug. Former slugs
+// redirect permanently to the current one.
+// @Summary Get a blog post by slug
+// @Description Retrieves one blog post by its slug. A slug the post had before is answered with a 301 redirect to its current slug, if the caller may see the post.
+// @Tags Blog
+// @Produce  json
+// @Param   slug  path  string  true  "Blog slug"
+// @Success 200 {object} Blog
+// @Success 301 {string} string "Moved Permanently"
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /blogs/by-slug/{slug} [get]
+func GetBlogBySlugHandler(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	blog, err := GetBlogBySlug(slug)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return
+	}
+	if blog == nil {
+		moved, err := GetRedirectedBlog(slug)
+		if err != nil {
+			log.Printf("Failed to look up slug redirect: %v", err)
+			http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+			return
+		}
+		// Only reveal where a post moved to callers who may see it
+		if moved == nil || !visibleTo(moved, optionalClaims(r), grantFromRequest(r, moved.ID)) {
+			http.Error(w, "Blog post not found", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, "/blogs/by-slug/"+moved.Slug, http.StatusMovedPermanently)
+		return
+	}
+	if !visibleTo(blog, optionalClaims(r), grantFromRequest(r, blog.ID)) {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	if err := attachViewerReactions(blog, optionalClaims(r)); err != nil {
+		log.Printf("Failed to load reactions: %v", err)
+	}
+	if err := attachSeriesNav(blog, optionalClaims(r)); err != nil {
+		log.Printf("Failed to load series: %v", err)
+	}
+	if !blog.Locked {
+		RecordView(r, blog, optionalClaims(r))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(blog)
+}
+
+// GetAuthorBlogs lists the published posts of one author.
+// @Summary Get an author's blog posts
+// @Description Lists the published blog posts of an author, newest first
+// @Tags Blog
+// @Produce  json
+// @Param   username  path  string  true  "Author username"
+// @Success 200 {array} Blog
+// @Failure 500 {object} map[string]string
+// @Router /authors/{username}/blogs [get]
+func GetAuthorBlogs(w http.ResponseWriter, r *http.Request) {
+	blogs, err := GetPublishedBlogsByAuthor(r.PathValue("username"))
+	if err != nil {
+		log.Printf("Failed to retrieve author blogs: %v", err)
+		http.Error(w, "Failed to retrieve blogs", http.StatusInternalServerError)
+		return
+	}
+	lockListed(blogs, optionalClaims(r))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(blogs)
+}
+
+// GetTagBlogs lists the published posts with a tag.
+// @Summary Get the blog posts with a tag
+// @Description Lists the published blog posts carrying a tag, newest first
+// @Tags Blog
+// @Produce  json
+// @Param   tag  path  string  true  "Tag"
+// @Success 200 {array} Blog
+// @Failure 500 {object} map[string]string
+// @Router /tags/{tag}/blogs [get]
+func GetTagBlogs(w http.ResponseWriter, r *http.Request) {
+	blogs, err := GetBlogsByTag(NormalizeTag(r.PathValue("tag")))
+	if err != nil {
+		log.Printf("Failed to retrieve tag blogs: %v", err)
+		http.Error(w, "Failed to retrieve blogs", http.StatusInternalServerError)
+		return
+	}
+	lockListed(blogs, optionalClaims(r))
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(blogs)
@@ -102,6 +300,7 @@ func GetBlogs(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
 // @Failure 403 {object} map[string]string
+// @Failure 409 {string} string "Slug already in use"
 // @Failure 500 {object} map[string]string
 // @Router /blogs/update [put]
 func UpdateBlog(w http.ResponseWriter, r *http.Request) {
@@ -112,6 +311,11 @@ func UpdateBlog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if errs := blog.Validate(); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
+
 	existingBlog, err := GetBlogByID(blog.ID)
 	if err != nil || existingBlog == nil {
 		http.Error(w, "Blog post not found", http.StatusNotFound)
@@ -119,12 +323,46 @@ func UpdateBlog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	claims, err := ClaimsFromContext(r.Context())
-	if err != nil || claims.Username != existingBlog.Author {
+	if err != nil {
 		http.Error(w, "Forbidden: You can only update your own blog post", http.StatusForbidden)
 		return
 	}
+	role := roleOf(existingBlog, claims)
+	if role != "author" && role != RoleCoAuthor && role != RoleEditor {
+		http.Error(w, "Forbidden: You can only update your own blog post", http.StatusForbidden)
+		return
+	}
+	if role == RoleEditor && ((blog.Visibility != "" && blog.Visibility != existingBlog.Visibility) || blog.Password != "") {
+		http.Error(w, "Forbidden: editors cannot change the visibility or password of a post", http.StatusForbidden)
+		return
+	}
+
+	if blog.ContentFormat == "" {
+		blog.ContentFormat = existingBlog.ContentFormat
+	}
+	if blog.Tags == nil {
+		blog.Tags = existingBlog.Tags
+	}
+	if blog.Visibility == "" {
+		blog.Visibility = existingBlog.Visibility
+	}
+	if blog.Visibility == VisibilityPassword && blog.Password == "" {
+		if existingBlog.PasswordHash == "" {
+			validation.Write(w, validation.Errors{"password": "is required for password-protected posts"})
+			return
+		}
+		blog.PasswordHash = existingBlog.PasswordHash
+	}
+
+	if !checkMediaRefs(w, existingBlog.Author, blog.Content) {
+		return
+	}
 
 	err = blog.UpdateBlog()
+	if errors.Is(err, ErrSlugTaken) {
+		http.Error(w, "Slug already in use", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update blog: %v", err)
 		http.Error(w, "Failed to update blog", http.StatusInternalServerError)
@@ -134,12 +372,13 @@ func UpdateBlog(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Blog post updated successfully",
+		"slug":    blog.Slug,
 	})
 }
 
 // DeleteBlog handles the deletion of a blog post.
 // @Summary Delete a blog post
-// @Description Allows a writer to delete their blog post
+// @Description Allows a writer or a co-author to delete a blog post
 // @Tags Blog
 // @Produce  json
 // @Param   id  query  int  true  "Blog ID"
@@ -167,7 +406,12 @@ func DeleteBlog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	claims, err := ClaimsFromContext(r.Context())
-	if err != nil || claims.Username != blog.Author {
+	if err != nil {
+		http.Error(w, "Forbidden: You can only delete your own blog post", http.StatusForbidden)
+		return
+	}
+	role := roleOf(blog, claims)
+	if role != "author" && role != RoleCoAuthor {
 		http.Error(w, "Forbidden: You can only delete your own blog post", http.StatusForbidden)
 		return
 	}
@@ -185,3 +429,95 @@ func DeleteBlog(w http.ResponseWriter, r *http.Request) {
 		"message": "Blog post deleted successfully",
 	})
 }
+
+// ExportBlogs returns every blog post written by the logged-in user.
+// @Summary Export own blog posts
+// @Description Returns all blog posts of the authenticated user, used for account data exports
+// @Tags Blog
+// @Produce  json
+// @Success 200 {array} Blog
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /blogs/export [get]
+func ExportBlogs(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	blogs, err := GetBlogsByAuthor(claims.Username)
+	if err != nil {
+		log.Printf("Failed to export blogs: %v", err)
+		http.Error(w, "Failed to export blogs", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(blogs)
+}
+
+// RemoveAuthorBlogs anonymises or deletes all posts of an author whose account was erased,
+// together with the rest of their data.
+// @Summary Remove an author's blog posts
+// @Description Anonymises (policy=anonymize) or deletes (policy=delete) all blog posts and series of a user, and deletes their bookmarks, reading lists, reactions, collaborations and the uploads no remaining post uses. Admin only; called by the user management service when an account is erased.
+// @Tags Admin
+// @Produce  json
+// @Param   username  query  string  true  "Author username"
+// @Param   policy    query  string  true  "anonymize or delete"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/authors/blogs [delete]
+func RemoveAuthorBlogs(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil || claims.Role != "Admin" {
+		http.Error(w, "Access denied: Admins only", http.StatusForbidden)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "Missing username", http.StatusBadRequest)
+		return
+	}
+
+	policy := r.URL.Query().Get("policy")
+	if policy != "anonymize" && policy != "delete" {
+		http.Error(w, "Invalid policy: must be anonymize or delete", http.StatusBadRequest)
+		return
+	}
+	affected, err := EraseAuthor(r.Context(), username, policy == "anonymize")
+	if err != nil {
+		log.Printf("Failed to remove blogs of %s: %v", username, err)
+		http.Error(w, "Failed to remove blogs", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Removed %d blog posts of erased user: %s", affected, username)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Blog posts removed successfully",
+		"affected": affected,
+	})
+}
+
+// checkMediaRefs responds with a validation error and returns false if content
+// references media that is not in the author's media library.
+func checkMediaRefs(w http.ResponseWriter, author, content string) bool {
+	unknown, err := UnknownMediaRefs(author, MediaIDsInContent(content))
+	if err != nil {
+		log.Printf("Failed to check media references: %v", err)
+		http.Error(w, "Failed to check media references", http.StatusInternalServerError)
+		return false
+	}
+	if len(unknown) > 0 {
+		validation.Write(w, validation.Errors{
+			"content": "references unknown media " + joinInts(unknown),
+		})
+		return false
+	}
+	return true
+}
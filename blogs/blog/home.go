@@ -0,0 +1,212 @@
+package blog
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Home feed settings.
+const (
+	followingTTL       = time.Minute // How long a fetched follow list is used
+	maxCachedFollowing = 10000       // Follow lists cached before expired ones are evicted
+	defaultHomeLimit   = 20
+	maxHomeLimit       = 50
+)
+
+// followingCache holds the follow lists fetched from the user management service.
+var followingCache = struct {
+	sync.Mutex
+	entries map[string]cachedFollowing
+}{entries: map[string]cachedFollowing{}}
+
+type cachedFollowing struct {
+	authors []string
+	expires time.Time
+}
+
+// HomeFeed is one page of the home feed.
+type HomeFeed struct {
+	Blogs      []Blog `json:"blogs"`
+	NextCursor string `json:"next_cursor,omitempty"` // Pass as ?cursor= to get the next page; empty on the last page
+}
+
+// fetchFollowing asks the user management service whom username follows.
+func fetchFollowing(ctx context.Context, username string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userServiceURL+"/users/"+url.PathEscape(username)+"/following", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := userServiceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return []string{}, nil
+	default:
+		return nil, fmt.Errorf("follow list request returned %s", resp.Status)
+	}
+
+	var follows []struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&follows); err != nil {
+		return nil, err
+	}
+	authors := make([]string, len(follows))
+	for i, f := range follows {
+		authors[i] = f.Username
+	}
+	return authors, nil
+}
+
+// followedAuthors returns the cached follow list of username, fetching it when
+// it is missing or stale. A stale list is used if the user service is down.
+func followedAuthors(ctx context.Context, username string) ([]string, error) {
+	now := time.Now()
+	followingCache.Lock()
+	cached, ok := followingCache.entries[username]
+	followingCache.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.authors, nil
+	}
+
+	authors, err := fetchFollowing(ctx, username)
+	if err != nil {
+		if ok {
+			log.Printf("Using stale follow list of %s: %v", username, err)
+			return cached.authors, nil
+		}
+		return nil, err
+	}
+
+	followingCache.Lock()
+	if len(followingCache.entries) >= maxCachedFollowing {
+		for name, e := range followingCache.entries {
+			if now.After(e.expires) {
+				delete(followingCache.entries, name)
+			}
+		}
+	}
+	followingCache.entries[username] = cachedFollowing{authors: authors, expires: now.Add(followingTTL)}
+	followingCache.Unlock()
+	return authors, nil
+}
+
+// encodeFeedCursor returns an opaque cursor pointing after b.
+func encodeFeedCursor(b Blog) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(b.CreatedAt.Unix(), 10) + ":" + strconv.Itoa(b.ID)))
+}
+
+// decodeFeedCursor reverses encodeFeedCursor.
+func decodeFeedCursor(cursor string) (time.Time, int, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	sec, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return time.Time{}, 0, false
+	}
+	unix, err1 := strconv.ParseInt(sec, 10, 64)
+	blogID, err2 := strconv.Atoi(id)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, 0, false
+	}
+	return time.Unix(unix, 0).UTC(), blogID, true
+}
+
+// GetHomeFeed returns the newest listed posts of authors, older than the
+// cursor position if one is given, newest first.
+func GetHomeFeed(authors []string, before *time.Time, beforeID, limit int) ([]Blog, error) {
+	if len(authors) == 0 {
+		return []Blog{}, nil
+	}
+	args := make([]interface{}, 0, len(authors)+4)
+	for _, a := range authors {
+		args = append(args, a)
+	}
+	query := `SELECT ` + blogColumns + ` FROM blogs WHERE ` + listedCondition +
+		` AND author IN (` + strings.TrimSuffix(strings.Repeat("?,", len(authors)), ",") + `)`
+	if before != nil {
+		query += ` AND (created_at < ? OR (created_at = ? AND id < ?))`
+		args = append(args, *before, *before, beforeID)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	return queryBlogs(query, append(args, limit)...)
+}
+
+// GetFeed returns the logged-in user's home feed.
+// @Summary Get the home feed
+// @Description Recently published posts of the authors the caller follows, newest first. Follow lists come from the user management service and are cached for a minute. Pass next_cursor as ?cursor= to get the following page.
+// @Tags Blog
+// @Produce  json
+// @Param   cursor  query  string  false  "Cursor returned by the previous page"
+// @Param   limit   query  int     false  "Posts per page, at most 50 (default 20)"
+// @Success 200 {object} HomeFeed
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /feed [get]
+func GetFeed(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit := defaultHomeLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHomeLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var before *time.Time
+	var beforeID int
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		t, id, ok := decodeFeedCursor(v)
+		if !ok {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		before, beforeID = &t, id
+	}
+
+	authors, err := followedAuthors(r.Context(), claims.Username)
+	if err != nil {
+		log.Printf("Failed to fetch follow list: %v", err)
+		http.Error(w, "Failed to fetch followed authors", http.StatusBadGateway)
+		return
+	}
+
+	// One extra post tells whether there is a next page
+	blogs, err := GetHomeFeed(authors, before, beforeID, limit+1)
+	if err != nil {
+		log.Printf("Failed to retrieve home feed: %v", err)
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+	feed := HomeFeed{Blogs: blogs}
+	if len(blogs) > limit {
+		feed.Blogs = blogs[:limit]
+		feed.NextCursor = encodeFeedCursor(feed.Blogs[limit-1])
+	}
+	lockListed(feed.Blogs, claims)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(feed)
+}
@@ -0,0 +1,601 @@
+package blog
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Import item states.
+const (
+	ImportReady    = "ready" // Would be imported; dry runs stop here
+	ImportImported = "imported"
+	ImportSkipped  = "skipped"
+	ImportFailed   = "failed"
+)
+
+// ImportOptions control how posts are imported.
+type ImportOptions struct {
+	DryRun        bool
+	AuthorMap     map[string]string // Source author to existing username
+	DefaultAuthor string            // Username for authors that are neither mapped nor existing users
+}
+
+// ImportPost is a post read from an import source.
+type ImportPost struct {
+	Source string // File name or WordPress post ID
+	Author string // Author name in the source
+	Blog   Blog
+	Err    error // Why the source could not be read, if it could not
+}
+
+// ImportItem reports what happened to one source post.
+type ImportItem struct {
+	Source       string    `json:"source"`
+	Title        string    `json:"title"`
+	SourceAuthor string    `json:"source_author"`
+	Author       string    `json:"author,omitempty"`
+	Slug         string    `json:"slug,omitempty"`
+	Visibility   string    `json:"visibility,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	BlogID       int       `json:"blog_id,omitempty"`
+	Status       string    `json:"status"` // ready, imported, skipped or failed
+	Message      string    `json:"message,omitempty"`
+}
+
+// ImportReport summarises an import or dry run.
+type ImportReport struct {
+	DryRun          bool         `json:"dry_run"`
+	Total           int          `json:"total"`
+	Ready           int          `json:"ready"`
+	Imported        int          `json:"imported"`
+	Skipped         int          `json:"skipped"`
+	Failed          int          `json:"failed"`
+	UnmappedAuthors []string     `json:"unmapped_authors"`
+	Items           []ImportItem `json:"items"`
+}
+
+// wxrDateLayout is the date format of WordPress exports.
+const wxrDateLayout = "2006-01-02 15:04:05"
+
+type wxrDocument struct {
+	Channel struct {
+		Items []wxrItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type wxrItem struct {
+	Title    string       `xml:"title"`
+	Creator  string       `xml:"creator"`
+	Encoded  []wxrEncoded `xml:"encoded"`
+	PostID   string       `xml:"post_id"`
+	Date     string       `xml:"post_date"`
+	DateGMT  string       `xml:"post_date_gmt"`
+	Modified string       `xml:"post_modified_gmt"`
+	Name     string       `xml:"post_name"`
+	Status   string       `xml:"status"`
+	Type     string       `xml:"post_type"`
+	Password string       `xml:"post_password"`
+	Category []struct {
+		Domain   string `xml:"domain,attr"`
+		Nicename string `xml:"nicename,attr"`
+		Value    string `xml:",chardata"`
+	} `xml:"category"`
+}
+
+// wxrEncoded is a content:encoded or excerpt:encoded element; they differ only by namespace.
+type wxrEncoded struct {
+	XMLName xml.Name `xml:"encoded"`
+	Value   string   `xml:",chardata"`
+}
+
+// parseWXRDate reads a WordPress date, which is empty or all zeros for unpublished posts.
+func parseWXRDate(value string) (time.Time, bool) {
+	t, err := time.Parse(wxrDateLayout, strings.TrimSpace(value))
+	if err != nil || t.Year() < 1970 {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// wxrSiteOffset returns how far the site's local time, used by post_date, is
+// ahead of UTC, judging by the first item that has both dates.
+func wxrSiteOffset(items []wxrItem) (time.Duration, bool) {
+	for _, item := range items {
+		local, ok := parseWXRDate(item.Date)
+		if !ok {
+			continue
+		}
+		if gmt, ok := parseWXRDate(item.DateGMT); ok {
+			return local.Sub(gmt), true
+		}
+	}
+	return 0, false
+}
+
+// ParseWXR reads the posts of a WordPress WXR export. Pages, attachments and
+// other post types are left out. Drafts become private posts. Dates are taken
+// from post_date_gmt; posts without one, such as drafts, have their local
+// post_date converted with the site's offset when another post reveals it.
+func ParseWXR(r io.Reader) ([]ImportPost, error) {
+	var doc wxrDocument
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid WXR file: %w", err)
+	}
+	offset, offsetKnown := wxrSiteOffset(doc.Channel.Items)
+
+	var posts []ImportPost
+	for _, item := range doc.Channel.Items {
+		if item.Type != "" && item.Type != "post" {
+			continue
+		}
+		b := Blog{
+			Title:         strings.TrimSpace(item.Title),
+			Slug:          item.Name,
+			ContentFormat: FormatHTML,
+			Password:      item.Password,
+		}
+		for _, e := range item.Encoded {
+			if strings.Contains(e.XMLName.Space, "excerpt") {
+				b.Summary = strings.TrimSpace(e.Value)
+			} else {
+				b.Content = e.Value
+			}
+		}
+
+		switch {
+		case item.Password != "":
+			b.Visibility = VisibilityPassword
+		case item.Status == "publish" || item.Status == "":
+			b.Visibility = VisibilityPublic
+		default:
+			b.Visibility = VisibilityPrivate
+		}
+
+		if t, ok := parseWXRDate(item.DateGMT); ok {
+			b.CreatedAt = t
+		} else if t, ok := parseWXRDate(item.Date); ok && offsetKnown {
+			b.CreatedAt = t.Add(-offset)
+		}
+		if t, ok := parseWXRDate(item.Modified); ok {
+			b.UpdatedAt = t
+		}
+
+		for _, c := range item.Category {
+			if c.Domain == "post_tag" || c.Domain == "category" {
+				if c.Value == "Uncategorized" {
+					continue
+				}
+				b.Tags = append(b.Tags, c.Value)
+			}
+		}
+
+		source := "wxr:" + item.PostID
+		if item.PostID == "" {
+			source = "wxr:" + b.Title
+		}
+		posts = append(posts, ImportPost{Source: source, Author: item.Creator, Blog: b})
+	}
+	return posts, nil
+}
+
+// Limits on the uncompressed size of the Markdown files read by
+// ParseMarkdownFiles, so that a small ZIP archive cannot expand without bound.
+const (
+	maxMarkdownFileSize  = 8 << 20
+	maxMarkdownTotalSize = 256 << 20
+)
+
+// ParseMarkdownFiles reads every .md and .markdown file below fsys. Each file
+// may start with YAML front matter between --- lines; title, slug (else the
+// file name), date, lastmod/updated, author, tags, categories,
+// summary/description, draft, visibility and content_format are understood.
+// Files over maxMarkdownFileSize fail on their own; past maxMarkdownTotalSize
+// the whole import fails.
+func ParseMarkdownFiles(fsys fs.FS) ([]ImportPost, error) {
+	var posts []ImportPost
+	var total int64
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(path.Ext(name))
+		if d.IsDir() || (ext != ".md" && ext != ".markdown") || strings.HasPrefix(path.Base(name), ".") {
+			return nil
+		}
+		data, err := readMarkdownFile(fsys, name)
+		total += int64(len(data))
+		if total > maxMarkdownTotalSize {
+			return fmt.Errorf("the Markdown files exceed %d MB in total", maxMarkdownTotalSize>>20)
+		}
+		var post ImportPost
+		if err == nil {
+			post, err = parseMarkdownPost(name, string(data))
+		}
+		if err != nil {
+			post = ImportPost{Blog: Blog{Title: path.Base(name)}, Err: err}
+		}
+		post.Source = name
+		posts = append(posts, post)
+		return nil
+	})
+	return posts, err
+}
+
+// readMarkdownFile reads a file of at most maxMarkdownFileSize bytes. Larger
+// files are read one byte past the limit and rejected.
+func readMarkdownFile(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxMarkdownFileSize+1))
+	if err != nil {
+		return data, err
+	}
+	if len(data) > maxMarkdownFileSize {
+		return data, fmt.Errorf("file is larger than %d MB", maxMarkdownFileSize>>20)
+	}
+	return data, nil
+}
+
+// jekyllDatePrefix matches the date Jekyll puts in front of post file names.
+var jekyllDatePrefix = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-`)
+
+// slugFromFilename derives a slug from the name of a Markdown file, as static
+// site generators do: the file name without its extension or Jekyll date, or
+// the directory name of a Hugo page bundle's index file.
+func slugFromFilename(name string) string {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	if base == "index" || base == "_index" {
+		base = path.Base(path.Dir(name))
+		if base == "." || base == "/" {
+			return ""
+		}
+	}
+	return jekyllDatePrefix.ReplaceAllString(base, "")
+}
+
+// parseMarkdownPost splits the Markdown file name into its front matter and
+// body. Posts without a slug take theirs from the file name.
+func parseMarkdownPost(name, text string) (ImportPost, error) {
+	text = strings.TrimPrefix(strings.ReplaceAll(text, "\r\n", "\n"), "\uFEFF")
+	b := Blog{ContentFormat: FormatMarkdown, Visibility: VisibilityPublic, Slug: slugFromFilename(name)}
+	post := ImportPost{}
+
+	if !strings.HasPrefix(text, "---\n") {
+		b.Content = text
+		post.Blog = b
+		return post, nil
+	}
+	end := strings.Index(text[4:], "\n---")
+	if end < 0 {
+		return post, errors.New("front matter is not closed")
+	}
+	meta, err := parseFrontMatter(text[4 : 4+end])
+	if err != nil {
+		return post, err
+	}
+	body := text[4+end+4:]
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	} else {
+		body = ""
+	}
+	b.Content = strings.TrimLeft(body, "\n")
+
+	// Keys are read in order so that posts with both tags and categories, or
+	// several summary keys, always come out the same
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := meta[key]
+		switch key {
+		case "title":
+			b.Title = value.scalar()
+		case "slug":
+			if slug := value.scalar(); slug != "" {
+				b.Slug = slug
+			}
+		case "date":
+			if b.CreatedAt, err = parseFrontMatterDate(value.scalar()); err != nil {
+				return post, fmt.Errorf("invalid date: %w", err)
+			}
+		case "lastmod", "updated":
+			if b.UpdatedAt, err = parseFrontMatterDate(value.scalar()); err != nil {
+				return post, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		case "author":
+			post.Author = value.scalar()
+		case "authors":
+			if len(value.list) > 0 {
+				post.Author = value.list[0]
+			} else {
+				post.Author = value.scalar()
+			}
+		case "tags", "categories":
+			b.Tags = append(b.Tags, value.items()...)
+		case "summary", "description", "excerpt":
+			b.Summary = value.scalar()
+		case "draft":
+			if value.scalar() == "true" {
+				b.Visibility = VisibilityPrivate
+			}
+		case "visibility":
+			b.Visibility = value.scalar()
+		case "content_format":
+			b.ContentFormat = value.scalar()
+		}
+	}
+	post.Blog = b
+	return post, nil
+}
+
+// frontMatterValue is a scalar or a list.
+type frontMatterValue struct {
+	value string
+	list  []string
+	isSeq bool
+}
+
+func (v frontMatterValue) scalar() string {
+	if v.isSeq {
+		return strings.Join(v.list, ", ")
+	}
+	return v.value
+}
+
+// items returns a list, or a comma-separated scalar split into one.
+func (v frontMatterValue) items() []string {
+	if v.isSeq {
+		return v.list
+	}
+	var items []string
+	for _, s := range strings.Split(v.value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// parseFrontMatter reads the subset of YAML used in front matter: top-level
+// "key: value" pairs whose values are plain or quoted scalars, [inline, lists]
+// or block lists of "- item" lines. Nested mappings are ignored.
+func parseFrontMatter(text string) (map[string]frontMatterValue, error) {
+	meta := map[string]frontMatterValue{}
+	var current string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "-") {
+			// Block list item of the previous key; anything else nested is skipped
+			if current != "" && strings.HasPrefix(trimmed, "- ") {
+				v := meta[current]
+				v.isSeq = true
+				v.list = append(v.list, unquoteYAML(strings.TrimSpace(trimmed[2:])))
+				meta[current] = v
+			}
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("front matter line %d: expected key: value", n)
+		}
+		current = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if i := strings.Index(value, " #"); i >= 0 && !strings.HasPrefix(value, `"`) && !strings.HasPrefix(value, "'") {
+			value = strings.TrimSpace(value[:i])
+		}
+		switch {
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			var list []string
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = unquoteYAML(strings.TrimSpace(item)); item != "" {
+					list = append(list, item)
+				}
+			}
+			meta[current] = frontMatterValue{list: list, isSeq: true}
+		default:
+			meta[current] = frontMatterValue{value: unquoteYAML(value)}
+		}
+	}
+	return meta, scanner.Err()
+}
+
+// unquoteYAML removes the quotes around a YAML scalar.
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+		return s[1 : len(s)-1]
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// frontMatterDateLayouts are the date formats accepted in front matter.
+var frontMatterDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+func parseFrontMatterDate(value string) (time.Time, error) {
+	for _, layout := range frontMatterDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", value)
+}
+
+// authorResolver maps source authors to existing users.
+type authorResolver struct {
+	ctx      context.Context
+	opts     ImportOptions
+	exists   map[string]bool
+	unmapped map[string]bool
+}
+
+// resolve returns the username a source author's posts are imported under, or "".
+func (a *authorResolver) resolve(source string) (string, error) {
+	candidates := []string{}
+	if mapped, ok := a.opts.AuthorMap[source]; ok {
+		candidates = append(candidates, mapped)
+	} else {
+		if source != "" {
+			candidates = append(candidates, source)
+		}
+		if a.opts.DefaultAuthor != "" {
+			candidates = append(candidates, a.opts.DefaultAuthor)
+		}
+	}
+	for _, username := range candidates {
+		exists, ok := a.exists[username]
+		if !ok {
+			var err error
+			if exists, err = userExists(a.ctx, username); err != nil {
+				return "", err
+			}
+			a.exists[username] = exists
+		}
+		if exists {
+			return username, nil
+		}
+	}
+	a.unmapped[source] = true
+	return "", nil
+}
+
+// userExists asks the user management service whether a user has a public profile.
+func userExists(ctx context.Context, username string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userServiceURL+"/users/"+url.PathEscape(username), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := userServiceClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("user lookup returned %s", resp.Status)
+	}
+}
+
+// ImportPosts validates posts, maps their authors and, unless this is a dry
+// run, stores them with their original dates. Each post is stored on its own,
+// so a failure does not undo the posts imported before it. Posts whose slug is
+// already taken are skipped, so an import can be run again after fixing errors.
+func ImportPosts(ctx context.Context, posts []ImportPost, opts ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{DryRun: opts.DryRun, Total: len(posts), UnmappedAuthors: []string{}, Items: make([]ImportItem, 0, len(posts))}
+	authors := &authorResolver{ctx: ctx, opts: opts, exists: map[string]bool{}, unmapped: map[string]bool{}}
+
+	for _, p := range posts {
+		b := p.Blog
+		item := ImportItem{Source: p.Source, Title: b.Title, SourceAuthor: p.Author, Slug: b.Slug, Visibility: b.Visibility, CreatedAt: b.CreatedAt}
+		item.Status, item.Message = importPost(ctx, &b, p, authors, opts.DryRun)
+		item.Author, item.Slug, item.BlogID = b.Author, b.Slug, b.ID
+
+		switch item.Status {
+		case ImportReady:
+			report.Ready++
+		case ImportImported:
+			report.Imported++
+		case ImportSkipped:
+			report.Skipped++
+		case ImportFailed:
+			report.Failed++
+		}
+		report.Items = append(report.Items, item)
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+	}
+
+	for source := range authors.unmapped {
+		report.UnmappedAuthors = append(report.UnmappedAuthors, source)
+	}
+	sort.Strings(report.UnmappedAuthors)
+	return report, nil
+}
+
+// importPost checks and, outside dry runs, stores one post. It returns the item status and a message.
+func importPost(ctx context.Context, b *Blog, p ImportPost, authors *authorResolver, dryRun bool) (string, string) {
+	if p.Err != nil {
+		return ImportFailed, p.Err.Error()
+	}
+	if errs := b.Validate(); len(errs) > 0 {
+		return ImportFailed, errs.Error()
+	}
+	if b.Visibility == VisibilityPassword && b.Password == "" {
+		return ImportFailed, "password-protected post without a password"
+	}
+
+	author, err := authors.resolve(p.Author)
+	if err != nil {
+		return ImportFailed, "author lookup failed: " + err.Error()
+	}
+	if author == "" {
+		return ImportFailed, fmt.Sprintf("author %q is not mapped to an existing user", p.Author)
+	}
+	b.Author = author
+
+	if b.Slug != "" {
+		b.Slug = Slugify(b.Slug)
+		var taken bool
+		if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM blogs WHERE slug = ?) OR EXISTS (SELECT 1 FROM blog_slug_redirects WHERE slug = ?)`,
+			b.Slug, b.Slug).Scan(&taken); err != nil {
+			return ImportFailed, err.Error()
+		}
+		if taken {
+			return ImportSkipped, "slug already in use, probably imported before"
+		}
+	}
+	if dryRun {
+		return ImportReady, ""
+	}
+
+	if err := b.CreateBlog(); err != nil {
+		if errors.Is(err, ErrSlugTaken) {
+			return ImportSkipped, "slug already in use, probably imported before"
+		}
+		return ImportFailed, err.Error()
+	}
+	return ImportImported, ""
+}
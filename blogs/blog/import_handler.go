@@ -0,0 +1,101 @@
+package blog
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxImportSize is the largest WXR file or Markdown ZIP archive accepted over HTTP.
+const maxImportSize = 64 << 20
+
+// ParseAuthorMap reads "source=username" pairs separated by commas.
+func ParseAuthorMap(value string) (map[string]string, error) {
+	authors := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		source, username, found := strings.Cut(pair, "=")
+		source, username = strings.TrimSpace(source), strings.TrimSpace(username)
+		if !found || source == "" || username == "" {
+			return nil, errors.New("author map entries must look like source=username")
+		}
+		authors[source] = username
+	}
+	return authors, nil
+}
+
+// ImportBlogs imports posts from a WordPress export or a ZIP archive of Markdown files.
+// @Summary Import blog posts
+// @Description Imports a WordPress WXR export (format=wxr, XML body) or a ZIP archive of Markdown files with YAML front matter (format=markdown, ZIP body). Source authors are mapped with author_map, then to the user of the same name, then to default_author. Original dates are kept. With dry_run=true nothing is stored and the report shows what would happen. Admin only.
+// @Tags Admin
+// @Accept  application/xml
+// @Accept  application/zip
+// @Produce  json
+// @Param   format          query  string  true   "wxr or markdown"
+// @Param   dry_run         query  bool    false  "Only report what would be imported"
+// @Param   author_map      query  string  false  "Comma-separated source=username pairs"
+// @Param   default_author  query  string  false  "Username for unmapped authors"
+// @Success 200 {object} ImportReport
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Router /admin/import [post]
+func ImportBlogs(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil || claims.Role != "Admin" {
+		http.Error(w, "Forbidden: Admins only", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := ImportOptions{DryRun: query.Get("dry_run") == "true", DefaultAuthor: query.Get("default_author")}
+	if opts.AuthorMap, err = ParseAuthorMap(query.Get("author_map")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Import file too large: the limit is 64 MB", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to read import file", http.StatusBadRequest)
+		return
+	}
+
+	var posts []ImportPost
+	switch query.Get("format") {
+	case "wxr":
+		posts, err = ParseWXR(bytes.NewReader(data))
+	case "markdown":
+		var archive *zip.Reader
+		if archive, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			posts, err = ParseMarkdownFiles(archive)
+		}
+	default:
+		http.Error(w, "Invalid format: use wxr or markdown", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read import file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := ImportPosts(r.Context(), posts, opts)
+	if err != nil {
+		log.Printf("Import interrupted: %v", err)
+	}
+	log.Printf("Import by %s: %d imported, %d ready, %d skipped, %d failed (dry run: %t)",
+		claims.Username, report.Imported, report.Ready, report.Skipped, report.Failed, report.DryRun)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
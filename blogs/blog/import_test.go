@@ -0,0 +1,294 @@
+package blog
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testWXR = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:excerpt="http://wordpress.org/export/1.2/excerpt/" xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<item>
+		<title>Hello World</title>
+		<dc:creator>admin</dc:creator>
+		<content:encoded><![CDATA[<p>Welcome</p>]]></content:encoded>
+		<excerpt:encoded><![CDATA[ A greeting ]]></excerpt:encoded>
+		<wp:post_id>1</wp:post_id>
+		<wp:post_date>2024-05-01 14:00:00</wp:post_date>
+		<wp:post_date_gmt>2024-05-01 12:00:00</wp:post_date_gmt>
+		<wp:post_modified_gmt>2024-05-02 08:30:00</wp:post_modified_gmt>
+		<wp:post_name>hello-world</wp:post_name>
+		<wp:status>publish</wp:status>
+		<wp:post_type>post</wp:post_type>
+		<category domain="category" nicename="uncategorized"><![CDATA[Uncategorized]]></category>
+		<category domain="post_tag" nicename="go"><![CDATA[Go]]></category>
+	</item>
+	<item>
+		<title>Work in progress</title>
+		<dc:creator>editor</dc:creator>
+		<content:encoded><![CDATA[Draft]]></content:encoded>
+		<wp:post_id>2</wp:post_id>
+		<wp:post_date>2024-06-01 09:00:00</wp:post_date>
+		<wp:post_date_gmt>0000-00-00 00:00:00</wp:post_date_gmt>
+		<wp:status>draft</wp:status>
+		<wp:post_type>post</wp:post_type>
+	</item>
+	<item>
+		<title>Members only</title>
+		<dc:creator>admin</dc:creator>
+		<wp:post_id>3</wp:post_id>
+		<wp:post_date_gmt>2024-07-01 00:00:00</wp:post_date_gmt>
+		<wp:status>publish</wp:status>
+		<wp:post_type>post</wp:post_type>
+		<wp:post_password>open sesame</wp:post_password>
+	</item>
+	<item>
+		<title>About</title>
+		<wp:post_id>4</wp:post_id>
+		<wp:post_type>page</wp:post_type>
+	</item>
+</channel>
+</rss>`
+
+func TestParseWXR(t *testing.T) {
+	posts, err := ParseWXR(strings.NewReader(testWXR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImportPost{
+		{
+			Source: "wxr:1",
+			Author: "admin",
+			Blog: Blog{
+				Title: "Hello World", Slug: "hello-world", Content: "<p>Welcome</p>", ContentFormat: FormatHTML,
+				Summary: "A greeting", Visibility: VisibilityPublic, Tags: []string{"Go"},
+				CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+				UpdatedAt: time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			// The draft's local date is converted with the site offset of the first post
+			Source: "wxr:2",
+			Author: "editor",
+			Blog: Blog{
+				Title: "Work in progress", Content: "Draft", ContentFormat: FormatHTML, Visibility: VisibilityPrivate,
+				CreatedAt: time.Date(2024, 6, 1, 7, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			Source: "wxr:3",
+			Author: "admin",
+			Blog: Blog{
+				Title: "Members only", ContentFormat: FormatHTML, Visibility: VisibilityPassword, Password: "open sesame",
+				CreatedAt: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	if len(posts) != len(want) {
+		t.Fatalf("ParseWXR() returned %d posts, want %d", len(posts), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(posts[i], want[i]) {
+			t.Errorf("post %d = %+v, want %+v", i, posts[i], want[i])
+		}
+	}
+}
+
+func TestParseWXRInvalid(t *testing.T) {
+	if _, err := ParseWXR(strings.NewReader("<rss><channel>")); err == nil || !strings.Contains(err.Error(), "invalid WXR file") {
+		t.Errorf("ParseWXR() error = %v, want an invalid WXR file error", err)
+	}
+}
+
+func TestSlugFromFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"hello-world.md", "hello-world"},
+		{"posts/hello-world.markdown", "hello-world"},
+		{"_posts/2024-05-01-hello-world.md", "hello-world"},
+		{"content/posts/hello-world/index.md", "hello-world"},
+		{"content/posts/_index.md", "posts"},
+		{"index.md", ""},
+		{"2024-05-01.md", "2024-05-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slugFromFilename(tt.name); got != tt.want {
+				t.Errorf("slugFromFilename(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMarkdownPost(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		text    string
+		want    ImportPost
+		wantErr string
+	}{
+		{
+			name: "without front matter",
+			file: "notes.md",
+			text: "# Notes\n",
+			want: ImportPost{Blog: Blog{Slug: "notes", Content: "# Notes\n", ContentFormat: FormatMarkdown, Visibility: VisibilityPublic}},
+		},
+		{
+			name: "front matter",
+			file: "_posts/2024-05-01-hello.md",
+			text: "\uFEFF---\r\ntitle: \"Hello, World\"\r\ndate: 2024-05-01 14:00:00 +0200\r\nlastmod: 2024-05-02\r\n" +
+				"author: Jane\r\ntags: [go, 'web']\r\ncategories:\r\n  - notes\r\ndescription: A greeting # shown in lists\r\n---\r\n\r\nBody\r\n",
+			want: ImportPost{Author: "Jane", Blog: Blog{
+				Title: "Hello, World", Slug: "hello", Content: "Body\n", ContentFormat: FormatMarkdown, Visibility: VisibilityPublic,
+				Summary: "A greeting", Tags: []string{"notes", "go", "web"},
+				CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+				UpdatedAt: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+			}},
+		},
+		{
+			name: "slug, draft and authors list",
+			file: "content/posts/first/index.md",
+			text: "---\nslug: my-first-post\ndraft: true\nauthors:\n  - jane\n  - john\ncontent_format: html\n---\n<p>Hi</p>",
+			want: ImportPost{Author: "jane", Blog: Blog{
+				Slug: "my-first-post", Content: "<p>Hi</p>", ContentFormat: FormatHTML, Visibility: VisibilityPrivate,
+			}},
+		},
+		{
+			name: "empty slug keeps the file name",
+			file: "hello.md",
+			text: "---\nslug: \"\"\n---\n",
+			want: ImportPost{Blog: Blog{Slug: "hello", ContentFormat: FormatMarkdown, Visibility: VisibilityPublic}},
+		},
+		{
+			name:    "unclosed front matter",
+			file:    "broken.md",
+			text:    "---\ntitle: Broken\n",
+			wantErr: "front matter is not closed",
+		},
+		{
+			name:    "invalid date",
+			file:    "late.md",
+			text:    "---\ndate: yesterday\n---\n",
+			wantErr: `invalid date: unrecognised date "yesterday"`,
+		},
+		{
+			name:    "invalid line",
+			file:    "odd.md",
+			text:    "---\ntitle: Odd\njust text\n---\n",
+			wantErr: "front matter line 2: expected key: value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMarkdownPost(tt.file, tt.text)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseMarkdownPost() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMarkdownPost() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMarkdownPost() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want map[string]frontMatterValue
+	}{
+		{
+			name: "scalars",
+			text: "Title: Plain\nquoted: \"a \\\"b\\\"\"\nsingle: 'it''s'\ncomment: value # note\nhash: \"# kept\"",
+			want: map[string]frontMatterValue{
+				"title":   {value: "Plain"},
+				"quoted":  {value: `a "b"`},
+				"single":  {value: "it's"},
+				"comment": {value: "value"},
+				"hash":    {value: "# kept"},
+			},
+		},
+		{
+			name: "lists",
+			text: "inline: [a, \"b\", ]\nblock:\n  - one\n  - 'two'\nempty: []",
+			want: map[string]frontMatterValue{
+				"inline": {list: []string{"a", "b"}, isSeq: true},
+				"block":  {list: []string{"one", "two"}, isSeq: true},
+				"empty":  {isSeq: true},
+			},
+		},
+		{
+			name: "nested mappings and comments are skipped",
+			text: "# comment\nparams:\n  toc: true\n\ntitle: Kept",
+			want: map[string]frontMatterValue{
+				"params": {},
+				"title":  {value: "Kept"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFrontMatter(tt.text)
+			if err != nil {
+				t.Fatalf("parseFrontMatter() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFrontMatter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMarkdownFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"posts/hello.md":        {Data: []byte("---\ntitle: Hello\n---\nHi")},
+		"posts/broken.md":       {Data: []byte("---\ntitle: Broken\n")},
+		"posts/.draft.md":       {Data: []byte("hidden")},
+		"posts/image.png":       {Data: []byte("png")},
+		"notes/README.MARKDOWN": {Data: []byte("Read me")},
+		"posts/huge.md":         {Data: make([]byte, maxMarkdownFileSize+1)},
+	}
+	posts, err := ParseMarkdownFiles(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range posts {
+		entry := p.Source + " " + p.Blog.Title
+		if p.Err != nil {
+			entry += ": " + p.Err.Error()
+		}
+		got = append(got, entry)
+	}
+	want := []string{"notes/README.MARKDOWN ", "posts/broken.md broken.md: front matter is not closed", "posts/hello.md Hello",
+		"posts/huge.md huge.md: file is larger than 8 MB"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMarkdownFiles() = %q, want %q", got, want)
+	}
+}
+
+func TestParseMarkdownFilesTotalSize(t *testing.T) {
+	data := make([]byte, maxMarkdownFileSize)
+	fsys := fstest.MapFS{}
+	for i := 0; i <= maxMarkdownTotalSize/maxMarkdownFileSize; i++ {
+		fsys[fmt.Sprintf("posts/%02d.md", i)] = &fstest.MapFile{Data: data}
+	}
+	if _, err := ParseMarkdownFiles(fsys); err == nil || err.Error() != "the Markdown files exceed 256 MB in total" {
+		t.Errorf("ParseMarkdownFiles() error = %v, want the total size error", err)
+	}
+}
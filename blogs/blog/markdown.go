@@ -0,0 +1,300 @@
+package blog
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Content formats a post can be written in.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// ContentFormats lists every supported content format.
+var ContentFormats = []string{FormatPlain, FormatMarkdown, FormatHTML}
+
+// RenderContent converts post content in the given format to sanitized HTML.
+func RenderContent(format, content string) string {
+	switch format {
+	case FormatMarkdown:
+		return SanitizeHTML(RenderMarkdown(content))
+	case FormatHTML:
+		return SanitizeHTML(content)
+	default:
+		return renderPlain(content)
+	}
+}
+
+// renderPlain escapes text and turns blank-line separated blocks into paragraphs.
+func renderPlain(content string) string {
+	var out strings.Builder
+	for _, para := range splitParagraphs(content) {
+		out.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(para), "\n", "<br>") + "</p>\n")
+	}
+	return out.String()
+}
+
+var blankLinePattern = regexp.MustCompile(`\n\s*\n`)
+
+func splitParagraphs(content string) []string {
+	var paras []string
+	for _, block := range blankLinePattern.Split(normalizeNewlines(content), -1) {
+		if block = strings.TrimSpace(block); block != "" {
+			paras = append(paras, block)
+		}
+	}
+	return paras
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
+
+// Block level markdown syntax.
+var (
+	headingPattern     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	rulePattern        = regexp.MustCompile(`^ {0,3}(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	fencePattern       = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*([a-zA-Z0-9_+-]*)")
+	bulletItemPattern  = regexp.MustCompile(`^ {0,3}[-*+]\s+(.*)$`)
+	orderedItemPattern = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)]\s+(.*)$`)
+	quotePattern       = regexp.MustCompile(`^ {0,3}>\s?(.*)$`)
+)
+
+// RenderMarkdown converts the common subset of Markdown to HTML: headings,
+// paragraphs, emphasis, links, images, inline and fenced code, block quotes,
+// lists and horizontal rules. Raw HTML in the source is escaped, not passed
+// through. The output should still be passed through SanitizeHTML to filter
+// link targets.
+func RenderMarkdown(source string) string {
+	var out strings.Builder
+	renderBlocks(&out, strings.Split(normalizeNewlines(source), "\n"))
+	return out.String()
+}
+
+func renderBlocks(out *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case fencePattern.MatchString(line):
+			m := fencePattern.FindStringSubmatch(line)
+			fence := m[1]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			i++ // Skip the closing fence
+			out.WriteString("<pre><code")
+			if m[2] != "" {
+				out.WriteString(` class="language-` + m[2] + `"`)
+			}
+			out.WriteString(">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			var code []string
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.HasPrefix(lines[i], "\t") || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, strings.TrimPrefix(strings.TrimPrefix(lines[i], "\t"), "    "))
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.TrimRight(strings.Join(code, "\n"), "\n")) + "</code></pre>\n")
+
+		case headingPattern.MatchString(trimmed):
+			m := headingPattern.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(m[1]))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+			i++
+
+		case rulePattern.MatchString(line):
+			out.WriteString("<hr>\n")
+			i++
+
+		case quotePattern.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && quotePattern.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quotePattern.FindStringSubmatch(lines[i])[1])
+			}
+			out.WriteString("<blockquote>\n")
+			renderBlocks(out, quoted)
+			out.WriteString("</blockquote>\n")
+
+		case bulletItemPattern.MatchString(line), orderedItemPattern.MatchString(line):
+			i = renderList(out, lines, i)
+
+		default:
+			var para []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]); i++ {
+				para = append(para, lines[i])
+			}
+			out.WriteString("<p>" + renderParagraph(para) + "</p>\n")
+		}
+	}
+}
+
+// startsBlock reports whether a line interrupts a paragraph.
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return fencePattern.MatchString(line) || headingPattern.MatchString(trimmed) || rulePattern.MatchString(line) ||
+		quotePattern.MatchString(line) || bulletItemPattern.MatchString(line) || orderedItemPattern.MatchString(line)
+}
+
+// renderList writes the list starting at lines[start] and returns the index of
+// the first line after it. Indented lines continue the previous item.
+func renderList(out *strings.Builder, lines []string, start int) int {
+	ordered := orderedItemPattern.MatchString(lines[start])
+	if ordered {
+		if n := orderedItemPattern.FindStringSubmatch(lines[start])[1]; n != "1" {
+			out.WriteString(`<ol start="` + strings.TrimLeft(n, "0") + `">` + "\n")
+		} else {
+			out.WriteString("<ol>\n")
+		}
+	} else {
+		out.WriteString("<ul>\n")
+	}
+
+	var items [][]string
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if ordered && orderedItemPattern.MatchString(line) {
+			items = append(items, []string{orderedItemPattern.FindStringSubmatch(line)[2]})
+		} else if !ordered && bulletItemPattern.MatchString(line) {
+			items = append(items, []string{bulletItemPattern.FindStringSubmatch(line)[1]})
+		} else if strings.TrimSpace(line) != "" && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || !startsBlock(line)) {
+			items[len(items)-1] = append(items[len(items)-1], strings.TrimSpace(line))
+		} else {
+			break
+		}
+	}
+	for _, item := range items {
+		out.WriteString("<li>" + renderParagraph(item) + "</li>\n")
+	}
+
+	if ordered {
+		out.WriteString("</ol>\n")
+	} else {
+		out.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// renderParagraph renders the lines of a paragraph; a line ending in two spaces
+// or a backslash forces a line break.
+func renderParagraph(lines []string) string {
+	parts := make([]string, len(lines))
+	for i, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		hardBreak := i < len(lines)-1 && (strings.HasSuffix(line, "  ") || strings.HasSuffix(line, "\\"))
+		line = strings.TrimRight(line, " ")
+		if hardBreak {
+			line = strings.TrimSuffix(line, "\\")
+		}
+		parts[i] = renderInline(line)
+		if hardBreak {
+			parts[i] += "<br>"
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// Inline markdown syntax.
+var (
+	linkPattern     = regexp.MustCompile(`^(!?)\[([^\]]*)\]\(\s*<?([^\s)>]*)>?(?:\s+"([^"]*)")?\s*\)`)
+	autolinkPattern = regexp.MustCompile(`^<((?:https?|mailto):[^\s<>]+)>`)
+)
+
+// emphasisDelimiters maps markdown delimiters to the element they produce,
+// longest first so ** is tried before *.
+var emphasisDelimiters = []struct{ delim, tag string }{
+	{"**", "strong"}, {"__", "strong"}, {"~~", "del"}, {"*", "em"}, {"_", "em"},
+}
+
+// renderInline renders emphasis, code spans, links and images within a line.
+func renderInline(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		switch c := text[i]; {
+		case c == '\\' && i+1 < len(text) && strings.ContainsRune("\\`*_{}[]()#+-.!~>", rune(text[i+1])):
+			out.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[ticks:], rest[:ticks]); end >= 0 {
+				code := strings.TrimSpace(rest[ticks : ticks+end])
+				out.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += 2*ticks + end
+				continue
+			}
+
+		case c == '[' || (c == '!' && strings.HasPrefix(rest, "![")):
+			if m := linkPattern.FindStringSubmatch(rest); m != nil {
+				title := ""
+				if m[4] != "" {
+					title = ` title="` + html.EscapeString(m[4]) + `"`
+				}
+				if m[1] == "!" {
+					out.WriteString(`<img src="` + html.EscapeString(m[3]) + `" alt="` + html.EscapeString(m[2]) + `"` + title + `>`)
+				} else {
+					out.WriteString(`<a href="` + html.EscapeString(m[3]) + `"` + title + `>` + renderInline(m[2]) + `</a>`)
+				}
+				i += len(m[0])
+				continue
+			}
+
+		case c == '<':
+			if m := autolinkPattern.FindStringSubmatch(rest); m != nil {
+				out.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + `</a>`)
+				i += len(m[0])
+				continue
+			}
+
+		case c == '*' || c == '~' || (c == '_' && (i == 0 || !isWordByte(text[i-1]))):
+			if n, ok := renderEmphasis(&out, rest); ok {
+				i += n
+				continue
+			}
+		}
+
+		out.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return out.String()
+}
+
+// renderEmphasis renders an emphasis span starting at the beginning of text
+// and returns how many bytes it consumed.
+func renderEmphasis(out *strings.Builder, text string) (int, bool) {
+	for _, e := range emphasisDelimiters {
+		if !strings.HasPrefix(text, e.delim) {
+			continue
+		}
+		inner := text[len(e.delim):]
+		if inner == "" || inner[0] == ' ' {
+			continue
+		}
+		end := strings.Index(inner, e.delim)
+		if end <= 0 || inner[end-1] == ' ' {
+			continue
+		}
+		// Underscores inside words such as snake_case never mark emphasis
+		if after := len(e.delim) + end + len(e.delim); e.delim[0] == '_' && after < len(text) && isWordByte(text[after]) {
+			continue
+		}
+		out.WriteString("<" + e.tag + ">" + renderInline(inner[:end]) + "</" + e.tag + ">")
+		return 2*len(e.delim) + end, true
+	}
+	return 0, false
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
@@ -0,0 +1,69 @@
+package blog
+
+import "testing"
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"heading and paragraph", "# Title\n\nSome *text*.", "<h1>Title</h1>\n<p>Some <em>text</em>.</p>\n"},
+		{"link with title", `[Go](https://go.dev "The Go site")`, `<p><a href="https://go.dev" title="The Go site">Go</a></p>` + "\n"},
+		{"list", "- one\n- two", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"},
+		{"ordered list start", "3. three\n4. four", "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n"},
+		{"snake_case", "a snake_case_name", "<p>a snake_case_name</p>\n"},
+
+		// Raw HTML is escaped wherever it appears
+		{"raw block", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"raw inline", "hello <b onclick=x>bold</b>", "<p>hello &lt;b onclick=x&gt;bold&lt;/b&gt;</p>\n"},
+		{"raw inside emphasis", "**<i>x</i>**", "<p><strong>&lt;i&gt;x&lt;/i&gt;</strong></p>\n"},
+		{"raw inside code span", "`<b>`", "<p><code>&lt;b&gt;</code></p>\n"},
+		{"raw inside fence", "```html\n<script>x</script>\n```", "<pre><code class=\"language-html\">&lt;script&gt;x&lt;/script&gt;</code></pre>\n"},
+		{"raw inside quote", "> <img src=x onerror=y>", "<blockquote>\n<p>&lt;img src=x onerror=y&gt;</p>\n</blockquote>\n"},
+		{"raw inside heading", "## <svg onload=x>", "<h2>&lt;svg onload=x&gt;</h2>\n"},
+		{"autolink with markup", "<https://example.com/?a=<b>", "<p>&lt;https://example.com/?a=&lt;b&gt;</p>\n"},
+
+		// Attribute injection through link syntax
+		{"quote in image alt", `![a" onerror="alert(1)](/i.png)`, `<p><img src="/i.png" alt="a&#34; onerror=&#34;alert(1)"></p>` + "\n"},
+		{"quote in link target", `[x](/a"onclick="y)`, `<p><a href="/a&#34;onclick=&#34;y">x</a></p>` + "\n"},
+		{"quote in link title", `[x](/a "t' onclick='y")`, `<p><a href="/a" title="t&#39; onclick=&#39;y">x</a></p>` + "\n"},
+		{"fence language", "```go\" onclick=\"x\nfmt\n```", "<pre><code class=\"language-go\">fmt</code></pre>\n"},
+
+		// Unclosed syntax
+		{"unclosed emphasis", "**bold", "<p>**bold</p>\n"},
+		{"unclosed code span", "`code", "<p>`code</p>\n"},
+		{"unclosed link", "[text](https://example.com", "<p>[text](https://example.com</p>\n"},
+		{"unclosed fence", "```\ncode", "<pre><code>code</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderMarkdown(tt.source); got != tt.want {
+				t.Errorf("RenderMarkdown(%q)\n got %q\nwant %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderContentMarkdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"safe link", "[Go](https://go.dev)", `<p><a href="https://go.dev" rel="nofollow noopener">Go</a></p>` + "\n"},
+		{"javascript link", "[x](javascript:alert`1`)", `<p><a rel="nofollow noopener">x</a></p>` + "\n"},
+		// Markdown does not decode entities, so this is a relative URL with a fragment
+		{"entity-encoded javascript link", "[x](jav&#x61;script:alert`1`)", `<p><a href="jav&amp;#x61;script:alert%601%60" rel="nofollow noopener">x</a></p>` + "\n"},
+		{"javascript image", "![x](javascript:alert`1`)", `<p><img alt="x"></p>` + "\n"},
+		{"javascript autolink is not a link", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>\n"},
+		{"media image", "![Cat](media:4)", `<p><img src="/media/4/original" alt="Cat"></p>` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderContent(FormatMarkdown, tt.source); got != tt.want {
+				t.Errorf("RenderContent(markdown, %q)\n got %q\nwant %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
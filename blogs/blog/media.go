@@ -0,0 +1,426 @@
+package blog
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register GIF decoding for uploads
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/imaging"
+	"shared/storage"
+)
+
+// Media upload limits.
+const (
+	maxMediaBytes     = 10 << 20 // 10 MB
+	maxImageDimension = 8192     // Reject larger images before decoding them
+	maxMediaFilename  = 255      // Characters, the size of the filename column
+)
+
+// orphanMediaRetention is how long an upload may stay unreferenced by any post
+// before it is deleted.
+const orphanMediaRetention = 7 * 24 * time.Hour
+
+// MediaVariantWidths are the widths, in pixels, of the responsive variants
+// generated for uploaded images. Only widths smaller than the original are kept.
+var MediaVariantWidths = []int{1600, 800, 400}
+
+// mediaTypes maps the sniffed content types accepted for upload to the file
+// extension used to store them.
+var mediaTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// mediaRefPattern matches references to uploaded media in post content,
+// e.g. ![diagram](media:42) or media:42/800 for a specific variant.
+var mediaRefPattern = regexp.MustCompile(`media:(\d+)`)
+
+// Errors returned for unacceptable uploads.
+var (
+	ErrMediaType = errors.New("file must be a JPEG, PNG or GIF image, a PDF or plain text")
+	ErrMediaSize = errors.New("images must be at most 8192x8192 pixels")
+)
+
+var blobStore storage.BlobStore
+
+// SetBlobStore sets the store used for uploaded files.
+func SetBlobStore(store storage.BlobStore) {
+	blobStore = store
+}
+
+// Media is a file uploaded by an author for use in their posts.
+type Media struct {
+	ID          int               `json:"id"`
+	Author      string            `json:"author"`
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"content_type"`
+	Size        int64             `json:"size"`
+	Width       int               `json:"width,omitempty"`
+	Height      int               `json:"height,omitempty"`
+	Variants    []int             `json:"variants,omitempty"` // Widths of the resized copies of an image
+	URLs        map[string]string `json:"urls"`               // "original" and each variant width
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// IsImage reports whether the media is an image with responsive variants.
+func (m *Media) IsImage() bool {
+	return strings.HasPrefix(m.ContentType, "image/")
+}
+
+// variantExt returns the extension of the resized copies of an image. JPEG
+// photos stay JPEG; everything else is re-encoded as PNG to keep transparency.
+func (m *Media) variantExt() string {
+	if m.ContentType == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// blobKey returns the key of the original ("original") or a variant (its width).
+func (m *Media) blobKey(variant string) string {
+	if variant == "original" {
+		return fmt.Sprintf("media/%d/original%s", m.ID, mediaTypes[m.ContentType])
+	}
+	return fmt.Sprintf("media/%d/%s%s", m.ID, variant, m.variantExt())
+}
+
+// HasVariant reports whether variant names the original or an existing resized copy.
+func (m *Media) HasVariant(variant string) bool {
+	if variant == "original" {
+		return true
+	}
+	for _, w := range m.Variants {
+		if strconv.Itoa(w) == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// variantContentType returns the content type a variant is served with.
+func (m *Media) variantContentType(variant string) string {
+	if variant == "original" || m.ContentType == "image/jpeg" {
+		return m.ContentType
+	}
+	return "image/png"
+}
+
+func (m *Media) setURLs() {
+	m.URLs = map[string]string{"original": fmt.Sprintf("/media/%d/original", m.ID)}
+	for _, w := range m.Variants {
+		m.URLs[strconv.Itoa(w)] = fmt.Sprintf("/media/%d/%d", m.ID, w)
+	}
+}
+
+// SaveMedia validates an upload, stores it with its responsive variants and
+// records it in the media table.
+func SaveMedia(ctx context.Context, author, filename string, data []byte) (*Media, error) {
+	m := &Media{
+		Author:      author,
+		Filename:    truncateFilename(filename, maxMediaFilename),
+		ContentType: strings.SplitN(http.DetectContentType(data), ";", 2)[0],
+		Size:        int64(len(data)),
+		CreatedAt:   time.Now(),
+	}
+	if _, ok := mediaTypes[m.ContentType]; !ok {
+		return nil, ErrMediaType
+	}
+
+	var img image.Image
+	if m.IsImage() {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrMediaType
+		}
+		if cfg.Width > maxImageDimension || cfg.Height > maxImageDimension {
+			return nil, ErrMediaSize
+		}
+		if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+			return nil, ErrMediaType
+		}
+		m.Width, m.Height = cfg.Width, cfg.Height
+	}
+
+	query := `INSERT INTO media (author, filename, content_type, size, width, height) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := db.ExecContext(ctx, query, m.Author, m.Filename, m.ContentType, m.Size, m.Width, m.Height)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	m.ID = int(id)
+
+	if err := m.storeBlobs(ctx, data, img); err != nil {
+		m.deleteBlobs(ctx)
+		db.ExecContext(ctx, `DELETE FROM media WHERE id = ?`, m.ID)
+		return nil, err
+	}
+
+	_, err = db.ExecContext(ctx, `UPDATE media SET variants = ? WHERE id = ?`, joinInts(m.Variants), m.ID)
+	if err != nil {
+		return nil, err
+	}
+	m.setURLs()
+	return m, nil
+}
+
+// truncateFilename shortens a filename to at most max characters, keeping its
+// extension when the extension itself is short enough.
+func truncateFilename(name string, max int) string {
+	runes := []rune(name)
+	if len(runes) <= max {
+		return name
+	}
+	ext := []rune(filepath.Ext(name))
+	if len(ext) >= max/2 {
+		ext = nil
+	}
+	return string(runes[:max-len(ext)]) + string(ext)
+}
+
+// storeBlobs writes the original file and, for images, every variant narrower
+// than the original. Each variant is scaled from the previous, larger one.
+func (m *Media) storeBlobs(ctx context.Context, data []byte, img image.Image) error {
+	if err := blobStore.Put(ctx, m.blobKey("original"), bytes.NewReader(data), m.ContentType); err != nil {
+		return err
+	}
+	if img == nil {
+		return nil
+	}
+
+	src := img
+	for _, width := range MediaVariantWidths {
+		if width >= m.Width {
+			continue
+		}
+		height := max(1, m.Height*width/m.Width)
+		src = imaging.Resize(src, width, height)
+
+		var buf bytes.Buffer
+		var err error
+		if m.variantExt() == ".jpg" {
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, src)
+		}
+		if err != nil {
+			return err
+		}
+		variant := strconv.Itoa(width)
+		if err := blobStore.Put(ctx, m.blobKey(variant), &buf, m.variantContentType(variant)); err != nil {
+			return err
+		}
+		m.Variants = append(m.Variants, width)
+	}
+	return nil
+}
+
+// deleteBlobs removes the original and every variant from the blob store.
+func (m *Media) deleteBlobs(ctx context.Context) error {
+	keys := []string{m.blobKey("original")}
+	for _, w := range m.Variants {
+		keys = append(keys, m.blobKey(strconv.Itoa(w)))
+	}
+	var firstErr error
+	for _, key := range keys {
+		if err := blobStore.Delete(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+const mediaColumns = `id, author, filename, content_type, size, width, height, variants, created_at`
+
+func scanMedia(scan func(dest ...interface{}) error) (*Media, error) {
+	var m Media
+	var variants string
+	if err := scan(&m.ID, &m.Author, &m.Filename, &m.ContentType, &m.Size, &m.Width, &m.Height, &variants, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	for _, v := range strings.Split(variants, ",") {
+		if w, err := strconv.Atoi(v); err == nil {
+			m.Variants = append(m.Variants, w)
+		}
+	}
+	m.setURLs()
+	return &m, nil
+}
+
+// GetMediaByID retrieves a single upload, or nil if it does not exist.
+func GetMediaByID(id int) (*Media, error) {
+	m, err := scanMedia(db.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE id = ?`, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+// GetMediaByAuthor lists an author's uploads, newest first.
+func GetMediaByAuthor(author string) ([]Media, error) {
+	rows, err := db.Query(`SELECT `+mediaColumns+` FROM media WHERE author = ? ORDER BY created_at DESC, id DESC`, author)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []Media{}
+	for rows.Next() {
+		m, err := scanMedia(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, *m)
+	}
+	return media, rows.Err()
+}
+
+// DeleteMediaFromModel removes an upload and its files.
+func DeleteMediaFromModel(ctx context.Context, m *Media) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM media WHERE id = ?`, m.ID); err != nil {
+		return err
+	}
+	return m.deleteBlobs(ctx)
+}
+
+// IsMediaInUse reports whether any post references the upload.
+func IsMediaInUse(id int) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM blog_media WHERE media_id = ?`, id).Scan(&count)
+	return count > 0, err
+}
+
+// MediaIDsInContent returns the distinct media IDs referenced by post content.
+func MediaIDsInContent(content string) []int {
+	seen := map[int]bool{}
+	var ids []int
+	for _, match := range mediaRefPattern.FindAllStringSubmatch(content, -1) {
+		id, err := strconv.Atoi(match[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// UnknownMediaRefs returns the IDs among ids that do not belong to author.
+func UnknownMediaRefs(author string, ids []int) ([]int, error) {
+	var unknown []int
+	for _, id := range ids {
+		var owner string
+		err := db.QueryRow(`SELECT author FROM media WHERE id = ?`, id).Scan(&owner)
+		if err == sql.ErrNoRows || (err == nil && owner != author) {
+			unknown = append(unknown, id)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return unknown, nil
+}
+
+// syncBlogMedia records in tx which of author's uploads a post's content
+// references, so saving a post and tracking its media succeed or fail together.
+func syncBlogMedia(tx *sql.Tx, blogID int, author, content string) error {
+	if _, err := tx.Exec(`DELETE FROM blog_media WHERE blog_id = ?`, blogID); err != nil {
+		return err
+	}
+	ids := MediaIDsInContent(content)
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{blogID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args = append(args, author)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err := tx.Exec(`INSERT INTO blog_media (blog_id, media_id) SELECT ?, id FROM media WHERE id IN (`+placeholders+`) AND author = ?`, args...)
+	return err
+}
+
+// DeleteOrphanedMedia removes uploads that no post has referenced for the
+// retention period.
+func DeleteOrphanedMedia(ctx context.Context) error {
+	cutoff := time.Now().Add(-orphanMediaRetention)
+	rows, err := db.QueryContext(ctx, `SELECT `+mediaColumns+` FROM media m
+        WHERE m.created_at < ? AND NOT EXISTS (SELECT 1 FROM blog_media bm WHERE bm.media_id = m.id)`, cutoff)
+	if err != nil {
+		return err
+	}
+	var orphans []*Media
+	for rows.Next() {
+		m, err := scanMedia(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		orphans = append(orphans, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range orphans {
+		// Re-check the reference inside the delete in case a post started using it meanwhile
+		res, err := db.ExecContext(ctx, `DELETE FROM media WHERE id = ?
+            AND NOT EXISTS (SELECT 1 FROM blog_media WHERE media_id = ?)`, m.ID, m.ID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		if err := m.deleteBlobs(ctx); err != nil {
+			log.Printf("Failed to delete files of orphaned media %d: %v", m.ID, err)
+		}
+		log.Printf("Deleted orphaned media %d of %s", m.ID, m.Author)
+	}
+	return nil
+}
+
+// StartMediaCleanupWorker periodically deletes orphaned uploads.
+func StartMediaCleanupWorker(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := DeleteOrphanedMedia(ctx); err != nil {
+				log.Printf("Failed to clean up orphaned media: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,260 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"shared/storage"
+)
+
+// UploadMedia stores a file in the logged-in author's media library.
+// @Summary Upload media
+// @Description Upload an image (JPEG, PNG, GIF), PDF or plain text file of at most 10 MB. Images get 1600, 800 and 400 pixel wide variants. Reference the upload from post content as media:{id}.
+// @Tags Media
+// @Accept  multipart/form-data
+// @Produce  json
+// @Param   file  formData  file  true  "File to upload"
+// @Success 201 {object} Media
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /media [post]
+func UploadMedia(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Leave room for the multipart headers around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaBytes+64<<10)
+	file, header, err := r.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "File must be at most 10 MB", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	if n, err := io.Copy(&buf, io.LimitReader(file, maxMediaBytes+1)); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	} else if n > maxMediaBytes {
+		http.Error(w, "File must be at most 10 MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	media, err := SaveMedia(r.Context(), claims.Username, filepath.Base(header.Filename), buf.Bytes())
+	if errors.Is(err, ErrMediaType) || errors.Is(err, ErrMediaSize) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		log.Printf("Failed to store media: %v", err)
+		http.Error(w, "Failed to store media", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(media)
+}
+
+// ListMedia lists the logged-in author's uploads.
+// @Summary List media
+// @Description Lists the media library of the authenticated author, newest first
+// @Tags Media
+// @Produce  json
+// @Success 200 {array} Media
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /media [get]
+func ListMedia(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	media, err := GetMediaByAuthor(claims.Username)
+	if err != nil {
+		log.Printf("Failed to list media: %v", err)
+		http.Error(w, "Failed to list media", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(media)
+}
+
+// DeleteMedia removes an upload from the logged-in author's media library.
+// @Summary Delete media
+// @Description Deletes an upload of the authenticated author. Uploads still referenced by a post cannot be deleted.
+// @Tags Media
+// @Produce  json
+// @Param   id  query  int  true  "Media ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /media [delete]
+func DeleteMedia(w http.ResponseWriter, r *http.Request) {
+	mediaID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid media ID", http.StatusBadRequest)
+		return
+	}
+
+	media, err := GetMediaByID(mediaID)
+	if err != nil || media == nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil || (claims.Username != media.Author && claims.Role != "Admin") {
+		http.Error(w, "Forbidden: You can only delete your own media", http.StatusForbidden)
+		return
+	}
+
+	inUse, err := IsMediaInUse(mediaID)
+	if err != nil {
+		log.Printf("Failed to delete media: %v", err)
+		http.Error(w, "Failed to delete media", http.StatusInternalServerError)
+		return
+	}
+	if inUse {
+		http.Error(w, "Media is used by a blog post", http.StatusConflict)
+		return
+	}
+
+	if err := DeleteMediaFromModel(r.Context(), media); err != nil {
+		log.Printf("Failed to delete media: %v", err)
+		http.Error(w, "Failed to delete media", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Media deleted successfully",
+	})
+}
+
+// ServeMedia serves an upload or one of its variants.
+// @Summary Get a media file
+// @Description Serves the original upload or a resized variant of an image; the URLs are listed in media responses. Uploads are served to their owner and Admins, and to callers who may read a post that uses them; the content of a password-protected post must have been unlocked.
+// @Tags Media
+// @Param   id       path  int     true  "Media ID"
+// @Param   variant  path  string  true  "original or a variant width, e.g. 800"
+// @Success 200 {file} file
+// @Success 304 {string} string "Not Modified"
+// @Failure 404 {string} string "Media not found"
+// @Router /media/{id}/{variant} [get]
+func ServeMedia(w http.ResponseWriter, r *http.Request) {
+	mediaID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+	media, err := GetMediaByID(mediaID)
+	if err != nil {
+		log.Printf("Failed to read media: %v", err)
+		http.Error(w, "Failed to read media", http.StatusInternalServerError)
+		return
+	}
+	variant := r.PathValue("variant")
+	if media == nil || !media.HasVariant(variant) {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+	visible, public, err := mediaVisibleTo(r, media)
+	if err != nil {
+		log.Printf("Failed to check media visibility: %v", err)
+		http.Error(w, "Failed to read media", http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	// A media ID always refers to the same bytes, so clients only need to revalidate rarely
+	etag := `"` + strconv.Itoa(media.ID) + "-" + variant + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, info, err := blobStore.Get(r.Context(), media.blobKey(variant))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to read media: %v", err)
+		http.Error(w, "Failed to read media", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", media.variantContentType(variant))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if public {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=300")
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !media.IsImage() {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+sanitizeFilename(media.Filename)+`"`)
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+// mediaVisibleTo applies the visibility of the posts using an upload: the
+// caller may fetch it if they may read the content of one of them, with the
+// same checks as GetBlog. Owners and Admins may always fetch their uploads.
+// public reports whether a public post uses it, so shared caches may keep it.
+func mediaVisibleTo(r *http.Request, m *Media) (visible, public bool, err error) {
+	posts, err := queryBlogs(`SELECT `+blogColumns+` FROM blogs WHERE id IN (SELECT blog_id FROM blog_media WHERE media_id = ?)`, m.ID)
+	if err != nil {
+		return false, false, err
+	}
+	claims := optionalClaims(r)
+	visible = claims != nil && (claims.Username == m.Author || claims.Role == "Admin")
+	for i := range posts {
+		if posts[i].Visibility == VisibilityPublic {
+			return true, true, nil
+		}
+		if !visible && visibleTo(&posts[i], claims, grantFromRequest(r, posts[i].ID)) && !posts[i].Locked {
+			visible = true
+		}
+	}
+	return visible, false, nil
+}
+
+// sanitizeFilename strips characters that would break a quoted header value.
+func sanitizeFilename(name string) string {
+	out := make([]rune, 0, len(name))
+	for _, r := range name {
+		if r < 0x20 || r == '"' || r == '\\' || r == 0x7f {
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,100 @@
+package blog
+
+import (
+	"encoding/json"
+	"html"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Card metadata settings.
+const (
+	wordsPerMinute   = 230
+	maxExcerptLength = 200 // Characters of an automatic excerpt, before the ellipsis
+	maxSummaryLength = 500
+)
+
+// plainText returns the words of a post's rendered HTML without markup.
+func plainText(contentHTML string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(markupPattern.ReplaceAllString(contentHTML, " "))), " ")
+}
+
+// computeMetadata sets the word count, reading time and excerpt of a post from
+// its rendered content. The author's summary, if any, is the excerpt.
+func (b *Blog) computeMetadata() {
+	text := plainText(b.ContentHTML)
+	b.WordCount = len(strings.Fields(text))
+	b.ReadingTime = (b.WordCount + wordsPerMinute - 1) / wordsPerMinute
+
+	b.Summary = strings.TrimSpace(b.Summary)
+	if b.Summary != "" {
+		b.Excerpt = b.Summary
+		return
+	}
+	b.Excerpt = text
+	if utf8.RuneCountInString(text) > maxExcerptLength {
+		cut := string([]rune(text)[:maxExcerptLength])
+		if i := strings.LastIndexByte(cut, ' '); i > maxExcerptLength/2 {
+			cut = cut[:i]
+		}
+		b.Excerpt = strings.TrimRight(cut, " ,.;:") + "…"
+	}
+}
+
+// blogFields are the JSON field names of a Blog, accepted by fields=.
+var blogFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(Blog{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// parseFields reads a comma-separated fields= parameter. It returns nil when
+// no fields were requested and the names that are not Blog fields, if any.
+func parseFields(param string) ([]string, []string) {
+	if param == "" {
+		return nil, nil
+	}
+	fields := []string{"id"}
+	var unknown []string
+	for _, f := range strings.Split(param, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == "" || f == "id":
+		case blogFields[f]:
+			fields = append(fields, f)
+		default:
+			unknown = append(unknown, f)
+		}
+	}
+	return fields, unknown
+}
+
+// sparseBlogs keeps only the requested JSON fields of each post. The id is
+// always included.
+func sparseBlogs(blogs []Blog, fields []string) ([]map[string]json.RawMessage, error) {
+	sparse := make([]map[string]json.RawMessage, len(blogs))
+	for i := range blogs {
+		data, err := json.Marshal(blogs[i])
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		sparse[i] = make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				sparse[i][f] = v
+			}
+		}
+	}
+	return sparse, nil
+}
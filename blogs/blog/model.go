@@ -3,15 +3,36 @@ package blog
 import (
 	"database/sql"
 	"time"
+
+	"shared/mysqlerr"
 )
 
 // Blog represents a blog post.
 type Blog struct {
-	ID        int       `json:"id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	Author    string    `json:"author"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            int            `json:"id"`
+	Title         string         `json:"title"`
+	Slug          string         `json:"slug"` // Generated from Title unless set by the author
+	Content       string         `json:"content"`
+	ContentFormat string         `json:"content_format"` // plain (default), markdown or html
+	ContentHTML   string         `json:"content_html"`   // Sanitized rendering of Content, set by the server
+	Summary       string         `json:"summary"`        // Optional summary written by the author
+	Excerpt       string         `json:"excerpt"`        // Summary, or the start of the content; set by the server
+	WordCount     int            `json:"word_count"`     // Set by the server
+	ReadingTime   int            `json:"reading_time"`   // Estimated minutes, set by the server
+	Author        string         `json:"author"`
+	Bylines       []string       `json:"bylines"`            // Author followed by the accepted co-authors
+	Visibility    string         `json:"visibility"`         // public (default), unlisted, private or password
+	Password      string         `json:"password,omitempty"` // Sets the password of a password-protected post; never returned
+	PasswordHash  string         `json:"-"`
+	Locked        bool           `json:"locked,omitempty"` // Content withheld until the post is unlocked
+	Tags          []string       `json:"tags"`
+	Reactions     map[string]int `json:"reactions"`              // Reaction counts by type
+	MyReactions   []string       `json:"my_reactions,omitempty"` // Reactions of the logged-in reader, on single posts
+	Series        *SeriesNav     `json:"series,omitempty"`       // Place in its series, on single posts
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+
+	collaborators map[string]string // Roles of the accepted collaborators by username
 }
 
 var db *sql.DB
@@ -21,58 +42,258 @@ func SetDB(database *sql.DB) {
 	db = database
 }
 
-// CreateBlog inserts a new blog post into the database.
-func (b *Blog) CreateBlog() error {
-	query := `INSERT INTO blogs (title, content, author) VALUES (?, ?, ?)`
-	_, err := db.Exec(query, b.Title, b.Content, b.Author)
-	return err
+// blogColumns are the columns read into a Blog, in scanBlog order.
+const blogColumns = `id, title, COALESCE(slug, ''), content, content_format, content_html, summary, excerpt, word_count, reading_time, author, visibility, COALESCE(password_hash, ''), created_at, updated_at`
+
+func scanBlog(scan func(dest ...interface{}) error) (*Blog, error) {
+	var blog Blog
+	if err := scan(&blog.ID, &blog.Title, &blog.Slug, &blog.Content, &blog.ContentFormat, &blog.ContentHTML, &blog.Summary, &blog.Excerpt, &blog.WordCount, &blog.ReadingTime, &blog.Author, &blog.Visibility, &blog.PasswordHash, &blog.CreatedAt, &blog.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &blog, nil
 }
 
-// GetAllBlogs retrieves all blog posts from the database.
-func GetAllBlogs() ([]Blog, error) {
-	query := `SELECT id, title, content, author, created_at FROM blogs`
-	rows, err := db.Query(query)
+// queryBlogs runs a query selecting blogColumns and collects the results.
+func queryBlogs(query string, args ...interface{}) ([]Blog, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var blogs []Blog
+	blogs := []Blog{}
 	for rows.Next() {
-		var blog Blog
-		if err := rows.Scan(&blog.ID, &blog.Title, &blog.Content, &blog.Author, &blog.CreatedAt); err != nil {
+		blog, err := scanBlog(rows.Scan)
+		if err != nil {
 			return nil, err
 		}
-		blogs = append(blogs, blog)
+		blogs = append(blogs, *blog)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return blogs, attachDetails(blogs)
+}
+
+// attachDetails loads the tags, reaction counts, collaborators and bylines of posts.
+func attachDetails(blogs []Blog) error {
+	if err := attachTags(blogs); err != nil {
+		return err
+	}
+	if err := attachReactions(blogs); err != nil {
+		return err
+	}
+	return attachCollaborators(blogs)
+}
+
+// Render sets ContentHTML from Content and ContentFormat, and the card
+// metadata derived from it.
+func (b *Blog) Render() {
+	if b.ContentFormat == "" {
+		b.ContentFormat = FormatPlain
+	}
+	b.ContentHTML = RenderContent(b.ContentFormat, b.Content)
+	b.computeMetadata()
+}
+
+// CreateBlog inserts a new blog post and its tags into the database. The post
+// gets the slug requested in b.Slug, or one generated from its title.
+func (b *Blog) CreateBlog() error {
+	b.Render()
+	b.Tags = normalizeTags(b.Tags)
+	if b.Visibility == "" {
+		b.Visibility = VisibilityPublic
+	}
+	if err := b.preparePassword(); err != nil {
+		return err
+	}
+
+	// A concurrent post may claim the same generated slug first; pick again then
+	for attempt := 1; ; attempt++ {
+		err := b.insert()
+		if err == nil || !mysqlerr.IsDuplicateEntry(err) {
+			return err
+		}
+		if b.Slug != "" {
+			return ErrSlugTaken
+		}
+		if attempt == 3 {
+			return err
+		}
+	}
+}
+
+func (b *Blog) insert() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	slug, err := chooseSlug(tx, b.Slug, b.Title, 0)
+	if err != nil {
+		return err
+	}
+	// Imported posts keep their original dates; new ones get the current time
+	createdAt := sql.NullTime{Time: b.CreatedAt, Valid: !b.CreatedAt.IsZero()}
+	updatedAt := sql.NullTime{Time: b.UpdatedAt, Valid: !b.UpdatedAt.IsZero()}
+	query := `INSERT INTO blogs (title, slug, content, content_format, content_html, summary, excerpt, word_count, reading_time, author, visibility, password_hash, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), COALESCE(?, CURRENT_TIMESTAMP), COALESCE(?, ?, CURRENT_TIMESTAMP))`
+	res, err := tx.Exec(query, b.Title, slug, b.Content, b.ContentFormat, b.ContentHTML, b.Summary, b.Excerpt, b.WordCount, b.ReadingTime, b.Author, b.Visibility, b.PasswordHash,
+		createdAt, updatedAt, createdAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := saveTags(tx, int(id), b.Tags); err != nil {
+		return err
+	}
+	if err := syncBlogMedia(tx, int(id), b.Author, b.Content); err != nil {
+		return err
+	}
+	saved := *b
+	saved.ID, saved.Slug = int(id), slug
+	if err := recordSaved(tx, &saved, true, ""); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
-	return blogs, nil
+	*b = saved
+	return nil
+}
+
+// GetAllBlogs retrieves all blog posts from the database.
+func GetAllBlogs() ([]Blog, error) {
+	return queryBlogs(`SELECT ` + blogColumns + ` FROM blogs`)
+}
+
+// GetListedBlogs retrieves the posts listed to a user: public and
+// password-protected posts plus all of the user's own.
+func GetListedBlogs(username string) ([]Blog, error) {
+	return queryBlogs(`SELECT `+blogColumns+` FROM blogs WHERE `+listedCondition+` OR author = ?`, username)
+}
+
+// GetBlogsByAuthor retrieves all blog posts written by the given user.
+func GetBlogsByAuthor(author string) ([]Blog, error) {
+	return queryBlogs(`SELECT `+blogColumns+` FROM blogs WHERE author = ? ORDER BY created_at`, author)
+}
+
+// GetPublishedBlogsByAuthor retrieves the posts shown on an author's public page, newest first.
+func GetPublishedBlogsByAuthor(author string) ([]Blog, error) {
+	return queryBlogs(`SELECT `+blogColumns+` FROM blogs WHERE author = ? AND `+listedCondition+` ORDER BY created_at DESC`, author)
 }
 
 // GetBlogByID retrieves a single blog post by its ID.
 func GetBlogByID(id int) (*Blog, error) {
-	query := `SELECT id, title, content, author, created_at FROM blogs WHERE id = ?`
-	row := db.QueryRow(query, id)
-
-	var blog Blog
-	err := row.Scan(&blog.ID, &blog.Title, &blog.Content, &blog.Author, &blog.CreatedAt)
+	blog, err := scanBlog(db.QueryRow(`SELECT `+blogColumns+` FROM blogs WHERE id = ?`, id).Scan)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		return nil, err
 	}
-	return &blog, nil
+	blogs := []Blog{*blog}
+	if err := attachDetails(blogs); err != nil {
+		return nil, err
+	}
+	return &blogs[0], nil
 }
 
-// UpdateBlog updates an existing blog post in the database.
+// UpdateBlog updates an existing blog post and its tags in the database. A
+// slug in b.Slug replaces the current one; otherwise a new title gets a new
+// generated slug. The previous slug keeps redirecting to the post.
 func (b *Blog) UpdateBlog() error {
-	query := `UPDATE blogs SET title = ?, content = ? WHERE id = ?`
-	_, err := db.Exec(query, b.Title, b.Content, b.ID)
-	return err
+	b.Render()
+	b.Tags = normalizeTags(b.Tags)
+	if err := b.preparePassword(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldTitle, oldSlug, oldVisibility, author string
+	err = tx.QueryRow(`SELECT title, COALESCE(slug, ''), visibility, author FROM blogs WHERE id = ? FOR UPDATE`, b.ID).Scan(&oldTitle, &oldSlug, &oldVisibility, &author)
+	if err != nil {
+		return err
+	}
+	slug := oldSlug
+	if b.Slug != "" || b.Title != oldTitle || oldSlug == "" {
+		if slug, err = chooseSlug(tx, b.Slug, b.Title, b.ID); err != nil {
+			return err
+		}
+	}
+	if slug != oldSlug {
+		if err := changeSlug(tx, b.ID, oldSlug, slug); err != nil {
+			if mysqlerr.IsDuplicateEntry(err) {
+				return ErrSlugTaken
+			}
+			return err
+		}
+	}
+	b.Slug = slug
+
+	query := `UPDATE blogs SET title = ?, content = ?, content_format = ?, content_html = ?, summary = ?, excerpt = ?, word_count = ?, reading_time = ?,
+		visibility = ?, password_hash = NULLIF(?, '') WHERE id = ?`
+	if _, err := tx.Exec(query, b.Title, b.Content, b.ContentFormat, b.ContentHTML, b.Summary, b.Excerpt, b.WordCount, b.ReadingTime,
+		b.Visibility, b.PasswordHash, b.ID); err != nil {
+		return err
+	}
+	if err := saveTags(tx, b.ID, b.Tags); err != nil {
+		return err
+	}
+	if err := syncBlogMedia(tx, b.ID, author, b.Content); err != nil {
+		return err
+	}
+	if b.Visibility == VisibilityPrivate {
+		if err := removeSavedForOthers(tx, b.ID, author); err != nil {
+			return err
+		}
+	}
+	b.Author = author
+	if err := recordSaved(tx, b, false, oldVisibility); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // DeleteBlogFromModel deletes a blog post from the database.
 func DeleteBlogFromModel(id int) error {
-	query := `DELETE FROM blogs WHERE id = ?`
-	_, err := db.Exec(query, id)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := deleteBlogs(tx, `id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AnonymousAuthor replaces the author of posts kept after their author's account
+// was erased. It is not a valid username, so no one can register it.
+const AnonymousAuthor = "[deleted]"
+
+// RenderMissingContent fills in content_html and the card metadata for posts
+// stored before they existed.
+func RenderMissingContent() error {
+	blogs, err := queryBlogs(`SELECT ` + blogColumns + ` FROM blogs WHERE content_html = '' OR (excerpt = '' AND content <> '')`)
+	if err != nil {
+		return err
+	}
+	for _, blog := range blogs {
+		blog.Render()
+		if _, err := db.Exec(`UPDATE blogs SET content_html = ?, excerpt = ?, word_count = ?, reading_time = ? WHERE id = ?`,
+			blog.ContentHTML, blog.Excerpt, blog.WordCount, blog.ReadingTime, blog.ID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,132 @@
+package blog
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For header is believed.
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies sets the reverse proxies, as addresses or CIDR ranges,
+// whose X-Forwarded-For header names the client. Without any, the header is
+// ignored, since clients can send it themselves.
+func SetTrustedProxies(proxies []string) error {
+	var prefixes []netip.Prefix
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", p)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	trustedProxies = prefixes
+	return nil
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// attemptLimiter counts attempts per key in fixed windows, such as wrong
+// passwords or new comments, and blocks a key once it reaches the limit,
+// until its window ends.
+type attemptLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	entries map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	attempts int
+	ends     time.Time
+}
+
+func newAttemptLimiter(limit int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{limit: limit, window: window, entries: map[string]*attemptWindow{}}
+}
+
+// retryAfter returns how long key has to wait before its next attempt, or 0
+// if it may try now.
+func (l *attemptLimiter) retryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[key]
+	if !ok || entry.attempts < l.limit {
+		return 0
+	}
+	return max(time.Until(entry.ends), 0)
+}
+
+// add records an attempt of key.
+func (l *attemptLimiter) add(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.entries) >= 1024 {
+		for k, e := range l.entries {
+			if now.After(e.ends) {
+				delete(l.entries, k)
+			}
+		}
+	}
+	entry, ok := l.entries[key]
+	if !ok || now.After(entry.ends) {
+		entry = &attemptWindow{ends: now.Add(l.window)}
+		l.entries[key] = entry
+	}
+	entry.attempts++
+}
+
+// clientIP returns the address of the client that sent r. When r comes from a
+// trusted proxy, X-Forwarded-For is followed back to the first address that
+// is not a trusted proxy.
+func clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
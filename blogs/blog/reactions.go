@@ -0,0 +1,284 @@
+package blog
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ReactionTypes maps each reaction a reader can leave to its emoji.
+var ReactionTypes = map[string]string{
+	"like":      "👍",
+	"love":      "❤️",
+	"laugh":     "😂",
+	"wow":       "😮",
+	"sad":       "😢",
+	"celebrate": "🎉",
+}
+
+// AddReaction records a user's reaction to a post. It reports whether the
+// reaction is new; reacting twice with the same type changes nothing.
+func AddReaction(blogID int, username, reaction string) (bool, error) {
+	return changeReaction(blogID, username, reaction,
+		`INSERT IGNORE INTO blog_reactions (blog_id, username, reaction) VALUES (?, ?, ?)`,
+		`INSERT INTO blog_reaction_counts (blog_id, reaction, count) VALUES (?, ?, 1) ON DUPLICATE KEY UPDATE count = count + 1`)
+}
+
+// RemoveReaction withdraws a user's reaction from a post. It reports whether
+// there was a reaction to remove.
+func RemoveReaction(blogID int, username, reaction string) (bool, error) {
+	return changeReaction(blogID, username, reaction,
+		`DELETE FROM blog_reactions WHERE blog_id = ? AND username = ? AND reaction = ?`,
+		`UPDATE blog_reaction_counts SET count = count - 1 WHERE blog_id = ? AND reaction = ? AND count > 0`)
+}
+
+// changeReaction applies a reaction change and adjusts the counter in the same
+// transaction, and only when the change took effect, so concurrent requests
+// never count a reaction twice or lose one.
+func changeReaction(blogID int, username, reaction, changeQuery, countQuery string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(changeQuery, blogID, username, reaction)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(countQuery, blogID, reaction); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// attachReactions loads the reaction counts of every post in blogs with a single query.
+func attachReactions(blogs []Blog) error {
+	if len(blogs) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(blogs))
+	args := make([]interface{}, len(blogs))
+	for i := range blogs {
+		index[blogs[i].ID] = i
+		args[i] = blogs[i].ID
+		blogs[i].Reactions = map[string]int{}
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(blogs)), ",")
+	rows, err := db.Query(`SELECT blog_id, reaction, count FROM blog_reaction_counts WHERE count > 0 AND blog_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var blogID, count int
+		var reaction string
+		if err := rows.Scan(&blogID, &reaction, &count); err != nil {
+			return err
+		}
+		if i, ok := index[blogID]; ok {
+			blogs[i].Reactions[reaction] = count
+		}
+	}
+	return rows.Err()
+}
+
+// GetUserReactions returns the reactions a user left on a post.
+func GetUserReactions(blogID int, username string) ([]string, error) {
+	rows, err := db.Query(`SELECT reaction FROM blog_reactions WHERE blog_id = ? AND username = ? ORDER BY reaction`, blogID, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := []string{}
+	for rows.Next() {
+		var reaction string
+		if err := rows.Scan(&reaction); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, reaction)
+	}
+	return reactions, rows.Err()
+}
+
+// attachViewerReactions sets the reactions the logged-in caller left on a post.
+func attachViewerReactions(b *Blog, claims *JWTClaims) error {
+	if claims == nil {
+		return nil
+	}
+	reactions, err := GetUserReactions(b.ID, claims.Username)
+	if err != nil {
+		return err
+	}
+	b.MyReactions = reactions
+	return nil
+}
+
+// GetReactedBlogs retrieves the posts a user reacted to, most recent reaction
+// first, optionally only those with one reaction type. Private posts of other
+// authors are left out.
+func GetReactedBlogs(username, reaction string) ([]Blog, error) {
+	query := `SELECT ` + blogColumns + ` FROM blogs
+		JOIN (SELECT blog_id, MAX(created_at) AS reacted_at FROM blog_reactions WHERE username = ?`
+	args := []interface{}{username}
+	if reaction != "" {
+		query += ` AND reaction = ?`
+		args = append(args, reaction)
+	}
+	query += ` GROUP BY blog_id) r ON r.blog_id = blogs.id
+		WHERE visibility <> 'private' OR author = ?
+		ORDER BY r.reacted_at DESC, blogs.id DESC`
+	args = append(args, username)
+	return queryBlogs(query, args...)
+}
+
+// reactionTarget loads the post a reaction request refers to, answering 404
+// for posts the caller may not read.
+func reactionTarget(w http.ResponseWriter, r *http.Request) (*Blog, *JWTClaims, string, bool) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, "", false
+	}
+	reaction := r.PathValue("type")
+	if _, ok := ReactionTypes[reaction]; !ok {
+		http.Error(w, "Unknown reaction type", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return nil, nil, "", false
+	}
+
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return nil, nil, "", false
+	}
+	if blog == nil || !visibleTo(blog, claims, grantFromRequest(r, blog.ID)) || blog.Locked {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return nil, nil, "", false
+	}
+	return blog, claims, reaction, true
+}
+
+// writeReactionState responds with the post's current counts and the caller's reactions.
+func writeReactionState(w http.ResponseWriter, blogID int, username string, changed bool) {
+	blogs := []Blog{{ID: blogID}}
+	if err := attachReactions(blogs); err != nil {
+		log.Printf("Failed to count reactions: %v", err)
+		http.Error(w, "Failed to count reactions", http.StatusInternalServerError)
+		return
+	}
+	mine, err := GetUserReactions(blogID, username)
+	if err != nil {
+		log.Printf("Failed to load reactions: %v", err)
+		http.Error(w, "Failed to count reactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changed":      changed,
+		"reactions":    blogs[0].Reactions,
+		"my_reactions": mine,
+	})
+}
+
+// React adds the caller's reaction to a post.
+// @Summary React to a blog post
+// @Description Adds a reaction (like, love, laugh, wow, sad or celebrate) of the logged-in user. Each user can leave each reaction type once; repeating it changes nothing.
+// @Tags Reactions
+// @Produce  json
+// @Param   id    path  int     true  "Blog ID"
+// @Param   type  path  string  true  "Reaction type"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /blogs/{id}/reactions/{type} [put]
+func React(w http.ResponseWriter, r *http.Request) {
+	blog, claims, reaction, ok := reactionTarget(w, r)
+	if !ok {
+		return
+	}
+	changed, err := AddReaction(blog.ID, claims.Username, reaction)
+	if err != nil {
+		log.Printf("Failed to add reaction: %v", err)
+		http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
+		return
+	}
+	writeReactionState(w, blog.ID, claims.Username, changed)
+}
+
+// Unreact removes the caller's reaction from a post.
+// @Summary Remove a reaction from a blog post
+// @Description Withdraws a reaction of the logged-in user; removing a reaction that is not there changes nothing
+// @Tags Reactions
+// @Produce  json
+// @Param   id    path  int     true  "Blog ID"
+// @Param   type  path  string  true  "Reaction type"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /blogs/{id}/reactions/{type} [delete]
+func Unreact(w http.ResponseWriter, r *http.Request) {
+	blog, claims, reaction, ok := reactionTarget(w, r)
+	if !ok {
+		return
+	}
+	changed, err := RemoveReaction(blog.ID, claims.Username, reaction)
+	if err != nil {
+		log.Printf("Failed to remove reaction: %v", err)
+		http.Error(w, "Failed to remove reaction", http.StatusInternalServerError)
+		return
+	}
+	writeReactionState(w, blog.ID, claims.Username, changed)
+}
+
+// GetReacted lists the posts the caller reacted to.
+// @Summary Get the posts I reacted to
+// @Description Lists the posts the logged-in user reacted to, most recent reaction first
+// @Tags Reactions
+// @Produce  json
+// @Param   type  query  string  false  "Only posts with this reaction type"
+// @Success 200 {array} Blog
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /blogs/reacted [get]
+func GetReacted(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	reaction := r.URL.Query().Get("type")
+	if _, ok := ReactionTypes[reaction]; reaction != "" && !ok {
+		http.Error(w, "Unknown reaction type", http.StatusBadRequest)
+		return
+	}
+
+	blogs, err := GetReactedBlogs(claims.Username, reaction)
+	if err != nil {
+		log.Printf("Failed to retrieve reacted blogs: %v", err)
+		http.Error(w, "Failed to retrieve blogs", http.StatusInternalServerError)
+		return
+	}
+	lockListed(blogs, claims)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(blogs)
+}
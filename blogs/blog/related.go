@@ -0,0 +1,368 @@
+package blog
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"shared/events"
+)
+
+// Related posts settings.
+const (
+	maxIndexedTerms     = 50  // Highest weighted terms kept per post
+	titleTermBoost      = 3   // A word in the title counts as often as this many in the content
+	relatedKept         = 20  // Related posts stored per post, before visibility filtering
+	relatedUpdated      = 200 // Most similar posts whose own lists take this post into account
+	defaultRelatedLimit = 5
+	relatedTagShare     = 0.4 // Share of the score from shared tags; the rest is text similarity
+)
+
+var markupPattern = regexp.MustCompile(`<[^>]*>`)
+
+// stopWords are common English words that say nothing about a post's topic.
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`about above after again against all also and any are because been before
+		being below between both but can could did does doing down during each few for from further had has have
+		having her here hers herself him himself his how into its itself just more most myself nor not now off once
+		only other our ours ourselves out over own same she should some such than that the their theirs them
+		themselves then there these they this those through too under until very was were what when where which
+		while who whom why will with would you your yours yourself yourselves`) {
+		stopWords[w] = true
+	}
+}
+
+// RelatedBlog is a post with its similarity to the post it is related to.
+type RelatedBlog struct {
+	Blog
+	Score float64 `json:"score"`
+}
+
+// termCounts splits text into lower-case words of at least three letters, without stop words.
+func termCounts(text string, boost int, counts map[string]int) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if len([]rune(w)) < 3 || len(w) > 64 || stopWords[w] {
+			continue
+		}
+		counts[w] += boost
+	}
+}
+
+// IndexRelated stores the TF-IDF vector of a post and updates the related
+// posts of it and of every post similar to it.
+func IndexRelated(blogID int) error {
+	blog, err := GetBlogByID(blogID)
+	if err != nil || blog == nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	termCounts(blog.Title, titleTermBoost, counts)
+	termCounts(html.UnescapeString(markupPattern.ReplaceAllString(blog.ContentHTML, " ")), 1, counts)
+
+	terms := make([]string, 0, len(counts))
+	args := make([]interface{}, 0, len(counts))
+	total := 0
+	for term, n := range counts {
+		terms = append(terms, term)
+		args = append(args, term)
+		total += n
+	}
+
+	// Inverse document frequencies as of now; vectors of older posts keep theirs
+	var docs int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM blogs`).Scan(&docs); err != nil {
+		return err
+	}
+	df := map[string]int{}
+	if len(terms) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(terms)), ",")
+		rows, err := db.Query(`SELECT term, COUNT(*) FROM blog_terms WHERE blog_id <> ? AND term IN (`+placeholders+`) GROUP BY term`,
+			append([]interface{}{blogID}, args...)...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var term string
+			var n int
+			if err := rows.Scan(&term, &n); err != nil {
+				rows.Close()
+				return err
+			}
+			df[term] = n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	weights := make(map[string]float64, len(terms))
+	for _, term := range terms {
+		tf := float64(counts[term]) / float64(total)
+		weights[term] = tf * (math.Log(float64(docs+1)/float64(df[term]+2)) + 1)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if weights[terms[i]] != weights[terms[j]] {
+			return weights[terms[i]] > weights[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+	if len(terms) > maxIndexedTerms {
+		terms = terms[:maxIndexedTerms]
+	}
+	var norm float64
+	for _, term := range terms {
+		norm += weights[term] * weights[term]
+	}
+	norm = math.Sqrt(norm)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM blog_terms WHERE blog_id = ?`, blogID); err != nil {
+		return err
+	}
+	if len(terms) > 0 && norm > 0 {
+		values := make([]string, len(terms))
+		termArgs := make([]interface{}, 0, 3*len(terms))
+		for i, term := range terms {
+			values[i] = "(?, ?, ?)"
+			termArgs = append(termArgs, blogID, term, weights[term]/norm)
+		}
+		if _, err := tx.Exec(`INSERT INTO blog_terms (blog_id, term, weight) VALUES `+strings.Join(values, ", "), termArgs...); err != nil {
+			return err
+		}
+	}
+
+	// Score every post sharing a term or a tag
+	scores := map[int]float64{}
+	rows, err := tx.Query(`SELECT t2.blog_id, SUM(t1.weight * t2.weight) FROM blog_terms t1
+		JOIN blog_terms t2 ON t2.term = t1.term AND t2.blog_id <> t1.blog_id
+		WHERE t1.blog_id = ? GROUP BY t2.blog_id`, blogID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		var cosine float64
+		if err := rows.Scan(&id, &cosine); err != nil {
+			rows.Close()
+			return err
+		}
+		scores[id] += (1 - relatedTagShare) * cosine
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(`SELECT t2.blog_id, COUNT(*),
+		(SELECT COUNT(*) FROM blog_tags WHERE blog_id = t1.blog_id) + (SELECT COUNT(*) FROM blog_tags WHERE blog_id = t2.blog_id) - COUNT(*)
+		FROM blog_tags t1 JOIN blog_tags t2 ON t2.tag = t1.tag AND t2.blog_id <> t1.blog_id
+		WHERE t1.blog_id = ? GROUP BY t2.blog_id`, blogID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, shared, union int
+		if err := rows.Scan(&id, &shared, &union); err != nil {
+			rows.Close()
+			return err
+		}
+		if union > 0 {
+			scores[id] += relatedTagShare * float64(shared) / float64(union)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ranked := make([]scoredBlog, 0, len(scores))
+	for id, score := range scores {
+		ranked = append(ranked, scoredBlog{id, score})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].blogID > ranked[j].blogID
+	})
+
+	// Replace this post's list and its place in the lists of the others
+	if _, err := tx.Exec(`DELETE FROM blog_related WHERE blog_id = ? OR related_id = ?`, blogID, blogID); err != nil {
+		return err
+	}
+	if len(ranked) > relatedUpdated {
+		ranked = ranked[:relatedUpdated]
+	}
+	for i, e := range ranked {
+		if i < relatedKept {
+			if _, err := tx.Exec(`INSERT INTO blog_related (blog_id, related_id, score) VALUES (?, ?, ?)`, blogID, e.blogID, e.score); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO blog_related (blog_id, related_id, score) VALUES (?, ?, ?)`, e.blogID, blogID, e.score); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM blog_related WHERE blog_id = ? AND related_id NOT IN (
+			SELECT related_id FROM (SELECT related_id FROM blog_related WHERE blog_id = ? ORDER BY score DESC, related_id DESC LIMIT ?) AS kept)`,
+			e.blogID, e.blogID, relatedKept); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SubscribeRelated indexes posts for related posts when events published on
+// bus report that they were created or updated, so the work happens after
+// the request that saved them.
+func SubscribeRelated(bus *events.Bus) {
+	bus.Subscribe(indexFromEvent)
+}
+
+func indexFromEvent(ctx context.Context, e events.Event) error {
+	if e.Type != EventBlogCreated && e.Type != EventBlogUpdated {
+		return nil
+	}
+	var data BlogEvent
+	if err := json.Unmarshal(e.Data, &data); err != nil {
+		return err
+	}
+	return IndexRelated(data.ID)
+}
+
+// IndexMissingRelated indexes the posts stored before related posts existed.
+func IndexMissingRelated(ctx context.Context) {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM blogs WHERE id NOT IN (SELECT DISTINCT blog_id FROM blog_terms) ORDER BY id`)
+	if err != nil {
+		log.Printf("Failed to find posts to index: %v", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := IndexRelated(id); err != nil {
+			log.Printf("Failed to index blog %d for related posts: %v", id, err)
+		}
+	}
+}
+
+// getRelated returns the stored related posts of a post, best first.
+func getRelated(blogID int) ([]scoredBlog, error) {
+	rows, err := db.Query(`SELECT related_id, score FROM blog_related WHERE blog_id = ? ORDER BY score DESC, related_id DESC`, blogID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var related []scoredBlog
+	for rows.Next() {
+		var e scoredBlog
+		if err := rows.Scan(&e.blogID, &e.score); err != nil {
+			return nil, err
+		}
+		related = append(related, e)
+	}
+	return related, rows.Err()
+}
+
+// GetRelated lists published posts similar to a post.
+// @Summary Get related blog posts
+// @Description Public posts that share tags and vocabulary with a post, most similar first
+// @Tags Blog
+// @Produce  json
+// @Param   id     path   int  true   "Blog ID"
+// @Param   limit  query  int  false  "Number of posts, at most 20 (default 5)"
+// @Success 200 {array} RelatedBlog
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /blogs/{id}/related [get]
+func GetRelated(w http.ResponseWriter, r *http.Request) {
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	limit := defaultRelatedLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > relatedKept {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return
+	}
+	if blog == nil || !visibleTo(blog, optionalClaims(r), grantFromRequest(r, blog.ID)) {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+
+	entries, err := getRelated(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve related posts: %v", err)
+		http.Error(w, "Failed to retrieve related posts", http.StatusInternalServerError)
+		return
+	}
+	ids := make([]int, len(entries))
+	scores := make(map[int]float64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.blogID
+		scores[e.blogID] = e.score
+	}
+	blogs, err := GetBlogsByIDs(ids)
+	if err != nil {
+		log.Printf("Failed to retrieve related posts: %v", err)
+		http.Error(w, "Failed to retrieve related posts", http.StatusInternalServerError)
+		return
+	}
+
+	related := []RelatedBlog{}
+	for _, b := range blogs {
+		if b.Visibility != VisibilityPublic {
+			continue
+		}
+		related = append(related, RelatedBlog{Blog: b, Score: math.Round(scores[b.ID]*1000) / 1000})
+		if len(related) == limit {
+			break
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(related)
+}
@@ -0,0 +1,167 @@
+package blog
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags lists the HTML elements kept by SanitizeHTML with the attributes
+// each may carry. Any other element is removed but its text is kept.
+var allowedTags = map[string][]string{
+	"p": nil, "br": nil, "hr": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"strong": nil, "b": nil, "em": nil, "i": nil, "u": nil, "s": nil, "del": nil,
+	"sup": nil, "sub": nil, "code": {"class"}, "pre": nil, "blockquote": nil,
+	"ul": nil, "ol": {"start"}, "li": nil,
+	"a":     {"href", "title"},
+	"img":   {"src", "alt", "title", "width", "height"},
+	"table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": nil, "td": nil,
+}
+
+// droppedTags are removed together with everything inside them.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"textarea": true, "noscript": true, "template": true, "svg": true, "math": true,
+}
+
+// voidTags never have an end tag.
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// impliedEnds lists, for elements whose end tag may be left out, the open
+// elements a new one closes, so that <li>one<li>two gives two items.
+var impliedEnds = map[string][]string{
+	"p": {"p"}, "li": {"li"}, "tr": {"tr", "td", "th"}, "td": {"td", "th"}, "th": {"td", "th"},
+}
+
+// Attribute values that need more than an allow-listed name.
+var (
+	codeClassPattern = regexp.MustCompile(`^language-[a-zA-Z0-9_+-]+$`)
+	numberPattern    = regexp.MustCompile(`^[0-9]{1,5}$`)
+	mediaURLPattern  = regexp.MustCompile(`^media:(\d+)(?:/(original|\d+))?$`)
+)
+
+// SanitizeHTML filters untrusted HTML down to a strict allow-list of elements
+// and attributes, drops unsafe URLs, rewrites media:{id} references to their
+// URLs and returns well-formed markup.
+func SanitizeHTML(input string) string {
+	var out strings.Builder
+	var open []string // Stack of elements written to out and not yet closed
+	skipDepth := 0    // Nesting depth inside a dropped element
+
+	z := html.NewTokenizer(strings.NewReader(input))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break // io.EOF, or input too malformed to continue
+		}
+		tok := z.Token()
+		name := tok.Data
+
+		if skipDepth > 0 {
+			switch {
+			case tt == html.StartTagToken && droppedTags[name]:
+				skipDepth++
+			case tt == html.EndTagToken && droppedTags[name]:
+				skipDepth--
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			out.WriteString(html.EscapeString(tok.Data))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedTags[name] {
+				if tt == html.StartTagToken {
+					skipDepth = 1
+				}
+				continue
+			}
+			allowedAttrs, ok := allowedTags[name]
+			if !ok {
+				continue
+			}
+			for len(open) > 0 && slices.Contains(impliedEnds[name], open[len(open)-1]) {
+				out.WriteString("</" + open[len(open)-1] + ">")
+				open = open[:len(open)-1]
+			}
+			out.WriteString("<" + name)
+			for _, attr := range tok.Attr {
+				if value, ok := sanitizeAttr(name, attr, allowedAttrs); ok {
+					fmt.Fprintf(&out, ` %s="%s"`, attr.Key, html.EscapeString(value))
+				}
+			}
+			if name == "a" {
+				out.WriteString(` rel="nofollow noopener"`)
+			}
+			out.WriteString(">")
+			if !voidTags[name] {
+				open = append(open, name)
+			}
+
+		case html.EndTagToken:
+			// Close back to the matching element; stray end tags are ignored
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == name {
+					for j := len(open) - 1; j >= i; j-- {
+						out.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
+
+// sanitizeAttr returns the value to keep for an attribute, if any.
+func sanitizeAttr(tag string, attr html.Attribute, allowed []string) (string, bool) {
+	if attr.Namespace != "" || !slices.Contains(allowed, attr.Key) {
+		return "", false
+	}
+	switch attr.Key {
+	case "href", "src":
+		return sanitizeURL(attr.Val)
+	case "class":
+		return attr.Val, tag == "code" && codeClassPattern.MatchString(attr.Val)
+	case "width", "height", "start":
+		return attr.Val, numberPattern.MatchString(attr.Val)
+	}
+	return attr.Val, true
+}
+
+// sanitizeURL allows http, https and mailto URLs plus relative ones, and maps
+// media:{id} or media:{id}/{variant} to the URL serving that upload.
+func sanitizeURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if m := mediaURLPattern.FindStringSubmatch(raw); m != nil {
+		variant := m[2]
+		if variant == "" {
+			variant = "original"
+		}
+		return "/media/" + m[1] + "/" + variant, true
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return u.String(), true
+	case "":
+		return u.String(), true // Relative URL
+	}
+	return "", false
+}
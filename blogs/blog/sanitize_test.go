@@ -0,0 +1,70 @@
+package blog
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		// Allowed markup
+		{"paragraph", `<p>Hello <strong>world</strong></p>`, `<p>Hello <strong>world</strong></p>`},
+		{"link", `<a href="https://example.com/?a=1&amp;b=2" title="Example">x</a>`, `<a href="https://example.com/?a=1&amp;b=2" title="Example" rel="nofollow noopener">x</a>`},
+		{"relative and mailto links", `<a href="/blogs/1">a</a><a href="mailto:me@example.com">b</a>`, `<a href="/blogs/1" rel="nofollow noopener">a</a><a href="mailto:me@example.com" rel="nofollow noopener">b</a>`},
+		{"media reference", `<img src="media:12/640" alt="Cat">`, `<img src="/media/12/640" alt="Cat">`},
+		{"code language", `<code class="language-go">x := 1</code>`, `<code class="language-go">x := 1</code>`},
+		{"text is escaped", `1 < 2 & "quoted"`, `1 &lt; 2 &amp; &#34;quoted&#34;`},
+
+		// Unsafe URLs
+		{"javascript URL", `<a href="javascript:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"mixed case scheme", `<a href="JaVaScRiPt:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"leading space", `<a href="  javascript:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"entity-encoded letter", `<a href="jav&#x61;script:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"entity-encoded colon", `<a href="javascript&colon;alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"encoded tab inside scheme", `<a href="java&#9;script:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"encoded control character before scheme", `<a href="&#1;javascript:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"vbscript URL", `<a href="vbscript:msgbox(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"data URL image", `<img src="data:image/svg+xml;base64,PHN2Zz4=" alt="x">`, `<img alt="x">`},
+
+		// Dropped elements
+		{"script", `<p>a</p><script>alert(1)</script><p>b</p>`, `<p>a</p><p>b</p>`},
+		{"svg", `<svg onload="alert(1)"><circle r="1"/></svg>after`, `after`},
+		{"script inside svg", `<svg><script>alert(1)</script></svg><p>ok</p>`, `<p>ok</p>`},
+		{"self-closing svg", `<svg/>after`, `after`},
+		{"nested dropped elements", `<svg><svg></svg><p>inner</p></svg>after`, `after`},
+		{"noscript", `<noscript><p>Enable JavaScript</p></noscript>ok`, `ok`},
+		{"noscript breakout", `<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>`, `<img src="x">&#34;&gt;`},
+		{"style", `<style>body{display:none}</style>ok`, `ok`},
+		{"iframe", `<iframe src="https://evil.example"></iframe>ok`, `ok`},
+		{"comment", `<!-- <script>alert(1)</script> -->text`, `text`},
+		{"unknown element keeps its text", `<div><span>text</span></div>`, `text`},
+
+		// Attribute injection
+		{"event handler", `<p onclick="alert(1)" style="color:red">hi</p>`, `<p>hi</p>`},
+		{"quote in attribute value", `<a href="/x" title='a" onmouseover="alert(1)'>x</a>`, `<a href="/x" title="a&#34; onmouseover=&#34;alert(1)" rel="nofollow noopener">x</a>`},
+		{"image handlers and bad sizes", `<img src="/a.png" width="100" height="1e9" onerror="alert(1)">`, `<img src="/a.png" width="100">`},
+		{"class outside code", `<p class="admin">x</p>`, `<p>x</p>`},
+		{"code class with extra classes", `<code class="language-go hidden">x</code>`, `<code>x</code>`},
+		{"namespaced attribute", `<a xlink:href="javascript:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"rel cannot be overridden", `<a href="/x" rel="opener">x</a>`, `<a href="/x" rel="nofollow noopener">x</a>`},
+
+		// Unclosed and stray tags
+		{"unclosed inline", `<p><strong>bold`, `<p><strong>bold</strong></p>`},
+		{"misnested end tag", `<a href="/x"><em>y</a>z`, `<a href="/x" rel="nofollow noopener"><em>y</em></a>z`},
+		{"stray end tag", `<p>a</div>b</p>`, `<p>ab</p>`},
+		{"implied list item ends", `<ul><li>one<li>two</ul>`, `<ul><li>one</li><li>two</li></ul>`},
+		{"implied paragraph end", `<p>one<p>two`, `<p>one</p><p>two</p>`},
+		{"implied cell ends", `<table><tr><td>a<td>b<tr><td>c</table>`, `<table><tr><td>a</td><td>b</td></tr><tr><td>c</td></tr></table>`},
+		{"unclosed script", `ok<script>alert(1)`, `ok`},
+		{"unterminated attribute", `ok<p title="x>hi`, `ok`},
+		{"end tag of void element", `a<br></br>b`, `a<br>b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeHTML(tt.input); got != tt.want {
+				t.Errorf("SanitizeHTML(%q)\n got %q\nwant %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
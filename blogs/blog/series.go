@@ -0,0 +1,488 @@
+package blog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shared/mysqlerr"
+	"shared/validation"
+)
+
+// Series limits, in characters.
+const (
+	maxSeriesTitle       = 200
+	maxSeriesDescription = 2000
+)
+
+// ErrInOtherSeries is returned when a post already belongs to another series.
+var ErrInOtherSeries = errors.New("post belongs to another series")
+
+// Series is an author's ordered collection of posts, such as a multi-part tutorial.
+type Series struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Author      string    `json:"author"`
+	Parts       []Blog    `json:"parts"` // In reading order; only on GET /series/{id}
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SeriesRequest describes a new series.
+type SeriesRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// SeriesLink points to another part of a series.
+type SeriesLink struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+}
+
+// SeriesNav places a post within its series.
+type SeriesNav struct {
+	ID       int         `json:"id"`
+	Title    string      `json:"title"`
+	Part     int         `json:"part"`  // Position of the post, starting at 1
+	Parts    int         `json:"parts"` // Number of parts the reader can see
+	Previous *SeriesLink `json:"previous"`
+	Next     *SeriesLink `json:"next"`
+}
+
+// Validate checks a series request.
+func (req SeriesRequest) Validate() validation.Errors {
+	errs := validation.Errors{}
+	switch {
+	case strings.TrimSpace(req.Title) == "":
+		errs["title"] = "is required"
+	case utf8.RuneCountInString(req.Title) > maxSeriesTitle:
+		errs["title"] = validation.AtMost(maxSeriesTitle, "characters")
+	}
+	if utf8.RuneCountInString(req.Description) > maxSeriesDescription {
+		errs["description"] = validation.AtMost(maxSeriesDescription, "characters")
+	}
+	return errs
+}
+
+func seriesParts(seriesID int) savedCollection {
+	return savedCollection{
+		table: "series_posts",
+		where: "series_id = ?",
+		args:  []interface{}{seriesID},
+		insert: func(tx *sql.Tx, blogID, position int) error {
+			_, err := tx.Exec(`INSERT INTO series_posts (series_id, blog_id, position) VALUES (?, ?, ?)`, seriesID, blogID, position)
+			return err
+		},
+	}
+}
+
+// CreateSeries stores a new series.
+func CreateSeries(s *Series) error {
+	res, err := db.Exec(`INSERT INTO series (title, description, author) VALUES (?, ?, ?)`, s.Title, s.Description, s.Author)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	s.ID = int(id)
+	s.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	return nil
+}
+
+// GetSeriesByID retrieves a series without its parts, or nil if it does not exist.
+func GetSeriesByID(id int) (*Series, error) {
+	s := &Series{}
+	err := db.QueryRow(`SELECT id, title, description, author, created_at FROM series WHERE id = ?`, id).
+		Scan(&s.ID, &s.Title, &s.Description, &s.Author, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// DeleteSeries deletes a series; its posts are kept.
+func DeleteSeries(id int) error {
+	_, err := db.Exec(`DELETE FROM series WHERE id = ?`, id)
+	return err
+}
+
+// AddSeriesPost appends a post to a series. A post belongs to at most one
+// series, which the unique blog_id of series_posts enforces; adding a post to
+// its own series again changes nothing.
+func AddSeriesPost(seriesID, blogID int) error {
+	err := seriesParts(seriesID).add(blogID)
+	if !mysqlerr.IsDuplicateEntry(err) {
+		return err
+	}
+	var current int
+	if err := db.QueryRow(`SELECT series_id FROM series_posts WHERE blog_id = ?`, blogID).Scan(&current); err != nil {
+		return err
+	}
+	if current != seriesID {
+		return ErrInOtherSeries
+	}
+	return nil
+}
+
+// getSeriesPosts returns the posts of a series the caller may see, in order.
+func getSeriesPosts(s *Series, claims *JWTClaims) ([]Blog, error) {
+	query := `SELECT ` + blogColumns + ` FROM blogs WHERE id IN (SELECT blog_id FROM series_posts WHERE series_id = ?)`
+	if !canManageSeries(s, claims) {
+		query += ` AND ` + listedCondition
+	}
+	blogs, err := queryBlogs(query, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT blog_id FROM series_posts WHERE series_id = ? ORDER BY position, blog_id`, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byID := make(map[int]Blog, len(blogs))
+	for _, b := range blogs {
+		byID[b.ID] = b
+	}
+	ordered := make([]Blog, 0, len(blogs))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if b, ok := byID[id]; ok {
+			ordered = append(ordered, b)
+		}
+	}
+	return ordered, rows.Err()
+}
+
+// attachSeriesNav sets the series navigation of a single post. Parts the
+// caller may not see are skipped, as in GET /series/{id}.
+func attachSeriesNav(b *Blog, claims *JWTClaims) error {
+	var seriesID int
+	err := db.QueryRow(`SELECT series_id FROM series_posts WHERE blog_id = ?`, b.ID).Scan(&seriesID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s, err := GetSeriesByID(seriesID)
+	if err != nil || s == nil {
+		return err
+	}
+
+	query := `SELECT b.id, b.title, COALESCE(b.slug, '') FROM series_posts p JOIN blogs b ON b.id = p.blog_id WHERE p.series_id = ?`
+	args := []interface{}{seriesID}
+	if !canManageSeries(s, claims) {
+		// The post being read counts even when it is unlisted
+		query += ` AND (b.visibility IN ` + listedVisibilities + ` OR b.id = ?)`
+		args = append(args, b.ID)
+	}
+	rows, err := db.Query(query+` ORDER BY p.position, p.blog_id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var parts []SeriesLink
+	for rows.Next() {
+		var l SeriesLink
+		if err := rows.Scan(&l.ID, &l.Title, &l.Slug); err != nil {
+			return err
+		}
+		parts = append(parts, l)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	nav := &SeriesNav{ID: s.ID, Title: s.Title, Parts: len(parts)}
+	for i := range parts {
+		if parts[i].ID != b.ID {
+			continue
+		}
+		nav.Part = i + 1
+		if i > 0 {
+			nav.Previous = &parts[i-1]
+		}
+		if i+1 < len(parts) {
+			nav.Next = &parts[i+1]
+		}
+	}
+	b.Series = nav
+	return nil
+}
+
+// canManageSeries reports whether the caller owns the series or is an Admin.
+func canManageSeries(s *Series, claims *JWTClaims) bool {
+	return claims != nil && (claims.Username == s.Author || claims.Role == "Admin")
+}
+
+// managedSeries loads the series in the path and checks that the caller may change it.
+func managedSeries(w http.ResponseWriter, r *http.Request) (*Series, bool) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	id, err := strconv.Atoi(r.PathValue("series"))
+	if err != nil {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return nil, false
+	}
+	s, err := GetSeriesByID(id)
+	if err != nil {
+		log.Printf("Failed to retrieve series: %v", err)
+		http.Error(w, "Failed to retrieve series", http.StatusInternalServerError)
+		return nil, false
+	}
+	if s == nil {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !canManageSeries(s, claims) {
+		http.Error(w, "Forbidden: only the owner and Admins can change a series", http.StatusForbidden)
+		return nil, false
+	}
+	return s, true
+}
+
+// CreateSeriesHandler creates a series owned by the caller.
+// @Summary Create a series
+// @Description Creates an empty series owned by the authenticated user
+// @Tags Series
+// @Accept  json
+// @Produce  json
+// @Param   series  body  SeriesRequest  true  "Series"
+// @Success 201 {object} Series
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /series [post]
+func CreateSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req SeriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
+
+	s := &Series{Title: strings.TrimSpace(req.Title), Description: req.Description, Author: claims.Username, Parts: []Blog{}}
+	if err := CreateSeries(s); err != nil {
+		log.Printf("Failed to create series: %v", err)
+		http.Error(w, "Failed to create series", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// GetSeriesHandler returns a series with its parts in reading order.
+// @Summary Get a series
+// @Description Returns a series and its parts in order. Private and unlisted parts are only shown to the owner and Admins.
+// @Tags Series
+// @Produce  json
+// @Param   series  path  int  true  "Series ID"
+// @Success 200 {object} Series
+// @Failure 404 {object} map[string]string
+// @Router /series/{series} [get]
+func GetSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("series"))
+	if err != nil {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+	s, err := GetSeriesByID(id)
+	if err != nil {
+		log.Printf("Failed to retrieve series: %v", err)
+		http.Error(w, "Failed to retrieve series", http.StatusInternalServerError)
+		return
+	}
+	if s == nil {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+
+	claims := optionalClaims(r)
+	if s.Parts, err = getSeriesPosts(s, claims); err != nil {
+		log.Printf("Failed to retrieve series posts: %v", err)
+		http.Error(w, "Failed to retrieve series", http.StatusInternalServerError)
+		return
+	}
+	lockListed(s.Parts, claims)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+// DeleteSeriesHandler deletes a series.
+// @Summary Delete a series
+// @Description Deletes a series; its posts are kept. Owner and Admins only.
+// @Tags Series
+// @Produce  json
+// @Param   series  path  int  true  "Series ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /series/{series} [delete]
+func DeleteSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := managedSeries(w, r)
+	if !ok {
+		return
+	}
+	if err := DeleteSeries(s.ID); err != nil {
+		log.Printf("Failed to delete series: %v", err)
+		http.Error(w, "Failed to delete series", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Series deleted",
+	})
+}
+
+// AddSeriesPostHandler appends a post to a series.
+// @Summary Add a post to a series
+// @Description Appends one of the series owner's posts as its last part. Owner and Admins only; a post belongs to at most one series.
+// @Tags Series
+// @Produce  json
+// @Param   series  path  int  true  "Series ID"
+// @Param   id      path  int  true  "Blog ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /series/{series}/posts/{id} [put]
+func AddSeriesPostHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := managedSeries(w, r)
+	if !ok {
+		return
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return
+	}
+	if blog == nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	if blog.Author != s.Author {
+		http.Error(w, "Only posts by the series owner can be added", http.StatusBadRequest)
+		return
+	}
+
+	err = AddSeriesPost(s.ID, blogID)
+	if err == ErrInOtherSeries {
+		http.Error(w, "The post already belongs to another series", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Failed to add post to series: %v", err)
+		http.Error(w, "Failed to add post to series", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Post added to series",
+	})
+}
+
+// RemoveSeriesPostHandler takes a post out of a series.
+// @Summary Remove a post from a series
+// @Description Removes a post from a series; the post itself is kept. Owner and Admins only.
+// @Tags Series
+// @Produce  json
+// @Param   series  path  int  true  "Series ID"
+// @Param   id      path  int  true  "Blog ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /series/{series}/posts/{id} [delete]
+func RemoveSeriesPostHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := managedSeries(w, r)
+	if !ok {
+		return
+	}
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	if err := seriesParts(s.ID).remove(blogID); err != nil {
+		log.Printf("Failed to remove post from series: %v", err)
+		http.Error(w, "Failed to remove post from series", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Post removed from series",
+	})
+}
+
+// ReorderSeriesHandler changes the order of a series' parts.
+// @Summary Reorder a series
+// @Description Sets the reading order of a series. The request must list every part exactly once. Owner and Admins only.
+// @Tags Series
+// @Accept  json
+// @Produce  json
+// @Param   series  path  int           true  "Series ID"
+// @Param   order   body  OrderRequest  true  "Post IDs in their new order"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /series/{series}/order [put]
+func ReorderSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := managedSeries(w, r)
+	if !ok {
+		return
+	}
+	var req OrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	err := seriesParts(s.ID).reorder(req.BlogIDs)
+	if err == errOrderMismatch {
+		http.Error(w, "The order must list every part exactly once", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Failed to reorder series: %v", err)
+		http.Error(w, "Failed to reorder series", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Order updated",
+	})
+}
@@ -0,0 +1,187 @@
+package blog
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSitemapURLs is the most URLs a single sitemap may list, per the sitemaps.org protocol.
+const maxSitemapURLs = 50000
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+	lastMod time.Time
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+func newSitemapURL(loc string, lastMod time.Time) sitemapURL {
+	lastMod = lastMod.UTC().Truncate(time.Second)
+	return sitemapURL{Loc: loc, LastMod: lastMod.Format(time.RFC3339), lastMod: lastMod}
+}
+
+// sitemapURLs lists every public page: public posts, then author pages, then tag pages.
+func sitemapURLs() ([]sitemapURL, error) {
+	var urls []sitemapURL
+
+	rows, err := db.Query(`SELECT id, COALESCE(slug, ''), updated_at FROM blogs WHERE ` + publicCondition + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var b Blog
+		if err := rows.Scan(&b.ID, &b.Slug, &b.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		urls = append(urls, newSitemapURL(BlogURL(&b), b.UpdatedAt))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT author, MAX(updated_at) FROM blogs WHERE author <> ? AND `+publicCondition+` GROUP BY author ORDER BY author`, AnonymousAuthor)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var author string
+		var updated time.Time
+		if err := rows.Scan(&author, &updated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		urls = append(urls, newSitemapURL(siteURL+"/authors/"+url.PathEscape(author)+"/blogs", updated))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT t.tag, MAX(b.updated_at) FROM blog_tags t JOIN blogs b ON b.id = t.blog_id WHERE b.visibility = 'public' GROUP BY t.tag ORDER BY t.tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		var updated time.Time
+		if err := rows.Scan(&tag, &updated); err != nil {
+			return nil, err
+		}
+		urls = append(urls, newSitemapURL(siteURL+"/tags/"+url.PathEscape(tag)+"/blogs", updated))
+	}
+	return urls, rows.Err()
+}
+
+// ServeSitemap serves the sitemap, or a sitemap index when there are more than 50,000 URLs.
+// @Summary Get the sitemap
+// @Description Lists published posts, author pages and tag pages with their last modification time. Beyond 50,000 URLs this is a sitemap index pointing at /sitemaps/{page}.xml.
+// @Tags Sitemap
+// @Produce  xml
+// @Success 200 {string} string "Sitemap XML"
+// @Success 304 {string} string "Not Modified"
+// @Failure 500 {string} string "Failed to build sitemap"
+// @Router /sitemap.xml [get]
+func ServeSitemap(w http.ResponseWriter, r *http.Request) {
+	urls, err := sitemapURLs()
+	if err != nil {
+		log.Printf("Failed to build sitemap: %v", err)
+		http.Error(w, "Failed to build sitemap", http.StatusInternalServerError)
+		return
+	}
+	if len(urls) <= maxSitemapURLs {
+		writeSitemap(w, r, urlSet{URLs: urls}, urls)
+		return
+	}
+
+	var index sitemapIndex
+	for page := 1; (page-1)*maxSitemapURLs < len(urls); page++ {
+		chunk := sitemapPage(urls, page)
+		index.Sitemaps = append(index.Sitemaps, newSitemapURL(siteURL+"/sitemaps/"+strconv.Itoa(page)+".xml", latestLastMod(chunk)))
+	}
+	writeSitemap(w, r, index, urls)
+}
+
+// ServeSitemapPage serves one page of a sitemap split by the sitemap index.
+// @Summary Get a sitemap page
+// @Description One sitemap of at most 50,000 URLs listed in the sitemap index
+// @Tags Sitemap
+// @Produce  xml
+// @Param   page  path  string  true  "Page file, e.g. 1.xml"
+// @Success 200 {string} string "Sitemap XML"
+// @Success 304 {string} string "Not Modified"
+// @Failure 404 {string} string "Sitemap not found"
+// @Failure 500 {string} string "Failed to build sitemap"
+// @Router /sitemaps/{page} [get]
+func ServeSitemapPage(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("page"), ".xml"))
+	if err != nil || page < 1 || !strings.HasSuffix(r.PathValue("page"), ".xml") {
+		http.Error(w, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+
+	urls, err := sitemapURLs()
+	if err != nil {
+		log.Printf("Failed to build sitemap: %v", err)
+		http.Error(w, "Failed to build sitemap", http.StatusInternalServerError)
+		return
+	}
+	chunk := sitemapPage(urls, page)
+	if len(chunk) == 0 {
+		http.Error(w, "Sitemap not found", http.StatusNotFound)
+		return
+	}
+	writeSitemap(w, r, urlSet{URLs: chunk}, chunk)
+}
+
+// sitemapPage returns the URLs on a 1-based sitemap page.
+func sitemapPage(urls []sitemapURL, page int) []sitemapURL {
+	start := (page - 1) * maxSitemapURLs
+	if start >= len(urls) {
+		return nil
+	}
+	return urls[start:min(start+maxSitemapURLs, len(urls))]
+}
+
+func latestLastMod(urls []sitemapURL) time.Time {
+	var latest time.Time
+	for _, u := range urls {
+		if u.lastMod.After(latest) {
+			latest = u.lastMod
+		}
+	}
+	return latest
+}
+
+func writeSitemap(w http.ResponseWriter, r *http.Request, doc interface{}, urls []sitemapURL) {
+	if lastMod := latestLastMod(urls); !lastMod.IsZero() {
+		w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+		if notModified(r, "", lastMod) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(doc); err != nil {
+		log.Printf("Failed to write sitemap: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package blog
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// maxSlugLength is the longest slug generated from a title, in bytes, before a collision suffix.
+const maxSlugLength = 100
+
+// ErrSlugTaken is returned when an author picks a slug used by another post.
+var ErrSlugTaken = errors.New("slug already in use")
+
+// transliterations spells common non-ASCII letters in ASCII.
+var transliterations = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "ae", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "oe", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ș': "s", 'ß': "ss", 'ť': "t", 'ţ': "t", 'ț': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "ue", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i",
+	'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s",
+	'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ό': "o", 'ύ': "y", 'ώ': "o",
+}
+
+// Slugify turns text into a lower-case, hyphen-separated, URL-safe slug,
+// transliterating accented, Cyrillic and Greek letters. Other characters are
+// dropped, so the result may be empty.
+func Slugify(text string) string {
+	var out strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(text) {
+		var s string
+		switch {
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			s = string(r)
+		case r == '&':
+			s = "and"
+		default:
+			s = transliterations[r]
+		}
+		if s == "" {
+			// Letters without a transliteration vanish, everything else separates words
+			if _, ok := transliterations[r]; !ok && r != '\'' && r != '’' {
+				hyphen = out.Len() > 0
+			}
+			continue
+		}
+		if hyphen {
+			out.WriteByte('-')
+			hyphen = false
+		}
+		out.WriteString(s)
+	}
+
+	slug := out.String()
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		if i := strings.LastIndexByte(slug, '-'); i > maxSlugLength/2 {
+			slug = slug[:i]
+		}
+		slug = strings.TrimRight(slug, "-")
+	}
+	return slug
+}
+
+// slugTaken reports whether slug is the current or a former slug of a post other than blogID.
+func slugTaken(tx *sql.Tx, slug string, blogID int) (bool, error) {
+	var taken bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM blogs WHERE slug = ? AND id <> ?)
+		OR EXISTS (SELECT 1 FROM blog_slug_redirects WHERE slug = ? AND blog_id <> ?)`,
+		slug, blogID, slug, blogID).Scan(&taken)
+	return taken, err
+}
+
+// uniqueSlug derives a free slug from title, appending -2, -3, ... on collisions.
+func uniqueSlug(tx *sql.Tx, title string, blogID int) (string, error) {
+	base := Slugify(title)
+	if base == "" {
+		base = "post"
+	}
+	slug := base
+	for n := 2; ; n++ {
+		taken, err := slugTaken(tx, slug, blogID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+		slug = base + "-" + strconv.Itoa(n)
+	}
+}
+
+// chooseSlug returns the slug a post should get: the author's choice if it is
+// free, or one generated from the title.
+func chooseSlug(tx *sql.Tx, requested, title string, blogID int) (string, error) {
+	if requested == "" {
+		return uniqueSlug(tx, title, blogID)
+	}
+	slug := Slugify(requested)
+	taken, err := slugTaken(tx, slug, blogID)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrSlugTaken
+	}
+	return slug, nil
+}
+
+// changeSlug moves a post to a new slug and keeps the old one as a redirect.
+func changeSlug(tx *sql.Tx, blogID int, oldSlug, newSlug string) error {
+	// A post may take back one of its own former slugs
+	if _, err := tx.Exec(`DELETE FROM blog_slug_redirects WHERE slug = ? AND blog_id = ?`, newSlug, blogID); err != nil {
+		return err
+	}
+	if oldSlug != "" {
+		if _, err := tx.Exec(`INSERT INTO blog_slug_redirects (slug, blog_id) VALUES (?, ?)`, oldSlug, blogID); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`UPDATE blogs SET slug = ? WHERE id = ?`, newSlug, blogID)
+	return err
+}
+
+// GetBlogBySlug retrieves a post by its current slug.
+func GetBlogBySlug(slug string) (*Blog, error) {
+	blog, err := scanBlog(db.QueryRow(`SELECT `+blogColumns+` FROM blogs WHERE slug = ?`, slug).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	blogs := []Blog{*blog}
+	if err := attachDetails(blogs); err != nil {
+		return nil, err
+	}
+	return &blogs[0], nil
+}
+
+// GetRedirectedBlog retrieves the post that used to be at slug, or nil if
+// there is none.
+func GetRedirectedBlog(slug string) (*Blog, error) {
+	var id int
+	err := db.QueryRow(`SELECT blog_id FROM blog_slug_redirects WHERE slug = ?`, slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return GetBlogByID(id)
+}
+
+// AssignMissingSlugs gives a slug to every post stored before slugs existed.
+func AssignMissingSlugs() error {
+	rows, err := db.Query(`SELECT id, title FROM blogs WHERE slug IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	type post struct {
+		id    int
+		title string
+	}
+	var posts []post
+	for rows.Next() {
+		var p post
+		if err := rows.Scan(&p.id, &p.title); err != nil {
+			rows.Close()
+			return err
+		}
+		posts = append(posts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range posts {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		slug, err := uniqueSlug(tx, p.title, p.id)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE blogs SET slug = ? WHERE id = ?`, slug, p.id)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return nil
+}
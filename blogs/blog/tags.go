@@ -0,0 +1,103 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Tag limits.
+const (
+	maxTags      = 10
+	maxTagLength = 50
+)
+
+var (
+	tagSeparatorPattern = regexp.MustCompile(`[\s_]+`)
+	tagPattern          = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+)
+
+// NormalizeTag lower-cases a tag and joins its words with hyphens, so
+// "Go Programming" and "go-programming" are the same tag.
+func NormalizeTag(tag string) string {
+	return tagSeparatorPattern.ReplaceAllString(strings.ToLower(strings.TrimSpace(tag)), "-")
+}
+
+// normalizeTags normalizes and de-duplicates tags, keeping their order.
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// validateTags returns a message describing what is wrong with normalized tags, or "".
+func validateTags(tags []string) string {
+	if len(tags) > maxTags {
+		return fmt.Sprintf("at most %d tags are allowed", maxTags)
+	}
+	for _, tag := range tags {
+		if len(tag) > maxTagLength || !tagPattern.MatchString(tag) {
+			return fmt.Sprintf("tags may only contain letters, digits and hyphens and be at most %d characters", maxTagLength)
+		}
+	}
+	return ""
+}
+
+// saveTags replaces the tags of a post.
+func saveTags(tx *sql.Tx, blogID int, tags []string) error {
+	if _, err := tx.Exec(`DELETE FROM blog_tags WHERE blog_id = ?`, blogID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT INTO blog_tags (blog_id, tag) VALUES (?, ?)`, blogID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachTags loads the tags of every post in blogs with a single query.
+func attachTags(blogs []Blog) error {
+	if len(blogs) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(blogs))
+	args := make([]interface{}, len(blogs))
+	for i := range blogs {
+		index[blogs[i].ID] = i
+		args[i] = blogs[i].ID
+		blogs[i].Tags = []string{}
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(blogs)), ",")
+	rows, err := db.Query(`SELECT blog_id, tag FROM blog_tags WHERE blog_id IN (`+placeholders+`) ORDER BY tag`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var blogID int
+		var tag string
+		if err := rows.Scan(&blogID, &tag); err != nil {
+			return err
+		}
+		if i, ok := index[blogID]; ok {
+			blogs[i].Tags = append(blogs[i].Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+// GetBlogsByTag retrieves the listed posts with a tag, newest first.
+func GetBlogsByTag(tag string) ([]Blog, error) {
+	return queryBlogs(`SELECT `+blogColumns+` FROM blogs WHERE id IN (SELECT blog_id FROM blog_tags WHERE tag = ?) AND `+listedCondition+` ORDER BY created_at DESC`, tag)
+}
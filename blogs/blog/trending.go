@@ -0,0 +1,264 @@
+package blog
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trending score settings. Each view, reaction and comment adds its weight to
+// a post's score, halved for every half of the window that has passed since.
+const (
+	trendingViewWeight     = 1.0
+	trendingReactionWeight = 5.0
+	trendingCommentWeight  = 10.0
+	trendingSize           = 50 // Posts kept per window
+	defaultTrendingLimit   = 10
+)
+
+// TrendingWindows maps the supported windows to their length.
+var TrendingWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// scoredBlog is a post ID with a ranking score.
+type scoredBlog struct {
+	blogID int
+	score  float64
+}
+
+// trendingCache holds the latest ranking of each window.
+var trendingCache = struct {
+	sync.RWMutex
+	ranks      map[string][]scoredBlog
+	computedAt time.Time
+}{ranks: map[string][]scoredBlog{}}
+
+// TrendingBlog is a post with its trending score.
+type TrendingBlog struct {
+	Blog
+	Score float64 `json:"score"`
+}
+
+// decay returns the weight left after age for a window.
+func decay(age, window time.Duration) float64 {
+	if age < 0 {
+		age = 0
+	}
+	return math.Pow(0.5, age.Hours()/(window.Hours()/2))
+}
+
+// computeTrending ranks the public posts by their decayed views, reactions and
+// comments within window.
+func computeTrending(window time.Duration) ([]scoredBlog, error) {
+	now := time.Now().UTC()
+	since := now.Add(-window)
+	scores := map[int]float64{}
+
+	rows, err := db.Query(`SELECT v.blog_id, v.day, v.views FROM blog_views_daily v JOIN blogs b ON b.id = v.blog_id
+		WHERE b.visibility = 'public' AND v.day >= ?`, since.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var blogID, n int
+		var day time.Time
+		if err := rows.Scan(&blogID, &day, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// Views are kept per day; count them from the middle of theirs
+		scores[blogID] += trendingViewWeight * float64(n) * decay(now.Sub(day.Add(12*time.Hour)), window)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reactions and comments are counted by the hour they were left in
+	for _, activity := range []struct {
+		table  string
+		weight float64
+	}{{"blog_reactions", trendingReactionWeight}, {"blog_comments", trendingCommentWeight}} {
+		rows, err = db.Query(`SELECT a.blog_id, TIMESTAMPDIFF(HOUR, a.created_at, NOW()) AS age, COUNT(*) FROM `+activity.table+` a
+			JOIN blogs b ON b.id = a.blog_id
+			WHERE b.visibility = 'public' AND a.created_at >= NOW() - INTERVAL ? HOUR
+			GROUP BY a.blog_id, age`, int(window.Hours()))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var blogID, ageHours, n int
+			if err := rows.Scan(&blogID, &ageHours, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			scores[blogID] += activity.weight * float64(n) * decay(time.Duration(ageHours)*time.Hour, window)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	ranks := make([]scoredBlog, 0, len(scores))
+	for blogID, score := range scores {
+		ranks = append(ranks, scoredBlog{blogID, score})
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].score != ranks[j].score {
+			return ranks[i].score > ranks[j].score
+		}
+		return ranks[i].blogID > ranks[j].blogID
+	})
+	if len(ranks) > trendingSize {
+		ranks = ranks[:trendingSize]
+	}
+	return ranks, nil
+}
+
+// RefreshTrending recomputes the ranking of every window.
+func RefreshTrending() error {
+	ranks := map[string][]scoredBlog{}
+	for name, window := range TrendingWindows {
+		entries, err := computeTrending(window)
+		if err != nil {
+			return err
+		}
+		ranks[name] = entries
+	}
+
+	trendingCache.Lock()
+	trendingCache.ranks = ranks
+	trendingCache.computedAt = time.Now()
+	trendingCache.Unlock()
+	return nil
+}
+
+// StartTrendingWorker recomputes the trending rankings every interval. Call
+// RefreshTrending first so that requests never find the rankings missing.
+func StartTrendingWorker(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := RefreshTrending(); err != nil {
+				log.Printf("Failed to compute trending posts: %v", err)
+			}
+		}
+	}()
+}
+
+// GetBlogsByIDs retrieves posts in the order of ids, skipping missing ones.
+func GetBlogsByIDs(ids []int) ([]Blog, error) {
+	if len(ids) == 0 {
+		return []Blog{}, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	found, err := queryBlogs(`SELECT `+blogColumns+` FROM blogs WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]Blog, len(found))
+	for _, b := range found {
+		byID[b.ID] = b
+	}
+	blogs := make([]Blog, 0, len(found))
+	for _, id := range ids {
+		if b, ok := byID[id]; ok {
+			blogs = append(blogs, b)
+		}
+	}
+	return blogs, nil
+}
+
+// GetTrending lists the posts readers engage with most right now.
+// @Summary Get trending blog posts
+// @Description Public posts ranked by views, reactions and comments within the window, with recent activity weighing more. Rankings are recomputed every few minutes.
+// @Tags Blog
+// @Produce  json
+// @Param   window  query  string  false  "24h (default), 7d or 30d"
+// @Param   limit   query  int     false  "Number of posts, at most 50 (default 10)"
+// @Success 200 {array} TrendingBlog
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 503 {string} string "Rankings not computed yet"
+// @Router /blogs/trending [get]
+func GetTrending(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "24h"
+	}
+	if _, ok := TrendingWindows[window]; !ok {
+		http.Error(w, "Invalid window: use 24h, 7d or 30d", http.StatusBadRequest)
+		return
+	}
+	limit := defaultTrendingLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > trendingSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	trendingCache.RLock()
+	ready := !trendingCache.computedAt.IsZero()
+	entries := trendingCache.ranks[window]
+	trendingCache.RUnlock()
+	// Only when computing the rankings at startup failed; the worker retries
+	if !ready {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Trending posts are not available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	ids := make([]int, len(entries))
+	scores := make(map[int]float64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.blogID
+		scores[e.blogID] = e.score
+	}
+	blogs, err := GetBlogsByIDs(ids)
+	if err != nil {
+		log.Printf("Failed to retrieve trending posts: %v", err)
+		http.Error(w, "Failed to retrieve trending posts", http.StatusInternalServerError)
+		return
+	}
+
+	// Posts may have been hidden since the ranking was computed
+	trending := []TrendingBlog{}
+	for _, b := range blogs {
+		if b.Visibility != VisibilityPublic {
+			continue
+		}
+		trending = append(trending, TrendingBlog{Blog: b, Score: math.Round(scores[b.ID]*100) / 100})
+		if len(trending) == limit {
+			break
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trending)
+}
@@ -0,0 +1,176 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// UserData is everything the blog service keeps about a user apart from the
+// posts they wrote, as included in account data exports.
+type UserData struct {
+	Comments       []Comment           `json:"comments"`
+	Reactions      []UserReaction      `json:"reactions"`
+	Bookmarks      []SavedPost         `json:"bookmarks"`
+	ReadingLists   []ReadingListExport `json:"reading_lists"`
+	Collaborations []Collaborator      `json:"collaborations"`
+	Series         []Series            `json:"series"`
+	Media          []Media             `json:"media"`
+}
+
+// UserReaction is a reaction a user left on a post.
+type UserReaction struct {
+	BlogID    int       `json:"blog_id"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SavedPost is a post in a user's bookmarks or reading list.
+type SavedPost struct {
+	BlogID    int       `json:"blog_id"`
+	Title     string    `json:"title"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReadingListExport is a reading list with the posts it holds.
+type ReadingListExport struct {
+	ReadingList
+	Posts []SavedPost `json:"posts"`
+}
+
+// GetUserData collects the comments, reactions, bookmarks, reading lists,
+// collaborations, series and uploads of a user, in one consistent snapshot.
+func GetUserData(ctx context.Context, username string) (*UserData, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	data := &UserData{
+		Comments: []Comment{}, Reactions: []UserReaction{}, Bookmarks: []SavedPost{}, ReadingLists: []ReadingListExport{},
+		Collaborations: []Collaborator{}, Series: []Series{}, Media: []Media{},
+	}
+	queries := []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{`SELECT id, blog_id, username, content, created_at FROM blog_comments WHERE username = ? ORDER BY id`, func(rows *sql.Rows) error {
+			var c Comment
+			err := rows.Scan(&c.ID, &c.BlogID, &c.Author, &c.Content, &c.CreatedAt)
+			data.Comments = append(data.Comments, c)
+			return err
+		}},
+		{`SELECT blog_id, reaction, created_at FROM blog_reactions WHERE username = ? ORDER BY created_at, blog_id`, func(rows *sql.Rows) error {
+			var r UserReaction
+			err := rows.Scan(&r.BlogID, &r.Reaction, &r.CreatedAt)
+			data.Reactions = append(data.Reactions, r)
+			return err
+		}},
+		{`SELECT b.blog_id, COALESCE(p.title, ''), b.position, b.created_at FROM bookmarks b LEFT JOIN blogs p ON p.id = b.blog_id
+            WHERE b.username = ? ORDER BY b.position`, func(rows *sql.Rows) error {
+			var s SavedPost
+			err := rows.Scan(&s.BlogID, &s.Title, &s.Position, &s.CreatedAt)
+			data.Bookmarks = append(data.Bookmarks, s)
+			return err
+		}},
+		{`SELECT id, name, created_at FROM reading_lists WHERE username = ? ORDER BY id`, func(rows *sql.Rows) error {
+			list := ReadingListExport{Posts: []SavedPost{}}
+			err := rows.Scan(&list.ID, &list.Name, &list.CreatedAt)
+			data.ReadingLists = append(data.ReadingLists, list)
+			return err
+		}},
+		{`SELECT blog_id, username, role, status, invited_by, created_at, accepted_at FROM blog_collaborators
+            WHERE username = ? ORDER BY created_at, blog_id`, func(rows *sql.Rows) error {
+			var c Collaborator
+			var acceptedAt sql.NullTime
+			err := rows.Scan(&c.BlogID, &c.Username, &c.Role, &c.Status, &c.InvitedBy, &c.CreatedAt, &acceptedAt)
+			if acceptedAt.Valid {
+				c.AcceptedAt = &acceptedAt.Time
+			}
+			data.Collaborations = append(data.Collaborations, c)
+			return err
+		}},
+		{`SELECT id, title, description, author, created_at FROM series WHERE author = ? ORDER BY id`, func(rows *sql.Rows) error {
+			var s Series
+			err := rows.Scan(&s.ID, &s.Title, &s.Description, &s.Author, &s.CreatedAt)
+			data.Series = append(data.Series, s)
+			return err
+		}},
+		{`SELECT ` + mediaColumns + ` FROM media WHERE author = ? ORDER BY id`, func(rows *sql.Rows) error {
+			m, err := scanMedia(rows.Scan)
+			if err == nil {
+				data.Media = append(data.Media, *m)
+			}
+			return err
+		}},
+	}
+	for _, q := range queries {
+		if err := queryUserRows(ctx, tx, q.query, username, q.scan); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range data.ReadingLists {
+		list := &data.ReadingLists[i]
+		err := queryUserRows(ctx, tx, `SELECT i.blog_id, COALESCE(p.title, ''), i.position, i.created_at FROM reading_list_items i
+            LEFT JOIN blogs p ON p.id = i.blog_id WHERE i.list_id = ? ORDER BY i.position`, list.ID, func(rows *sql.Rows) error {
+			var s SavedPost
+			err := rows.Scan(&s.BlogID, &s.Title, &s.Position, &s.CreatedAt)
+			list.Posts = append(list.Posts, s)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		list.Items = len(list.Posts)
+	}
+	return data, nil
+}
+
+// queryUserRows runs a query with a single argument and calls scan for every row.
+func queryUserRows(ctx context.Context, tx *sql.Tx, query string, arg interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportUserData returns everything the blog service keeps about the logged-in
+// user besides their posts.
+// @Summary Export own activity
+// @Description Returns the comments, reactions, bookmarks, reading lists, collaborations, series and uploads of the authenticated user, used for account data exports
+// @Tags Blog
+// @Produce  json
+// @Success 200 {object} UserData
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /blogs/activity [get]
+func ExportUserData(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, err := GetUserData(r.Context(), claims.Username)
+	if err != nil {
+		log.Printf("Failed to export user data: %v", err)
+		http.Error(w, "Failed to export user data", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
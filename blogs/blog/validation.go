@@ -0,0 +1,52 @@
+package blog
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"shared/validation"
+)
+
+// Limits applied to blog input. They mirror the column sizes of the blogs table.
+const (
+	maxTitleLength   = 255
+	maxContentLength = 65535 // bytes, the size of a MySQL TEXT column
+	maxPostPassword  = 72    // bytes, the input limit of bcrypt
+)
+
+// Validate checks the user-editable fields of a blog post.
+func (b Blog) Validate() validation.Errors {
+	errs := validation.Errors{}
+	switch {
+	case strings.TrimSpace(b.Title) == "":
+		errs["title"] = "is required"
+	case utf8.RuneCountInString(b.Title) > maxTitleLength:
+		errs["title"] = validation.AtMost(maxTitleLength, "characters")
+	}
+	switch {
+	case strings.TrimSpace(b.Content) == "":
+		errs["content"] = "is required"
+	case len(b.Content) > maxContentLength:
+		errs["content"] = validation.AtMost(maxContentLength, "bytes")
+	}
+	if utf8.RuneCountInString(b.Summary) > maxSummaryLength {
+		errs["summary"] = validation.AtMost(maxSummaryLength, "characters")
+	}
+	if b.Slug != "" && Slugify(b.Slug) == "" {
+		errs["slug"] = "must contain letters or digits"
+	}
+	if msg := validateTags(normalizeTags(b.Tags)); msg != "" {
+		errs["tags"] = msg
+	}
+	if b.Visibility != "" && !slices.Contains(Visibilities, b.Visibility) {
+		errs["visibility"] = "must be one of public, unlisted, private or password"
+	}
+	if len(b.Password) > maxPostPassword {
+		errs["password"] = validation.AtMost(maxPostPassword, "bytes")
+	}
+	if b.ContentFormat != "" && !slices.Contains(ContentFormats, b.ContentFormat) {
+		errs["content_format"] = "must be one of plain, markdown or html"
+	}
+	return errs
+}
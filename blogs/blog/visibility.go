@@ -0,0 +1,248 @@
+package blog
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Visibility levels of a post.
+const (
+	VisibilityPublic   = "public"   // Listed everywhere
+	VisibilityUnlisted = "unlisted" // Reachable only by direct link
+	VisibilityPrivate  = "private"  // Only the author and Admins
+	VisibilityPassword = "password" // Listed, but the content needs the post password
+)
+
+// Visibilities lists every supported visibility level.
+var Visibilities = []string{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate, VisibilityPassword}
+
+// listedVisibilities is the SQL list of visibilities that appear in listings.
+const listedVisibilities = `('public', 'password')`
+
+// SQL conditions selecting the posts that appear in listings and in feeds
+// and sitemaps respectively.
+const (
+	listedCondition = `visibility IN ` + listedVisibilities
+	publicCondition = `visibility = 'public'`
+)
+
+// grantTTL is how long an unlock grant for a password-protected post stays valid.
+const grantTTL = 30 * time.Minute
+
+// Wrong post passwords are limited per client and post, and per post across
+// all clients so that guessing from many addresses is slowed down too.
+var (
+	unlockClientLimiter = newAttemptLimiter(5, 15*time.Minute)
+	unlockPostLimiter   = newAttemptLimiter(50, 15*time.Minute)
+)
+
+// grantKey signs unlock grants. It differs from jwtKey so that a grant can
+// never be mistaken for a login session.
+var grantKey = func() []byte {
+	sum := sha256.Sum256(append([]byte("post-grant:"), jwtKey...))
+	return sum[:]
+}()
+
+// grantClaims are the claims of an unlock grant; the subject is the post ID.
+type grantClaims struct {
+	jwt.RegisteredClaims
+}
+
+// preparePassword hashes a new post password, and drops the stored hash of
+// posts that are no longer password-protected.
+func (b *Blog) preparePassword() error {
+	if b.Visibility != VisibilityPassword {
+		b.PasswordHash = ""
+	} else if b.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(b.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		b.PasswordHash = string(hash)
+	}
+	b.Password = ""
+	return nil
+}
+
+// lock removes the content of a password-protected post.
+func (b *Blog) lock() {
+	b.Content = ""
+	b.ContentHTML = ""
+	b.Excerpt = b.Summary // An automatic excerpt would reveal the content
+	b.Locked = true
+}
+
+// canManage reports whether claims belong to the post's author or an Admin.
+func canManage(b *Blog, claims *JWTClaims) bool {
+	return claims != nil && (claims.Username == b.Author || claims.Role == "Admin")
+}
+
+// visibleTo reports whether a post may be shown to the caller at all and locks
+// password-protected posts the caller has not unlocked.
+func visibleTo(b *Blog, claims *JWTClaims, grant string) bool {
+	if canManage(b, claims) {
+		return true
+	}
+	if roleOf(b, claims) != "" {
+		// Every collaborator role may read the post
+		return true
+	}
+	switch b.Visibility {
+	case VisibilityPrivate:
+		return false
+	case VisibilityPassword:
+		if !validGrant(grant, b.ID) {
+			b.lock()
+		}
+	}
+	return true
+}
+
+// lockListed locks the password-protected posts of a listing that the caller
+// neither manages nor collaborates on.
+func lockListed(blogs []Blog, claims *JWTClaims) {
+	for i := range blogs {
+		if blogs[i].Visibility == VisibilityPassword && !canManage(&blogs[i], claims) && roleOf(&blogs[i], claims) == "" {
+			blogs[i].lock()
+		}
+	}
+}
+
+// optionalClaims returns the caller's claims, or nil for anonymous requests.
+func optionalClaims(r *http.Request) *JWTClaims {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// grantCookie names the cookie that holds the unlock grant of a post.
+func grantCookie(blogID int) string {
+	return "post_grant_" + strconv.Itoa(blogID)
+}
+
+// grantFromRequest returns the unlock grant of a post sent in the X-Post-Grant
+// header or in the post's grant cookie. Grants are never read from the URL,
+// where they would end up in logs, browser history and Referer headers.
+func grantFromRequest(r *http.Request, blogID int) string {
+	if grant := r.Header.Get("X-Post-Grant"); grant != "" {
+		return grant
+	}
+	if cookie, err := r.Cookie(grantCookie(blogID)); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func issueGrant(blogID int) (string, time.Time, error) {
+	expiresAt := time.Now().Add(grantTTL)
+	claims := grantClaims{jwt.RegisteredClaims{
+		Subject:   strconv.Itoa(blogID),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(grantKey)
+	return token, expiresAt, err
+}
+
+func validGrant(grant string, blogID int) bool {
+	if grant == "" {
+		return false
+	}
+	claims := &grantClaims{}
+	token, err := jwt.ParseWithClaims(grant, claims, func(token *jwt.Token) (interface{}, error) {
+		return grantKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	return err == nil && token.Valid && claims.Subject == strconv.Itoa(blogID)
+}
+
+// UnlockRequest carries the password of a password-protected post.
+type UnlockRequest struct {
+	Password string `json:"password"`
+}
+
+// UnlockBlog exchanges the password of a password-protected post for a short-lived access grant.
+// @Summary Unlock a password-protected post
+// @Description Checks the post password and returns a grant, valid for 30 minutes, to send as the X-Post-Grant header when reading the post. The grant is also set as an HttpOnly cookie so browsers can load the post's images.
+// @Tags Blog
+// @Accept  json
+// @Produce  json
+// @Param   id       path  int            true  "Blog ID"
+// @Param   request  body  UnlockRequest  true  "Post password"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 429 {string} string "Too many wrong passwords"
+// @Router /blogs/{id}/unlock [post]
+func UnlockBlog(w http.ResponseWriter, r *http.Request) {
+	blogID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	var req UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	blog, err := GetBlogByID(blogID)
+	if err != nil {
+		log.Printf("Failed to retrieve blog: %v", err)
+		http.Error(w, "Failed to retrieve blog", http.StatusInternalServerError)
+		return
+	}
+	if blog == nil || blog.Visibility == VisibilityPrivate {
+		http.Error(w, "Blog post not found", http.StatusNotFound)
+		return
+	}
+	if blog.Visibility != VisibilityPassword {
+		http.Error(w, "Blog post is not password-protected", http.StatusBadRequest)
+		return
+	}
+
+	postKey := strconv.Itoa(blog.ID)
+	clientKey := postKey + "|" + clientIP(r)
+	if wait := max(unlockClientLimiter.retryAfter(clientKey), unlockPostLimiter.retryAfter(postKey)); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many wrong passwords, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(blog.PasswordHash), []byte(req.Password)) != nil {
+		unlockClientLimiter.add(clientKey)
+		unlockPostLimiter.add(postKey)
+		http.Error(w, "Incorrect password", http.StatusForbidden)
+		return
+	}
+
+	grant, expiresAt, err := issueGrant(blog.ID)
+	if err != nil {
+		log.Printf("Failed to issue grant: %v", err)
+		http.Error(w, "Failed to unlock blog", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     grantCookie(blog.ID),
+		Value:    grant,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"grant":      grant,
+		"expires_at": expiresAt.UTC().Truncate(time.Second),
+	})
+}
@@ -0,0 +1,598 @@
+package blog
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"shared/events"
+	"shared/validation"
+)
+
+// Webhook delivery settings. A failed delivery is retried after 1, 2, 4, ...
+// minutes; after webhookMaxAttempts it is dead-lettered, about two hours after
+// the first attempt.
+const (
+	webhookTimeout       = 10 * time.Second
+	webhookMaxAttempts   = 8
+	webhookBackoff       = time.Minute
+	webhookBatchSize     = 20
+	webhookLease         = time.Minute         // A claimed delivery is not claimed again for this long
+	webhookRetention     = 30 * 24 * time.Hour // Delivered entries are kept this long
+	webhookDeadRetention = 90 * 24 * time.Hour // Dead entries are kept this long after their last attempt
+	maxWebhookResponse   = 1024                // Bytes of a failed response kept in the delivery log
+	maxWebhookURL        = 2048
+	maxWebhookSecret     = 128
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // Gave up after webhookMaxAttempts; can be retried by hand
+)
+
+// EventWebhookTest is the type of the event sent by the test endpoint.
+const EventWebhookTest = "WebhookTest"
+
+// AllEvents subscribes a webhook to every event type.
+const AllEvents = "*"
+
+var webhookClient = &http.Client{
+	Timeout:   webhookTimeout,
+	Transport: newWebhookTransport(),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// errAddressNotPublic is returned for webhook URLs that lead to an address
+// outside the public internet.
+var errAddressNotPublic = errors.New("webhook address is not public")
+
+// nonPublicPrefixes are the special-purpose ranges that netip.Addr has no
+// method for.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // This network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which can reach any IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4, which embeds an IPv4 address
+	netip.MustParsePrefix("fec0::/10"),      // Deprecated site-local
+}
+
+// newWebhookTransport returns a transport that only connects to public
+// addresses. The check runs on every address dialled, after name
+// resolution, so a public host name cannot resolve or rebind to an internal
+// one. Proxies are not used, since the dialer would only see the proxy.
+func newWebhookTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   webhookTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   checkWebhookAddress,
+	}).DialContext
+	return transport
+}
+
+// checkWebhookAddress refuses to connect to an address that is not public.
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddress(ip) {
+		return fmt.Errorf("%w: %s", errAddressNotPublic, ip)
+	}
+	return nil
+}
+
+// isPublicAddress reports whether ip is on the public internet: not loopback,
+// private, link-local (which includes the cloud metadata service at
+// 169.254.169.254), multicast, unspecified or otherwise reserved.
+func isPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap().WithZone("")
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Webhook is a subscription to blog events.
+type Webhook struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`      // Event types, or "*" for all
+	Secret     string    `json:"secret,omitempty"` // Only returned when the webhook is created
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookRequest is the body of a new webhook subscription.
+type WebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"` // Optional; generated when empty
+}
+
+// WebhookDelivery is an entry of a webhook's delivery log.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	EventID        *int64          `json:"event_id"` // Outbox ID of the event; null for test events
+	EventType      string          `json:"event_type"`
+	Status         string          `json:"status"` // pending, delivered or dead
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	LastDuration   int             `json:"last_duration_ms"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// DeliveryPage is one page of a delivery log, newest first.
+type DeliveryPage struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Page       int               `json:"page"`
+	PerPage    int               `json:"per_page"`
+	Total      int               `json:"total"`
+}
+
+// Validate checks a webhook request.
+func (req WebhookRequest) Validate() validation.Errors {
+	errs := validation.Errors{}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs["url"] = "must be an absolute http or https URL"
+	} else if len(req.URL) > maxWebhookURL {
+		errs["url"] = validation.AtMost(maxWebhookURL, "characters")
+	} else if ip, err := netip.ParseAddr(u.Hostname()); (err == nil && !isPublicAddress(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+		errs["url"] = "must not point to a local or private address"
+	}
+	if len(req.EventTypes) == 0 {
+		errs["event_types"] = "must list at least one event type, or \"*\""
+	}
+	for _, t := range req.EventTypes {
+		if t != AllEvents && !slices.Contains(EventTypes, t) {
+			errs["event_types"] = "unknown event type " + strconv.Quote(t) + "; use " + strings.Join(EventTypes, ", ") + " or \"*\""
+		}
+	}
+	if len(req.Secret) > maxWebhookSecret {
+		errs["secret"] = validation.AtMost(maxWebhookSecret, "characters")
+	}
+	return errs
+}
+
+// CreateWebhook stores a subscription, generating its secret if none was given.
+func CreateWebhook(req WebhookRequest, createdBy string) (*Webhook, error) {
+	secret := req.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(buf)
+	}
+	types := req.EventTypes
+	if slices.Contains(types, AllEvents) {
+		types = []string{AllEvents}
+	}
+	res, err := db.Exec(`INSERT INTO webhooks (url, event_types, secret, created_by) VALUES (?, ?, ?, ?)`,
+		req.URL, strings.Join(types, ","), secret, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	hook, err := GetWebhook(int(id))
+	if err != nil {
+		return nil, err
+	}
+	hook.Secret = secret
+	return hook, nil
+}
+
+func scanWebhook(scan func(dest ...interface{}) error) (*Webhook, error) {
+	var hook Webhook
+	var types string
+	if err := scan(&hook.ID, &hook.URL, &types, &hook.CreatedBy, &hook.CreatedAt); err != nil {
+		return nil, err
+	}
+	hook.EventTypes = strings.Split(types, ",")
+	return &hook, nil
+}
+
+// GetWebhook returns a subscription without its secret, or nil if there is none.
+func GetWebhook(id int) (*Webhook, error) {
+	hook, err := scanWebhook(db.QueryRow(`SELECT id, url, event_types, created_by, created_at FROM webhooks WHERE id = ?`, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return hook, err
+}
+
+// GetWebhooks lists all subscriptions without their secrets.
+func GetWebhooks() ([]Webhook, error) {
+	rows, err := db.Query(`SELECT id, url, event_types, created_by, created_at FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		hook, err := scanWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook removes a subscription and its delivery log. It reports
+// whether the subscription existed.
+func DeleteWebhook(id int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SubscribeWebhooks queues a delivery to every webhook subscribed to each
+// event published on bus.
+func SubscribeWebhooks(bus *events.Bus) {
+	bus.Subscribe(enqueueDeliveries)
+}
+
+// enqueueDeliveries queues an event for the webhooks subscribed to its type.
+// An event the relay publishes again is queued only once per webhook.
+func enqueueDeliveries(ctx context.Context, e events.Event) error {
+	rows, err := db.QueryContext(ctx, `SELECT id, event_types FROM webhooks`)
+	if err != nil {
+		return err
+	}
+	var hookIDs []int
+	for rows.Next() {
+		var id int
+		var types string
+		if err := rows.Scan(&id, &types); err != nil {
+			rows.Close()
+			return err
+		}
+		if types == AllEvents || slices.Contains(strings.Split(types, ","), e.Type) {
+			hookIDs = append(hookIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(hookIDs) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for _, id := range hookIDs {
+		_, err := db.ExecContext(ctx, `INSERT IGNORE INTO webhook_deliveries (webhook_id, event_id, event_type, payload) VALUES (?, ?, ?, ?)`,
+			id, e.ID, e.Type, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingDelivery is a delivery claimed for an attempt.
+type pendingDelivery struct {
+	id        int64
+	eventType string
+	payload   []byte
+	attempts  int
+	url       string
+	secret    string
+}
+
+// StartWebhookWorker sends due webhook deliveries every interval and removes
+// old delivered and dead ones.
+func StartWebhookWorker(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastCleanup := time.Time{}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := SendDueDeliveries(ctx); err != nil {
+				log.Printf("Failed to send webhook deliveries: %v", err)
+			}
+			if time.Since(lastCleanup) > time.Hour {
+				if err := removeOldDeliveries(ctx); err != nil {
+					log.Printf("Failed to remove old webhook deliveries: %v", err)
+				}
+				lastCleanup = time.Now()
+			}
+		}
+	}()
+}
+
+// removeOldDeliveries deletes delivered entries after webhookRetention and
+// dead ones webhookDeadRetention after their last attempt, which is when their
+// next_attempt_at was set. Pending entries are kept until they are settled.
+func removeOldDeliveries(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE status = ? AND created_at < ?`,
+		DeliveryDelivered, time.Now().Add(-webhookRetention)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE status = ? AND next_attempt_at < ?`,
+		DeliveryDead, time.Now().Add(-webhookDeadRetention))
+	return err
+}
+
+// SendDueDeliveries claims a batch of deliveries that are due and attempts
+// them concurrently. Claimed deliveries are leased so that other instances
+// skip them while they are in flight.
+func SendDueDeliveries(ctx context.Context) error {
+	due, err := claimDeliveries(ctx)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, d := range due {
+		wg.Add(1)
+		go func(d pendingDelivery) {
+			defer wg.Done()
+			if err := attemptDelivery(ctx, d); err != nil {
+				log.Printf("Failed to record webhook delivery %d: %v", d.id, err)
+			}
+		}(d)
+	}
+	wg.Wait()
+	return nil
+}
+
+func claimDeliveries(ctx context.Context) ([]pendingDelivery, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT d.id, d.event_type, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY d.next_attempt_at, d.id LIMIT ? FOR UPDATE OF d SKIP LOCKED`, DeliveryPending, webhookBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	var due []pendingDelivery
+	var ids []int
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.id, &d.eventType, &d.payload, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, d)
+		ids = append(ids, int(d.id))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(due) == 0 {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET next_attempt_at = CURRENT_TIMESTAMP + INTERVAL ? SECOND
+		WHERE id IN (`+joinInts(ids)+`)`, int(webhookLease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	return due, tx.Commit()
+}
+
+// signWebhook returns the X-Webhook-Signature of a payload: the hex
+// HMAC-SHA256, keyed with the webhook's secret, of the timestamp, a dot and
+// the body.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// attemptDelivery posts a delivery to its webhook and records the outcome:
+// delivered on a 2xx response, otherwise another attempt after an
+// exponentially growing delay, or dead-lettered once the attempts run out.
+func attemptDelivery(ctx context.Context, d pendingDelivery) error {
+	started := time.Now()
+	code, sendErr := sendWebhook(ctx, d)
+	duration := int(time.Since(started).Milliseconds())
+
+	attempts := d.attempts + 1
+	if sendErr == nil {
+		_, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_status_code = ?, last_error = NULL, last_duration_ms = ?,
+			delivered_at = CURRENT_TIMESTAMP WHERE id = ?`, DeliveryDelivered, attempts, code, duration, d.id)
+		return err
+	}
+
+	status, delay := DeliveryPending, webhookBackoff<<(attempts-1)
+	if attempts >= webhookMaxAttempts {
+		status, delay = DeliveryDead, 0
+	}
+	_, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_status_code = ?, last_error = ?, last_duration_ms = ?,
+		next_attempt_at = CURRENT_TIMESTAMP + INTERVAL ? SECOND WHERE id = ?`,
+		status, attempts, code, sendErr.Error(), duration, int(delay.Seconds()), d.id)
+	if status == DeliveryDead {
+		log.Printf("Webhook delivery %d dead-lettered after %d attempts: %v", d.id, attempts, sendErr)
+	}
+	return err
+}
+
+// sendWebhook posts a delivery and returns the response status code, if any.
+func sendWebhook(ctx context.Context, d pendingDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blogs-webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", d.eventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.id, 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(d.secret, timestamp, d.payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// SendTestEvent queues a WebhookTest event for a webhook and attempts it
+// right away. A failed test delivery is retried like any other.
+func SendTestEvent(ctx context.Context, hook *Webhook) (*WebhookDelivery, error) {
+	data, err := json.Marshal(map[string]interface{}{"webhook_id": hook.ID, "message": "This is a test event"})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(events.Event{Source: "blogs", Type: EventWebhookTest, Data: data, OccurredAt: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	// Leased from the start so the worker leaves it to this attempt
+	res, err := db.ExecContext(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_type, payload, next_attempt_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)`, hook.ID, EventWebhookTest, payload, int(webhookLease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	var secret string
+	if err := db.QueryRowContext(ctx, `SELECT secret FROM webhooks WHERE id = ?`, hook.ID).Scan(&secret); err != nil {
+		return nil, err
+	}
+	d := pendingDelivery{id: id, eventType: EventWebhookTest, payload: payload, url: hook.URL, secret: secret}
+	if err := attemptDelivery(ctx, d); err != nil {
+		return nil, err
+	}
+	return getDelivery(hook.ID, id)
+}
+
+const deliveryColumns = `id, event_id, event_type, status, attempts, next_attempt_at, COALESCE(last_status_code, 0), COALESCE(last_error, ''),
+	last_duration_ms, payload, created_at, delivered_at`
+
+func scanDelivery(scan func(dest ...interface{}) error) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var eventID sql.NullInt64
+	var next, delivered sql.NullTime
+	var payload []byte
+	if err := scan(&d.ID, &eventID, &d.EventType, &d.Status, &d.Attempts, &next, &d.LastStatusCode, &d.LastError,
+		&d.LastDuration, &payload, &d.CreatedAt, &delivered); err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	if eventID.Valid {
+		d.EventID = &eventID.Int64
+	}
+	if next.Valid && d.Status == DeliveryPending {
+		d.NextAttemptAt = &next.Time
+	}
+	if delivered.Valid {
+		d.DeliveredAt = &delivered.Time
+	}
+	return &d, nil
+}
+
+// getDelivery returns a delivery of a webhook, or nil if there is none.
+func getDelivery(webhookID int, id int64) (*WebhookDelivery, error) {
+	d, err := scanDelivery(db.QueryRow(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? AND id = ?`, webhookID, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// GetDeliveries returns a page of a webhook's delivery log, newest first,
+// optionally only those with a status.
+func GetDeliveries(webhookID int, status string, page, perPage int) (*DeliveryPage, error) {
+	condition, args := `webhook_id = ?`, []interface{}{webhookID}
+	if status != "" {
+		condition += ` AND status = ?`
+		args = append(args, status)
+	}
+	result := &DeliveryPage{Deliveries: []WebhookDelivery{}, Page: page, PerPage: perPage}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries WHERE `+condition, args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE `+condition+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d, err := scanDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		result.Deliveries = append(result.Deliveries, *d)
+	}
+	return result, rows.Err()
+}
+
+// RetryDelivery puts a dead-lettered delivery back in the queue with a fresh
+// set of attempts. It reports false if the webhook has no such dead delivery.
+func RetryDelivery(webhookID int, id int64) (bool, error) {
+	res, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE webhook_id = ? AND id = ? AND status = ?`, DeliveryPending, webhookID, id, DeliveryDead)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
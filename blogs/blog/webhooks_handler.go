@@ -0,0 +1,247 @@
+package blog
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"shared/validation"
+)
+
+// requireAdmin answers 403 unless the caller is an Admin.
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*JWTClaims, bool) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil || claims.Role != "Admin" {
+		http.Error(w, "Forbidden: Admins only", http.StatusForbidden)
+		return nil, false
+	}
+	return claims, true
+}
+
+// webhookFromPath loads the webhook named in the path for an Admin.
+func webhookFromPath(w http.ResponseWriter, r *http.Request) (*Webhook, bool) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return nil, false
+	}
+	id, err := strconv.Atoi(r.PathValue("webhook"))
+	if err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	hook, err := GetWebhook(id)
+	if err != nil {
+		log.Printf("Failed to fetch webhook: %v", err)
+		http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		return nil, false
+	}
+	if hook == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	return hook, true
+}
+
+// GetWebhooksHandler lists the webhook subscriptions.
+// @Summary List webhooks
+// @Description Lists the webhook subscriptions. Secrets are not included. Admin only.
+// @Tags Webhooks
+// @Produce  json
+// @Success 200 {array} Webhook
+// @Failure 403 {object} map[string]string
+// @Router /webhooks [get]
+func GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	hooks, err := GetWebhooks()
+	if err != nil {
+		log.Printf("Failed to fetch webhooks: %v", err)
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// CreateWebhookHandler subscribes a URL to blog events.
+// @Summary Create a webhook
+// @Description Subscribes a URL to blog events (BlogCreated, BlogUpdated, BlogPublished, BlogUnpublished, BlogDeleted, or "*" for all). Each delivery is a POST of the event as JSON, signed in X-Webhook-Signature with "sha256=" and the hex HMAC-SHA256 of X-Webhook-Timestamp, ".", and the body, keyed with the secret. The URL must not point to a local or private address. The secret is generated unless given, and only returned here. Admin only.
+// @Tags Webhooks
+// @Accept  json
+// @Produce  json
+// @Param   webhook  body  WebhookRequest  true  "Subscription"
+// @Success 201 {object} Webhook
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /webhooks [post]
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
+
+	hook, err := CreateWebhook(req, claims.Username)
+	if err != nil {
+		log.Printf("Failed to create webhook: %v", err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// GetWebhookHandler returns one webhook subscription.
+// @Summary Get a webhook
+// @Description Returns a webhook subscription without its secret. Admin only.
+// @Tags Webhooks
+// @Produce  json
+// @Param   webhook  path  int  true  "Webhook ID"
+// @Success 200 {object} Webhook
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{webhook} [get]
+func GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// DeleteWebhookHandler removes a webhook subscription.
+// @Summary Delete a webhook
+// @Description Removes a webhook subscription and its delivery log. Pending deliveries are dropped. Admin only.
+// @Tags Webhooks
+// @Produce  json
+// @Param   webhook  path  int  true  "Webhook ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{webhook} [delete]
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, err := DeleteWebhook(hook.ID); err != nil {
+		log.Printf("Failed to delete webhook: %v", err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Webhook deleted",
+	})
+}
+
+// GetDeliveriesHandler returns a webhook's delivery log.
+// @Summary List webhook deliveries
+// @Description Returns the deliveries of a webhook, newest first, with their status (pending, delivered or dead), attempts and the outcome of the last attempt. Failed deliveries are retried after 1, 2, 4, ... minutes and dead-lettered after 8 attempts. Delivered entries are kept for 30 days and dead ones for 90 days after their last attempt. Admin only.
+// @Tags Webhooks
+// @Produce  json
+// @Param   webhook   path   int     true   "Webhook ID"
+// @Param   status    query  string  false  "pending, delivered or dead"
+// @Param   page      query  int     false  "Page number (default 1)"
+// @Param   per_page  query  int     false  "Deliveries per page (default 20, max 100)"
+// @Success 200 {object} DeliveryPage
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{webhook}/deliveries [get]
+func GetDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != DeliveryPending && status != DeliveryDelivered && status != DeliveryDead {
+		http.Error(w, "Invalid status: use pending, delivered or dead", http.StatusBadRequest)
+		return
+	}
+	page, perPage, ok := paginationParams(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := GetDeliveries(hook.ID, status, page, perPage)
+	if err != nil {
+		log.Printf("Failed to fetch webhook deliveries: %v", err)
+		http.Error(w, "Failed to fetch webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// TestWebhookHandler sends a test event to a webhook.
+// @Summary Send a test event
+// @Description Sends a signed WebhookTest event to the webhook right away and returns the resulting delivery log entry. A failed test is retried like any other delivery. Admin only.
+// @Tags Webhooks
+// @Produce  json
+// @Param   webhook  path  int  true  "Webhook ID"
+// @Success 200 {object} WebhookDelivery
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{webhook}/test [post]
+func TestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+	delivery, err := SendTestEvent(r.Context(), hook)
+	if err != nil {
+		log.Printf("Failed to send test event: %v", err)
+		http.Error(w, "Failed to send test event", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// RetryDeliveryHandler requeues a dead-lettered delivery.
+// @Summary Retry a dead delivery
+// @Description Puts a dead-lettered delivery back in the queue with a fresh set of attempts. Admin only.
+// @Tags Webhooks
+// @Produce  json
+// @Param   webhook   path  int  true  "Webhook ID"
+// @Param   delivery  path  int  true  "Delivery ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/{webhook}/deliveries/{delivery}/retry [post]
+func RetryDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("delivery"), 10, 64)
+	if err != nil {
+		http.Error(w, "Dead delivery not found", http.StatusNotFound)
+		return
+	}
+	retried, err := RetryDelivery(hook.ID, id)
+	if err != nil {
+		log.Printf("Failed to retry webhook delivery: %v", err)
+		http.Error(w, "Failed to retry delivery", http.StatusInternalServerError)
+		return
+	}
+	if !retried {
+		http.Error(w, "Dead delivery not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Delivery queued for another attempt",
+	})
+}
@@ -0,0 +1,131 @@
+package blog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{
+			name:      "event",
+			secret:    "s3cret",
+			timestamp: 1714564800,
+			body:      `{"id":1}`,
+			want:      "sha256=f294a9194411622cfb7e15c111610c28f0b024c53cbcb66517b4a15c8c5fc5ea",
+		},
+		{
+			name:      "other timestamp",
+			secret:    "s3cret",
+			timestamp: 1714564801,
+			body:      `{"id":1}`,
+			want:      "sha256=159b969961100737096d281f6b4574276be20d502af5fabee2e43835fa3fb25d",
+		},
+		{
+			name:      "other secret",
+			secret:    "other",
+			timestamp: 1714564800,
+			body:      `{"id":1}`,
+			want:      "sha256=344b29fd2bf1186a680c8bb45fc1d93a56b2e987fd12ce72e6199cce77954578",
+		},
+		{
+			name: "empty",
+			want: "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhook(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("signWebhook() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1%eth0", false},
+		{"fd00:ec2::254", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"2002:a9fe:a9fe::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isPublicAddress(netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Errorf("isPublicAddress(%s) = %t, want %t", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendWebhookRefusesLoopback(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	_, err := sendWebhook(context.Background(), pendingDelivery{id: 1, eventType: EventWebhookTest, payload: []byte(`{}`), url: server.URL})
+	if !errors.Is(err, errAddressNotPublic) {
+		t.Errorf("sendWebhook() error = %v, want %v", err, errAddressNotPublic)
+	}
+	if called {
+		t.Error("sendWebhook() reached the loopback server")
+	}
+}
+
+func TestWebhookRequestValidateURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{"https://example.com/hooks", ""},
+		{"http://93.184.215.14:8080/hooks", ""},
+		{"ftp://example.com", "must be an absolute http or https URL"},
+		{"/hooks", "must be an absolute http or https URL"},
+		{"http://localhost:8001/hooks", "must not point to a local or private address"},
+		{"http://127.0.0.1/hooks", "must not point to a local or private address"},
+		{"http://169.254.169.254/latest/meta-data/", "must not point to a local or private address"},
+		{"http://[::1]/hooks", "must not point to a local or private address"},
+		{"http://10.1.2.3/hooks", "must not point to a local or private address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			errs := WebhookRequest{URL: tt.url, EventTypes: []string{AllEvents}}.Validate()
+			if errs["url"] != tt.wantErr {
+				t.Errorf("Validate() url error = %q, want %q", errs["url"], tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"blogs/blog"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"shared/backup"
+)
+
+// runCommand runs a maintenance subcommand against the prepared database and
+// returns the process exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "import":
+		return runImport(args)
+	case "export":
+		return runExport(args)
+	case "backup":
+		return backup.RunBackup(serviceName, db, store, schemaVersion, args)
+	case "restore":
+		return backup.RunRestore(serviceName, db, store, schemaVersion, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q; available: import, export, backup, restore\n", name)
+		return 2
+	}
+}
+
+// runImport imports a WordPress WXR export or a directory of Markdown files.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be imported")
+	authorMap := fs.String("author-map", "", "comma-separated source=username pairs")
+	defaultAuthor := fs.String("default-author", "", "username for authors that are not mapped")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: blogs import [flags] <export.xml | markdown-dir | markdown.zip>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	source := fs.Arg(0)
+
+	opts := blog.ImportOptions{DryRun: *dryRun, DefaultAuthor: *defaultAuthor}
+	var err error
+	if opts.AuthorMap, err = blog.ParseAuthorMap(*authorMap); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	posts, err := readImportSource(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", source, err)
+		return 1
+	}
+	report, err := blog.ImportPosts(context.Background(), posts, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import interrupted: %v\n", err)
+	}
+
+	for _, item := range report.Items {
+		line := fmt.Sprintf("%-8s  %s  %q", item.Status, item.Source, item.Title)
+		if item.Author != "" {
+			line += "  by " + item.Author
+		}
+		if !item.CreatedAt.IsZero() {
+			line += "  " + item.CreatedAt.Format("2006-01-02")
+		}
+		if item.Message != "" {
+			line += "  (" + item.Message + ")"
+		}
+		fmt.Println(line)
+	}
+	if len(report.UnmappedAuthors) > 0 {
+		fmt.Printf("\nUnmapped authors: %s\n", strings.Join(report.UnmappedAuthors, ", "))
+	}
+	fmt.Printf("\n%d posts: %d imported, %d ready, %d skipped, %d failed", report.Total, report.Imported, report.Ready, report.Skipped, report.Failed)
+	if report.DryRun {
+		fmt.Print(" (dry run, nothing was stored)")
+	}
+	fmt.Println()
+
+	if report.Failed > 0 || err != nil {
+		return 1
+	}
+	return 0
+}
+
+// readImportSource parses a WXR file, a directory of Markdown files or a ZIP of them.
+func readImportSource(source string) ([]blog.ImportPost, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return blog.ParseMarkdownFiles(os.DirFS(source))
+	}
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".zip":
+		archive, err := zip.OpenReader(source)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		return blog.ParseMarkdownFiles(archive)
+	default:
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return blog.ParseWXR(f)
+	}
+}
+
+// runExport writes all posts, or one author's, to a file or standard output.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", blog.ExportJSONLines, "jsonl, markdown or html")
+	author := fs.String("author", "", "only export this author's posts")
+	output := fs.String("o", "", "output file (default standard output)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || !slices.Contains(blog.ExportFormats, *format) {
+		fmt.Fprintln(fs.Output(), "usage: blogs export [-format jsonl|markdown|html] [-author username] [-o file]")
+		return 2
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := blog.ExportPosts(context.Background(), out, *format, *author); err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	if err := out.Sync(); err != nil && *output != "" {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
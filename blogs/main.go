@@ -3,9 +3,18 @@ package main
 import (
 	"blogs/blog"
 	_ "blogs/docs" // For Swagger documentation
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"shared/events"
+	"shared/storage"
 
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -13,20 +22,16 @@ import (
 
 var db *sql.DB
 
-// ProtectedRoute is a simple middleware that ensures authentication.
-func ProtectedRoute(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// In a real scenario, you would check for JWT or session authentication here
-		// If not authenticated, return Unauthorized
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		// Pass to the next handler if authenticated
-		next(w, r)
-	}
-}
+// store keeps uploaded files; backups include it.
+var store storage.BlobStore
+
+// schemaVersion identifies the tables and columns created at startup. Bump it
+// whenever the schema or columns below change; backups are only restored into
+// a database at the same schema version.
+const schemaVersion = 6
+
+// serviceName names the database in backups.
+const serviceName = "blog_management"
 
 // @title Blog Management API
 // @version 1.0
@@ -36,7 +41,7 @@ func ProtectedRoute(next http.HandlerFunc) http.HandlerFunc {
 
 func main() {
 	// MySQL connection string (ensure you use your credentials)
-	dsn := "root:@tcp(127.0.0.1:3306)/blog_management"
+	dsn := "root:@tcp(127.0.0.1:3306)/blog_management?parseTime=true"
 	var err error
 	db, err = sql.Open("mysql", dsn)
 	if err != nil {
@@ -49,35 +54,430 @@ func main() {
 		log.Fatalf("Failed to ping the database: %v", err)
 	}
 
-	// Create the blogs table if it doesn't exist
-	createTableQuery := `
+	// Create the tables if they don't exist
+	schema := []string{`
     CREATE TABLE IF NOT EXISTS blogs (
         id INT AUTO_INCREMENT PRIMARY KEY,
         title VARCHAR(255) NOT NULL,
+        slug VARCHAR(120) NULL UNIQUE,
         content TEXT NOT NULL,
+        content_format VARCHAR(16) NOT NULL DEFAULT 'plain',
+        content_html MEDIUMTEXT NOT NULL,
+        summary TEXT NOT NULL,
+        excerpt TEXT NOT NULL,
+        word_count INT NOT NULL DEFAULT 0,
+        reading_time INT NOT NULL DEFAULT 0,
+        author VARCHAR(100) NOT NULL,
+        visibility VARCHAR(16) NOT NULL DEFAULT 'public',
+        password_hash VARCHAR(60) NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_slug_redirects (
+        slug VARCHAR(120) PRIMARY KEY,
+        blog_id INT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_tags (
+        blog_id INT NOT NULL,
+        tag VARCHAR(50) NOT NULL,
+        PRIMARY KEY (blog_id, tag),
+        INDEX idx_blog_tags_tag (tag),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_reactions (
+        blog_id INT NOT NULL,
+        username VARCHAR(100) NOT NULL,
+        reaction VARCHAR(16) NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (blog_id, username, reaction),
+        INDEX idx_blog_reactions_user (username, created_at),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_comments (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        blog_id INT NOT NULL,
+        username VARCHAR(100) NOT NULL,
+        content TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        INDEX idx_blog_comments_blog (blog_id, created_at),
+        INDEX idx_blog_comments_user (username),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_reaction_counts (
+        blog_id INT NOT NULL,
+        reaction VARCHAR(16) NOT NULL,
+        count INT NOT NULL DEFAULT 0,
+        PRIMARY KEY (blog_id, reaction),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_views_daily (
+        blog_id INT NOT NULL,
+        day DATE NOT NULL,
+        views INT NOT NULL DEFAULT 0,
+        PRIMARY KEY (blog_id, day),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_referrers_daily (
+        blog_id INT NOT NULL,
+        day DATE NOT NULL,
+        referrer VARCHAR(255) NOT NULL,
+        views INT NOT NULL DEFAULT 0,
+        PRIMARY KEY (blog_id, day, referrer),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_terms (
+        blog_id INT NOT NULL,
+        term VARCHAR(64) NOT NULL,
+        weight DOUBLE NOT NULL,
+        PRIMARY KEY (blog_id, term),
+        INDEX idx_blog_terms_term (term),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_related (
+        blog_id INT NOT NULL,
+        related_id INT NOT NULL,
+        score DOUBLE NOT NULL,
+        PRIMARY KEY (blog_id, related_id),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE,
+        FOREIGN KEY (related_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS bookmarks (
+        username VARCHAR(100) NOT NULL,
+        blog_id INT NOT NULL,
+        position INT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (username, blog_id),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS reading_lists (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        username VARCHAR(100) NOT NULL,
+        name VARCHAR(100) NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE KEY uq_reading_lists_name (username, name)
+    );`, `
+    CREATE TABLE IF NOT EXISTS reading_list_items (
+        list_id INT NOT NULL,
+        blog_id INT NOT NULL,
+        position INT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (list_id, blog_id),
+        FOREIGN KEY (list_id) REFERENCES reading_lists(id) ON DELETE CASCADE,
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_collaborators (
+        blog_id INT NOT NULL,
+        username VARCHAR(100) NOT NULL,
+        role VARCHAR(16) NOT NULL,
+        status VARCHAR(16) NOT NULL DEFAULT 'pending',
+        invited_by VARCHAR(100) NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        accepted_at TIMESTAMP NULL,
+        PRIMARY KEY (blog_id, username),
+        INDEX idx_blog_collaborators_username (username),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS series (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        title VARCHAR(200) NOT NULL,
+        description TEXT NOT NULL,
+        author VARCHAR(100) NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        INDEX idx_series_author (author)
+    );`, `
+    CREATE TABLE IF NOT EXISTS series_posts (
+        series_id INT NOT NULL,
+        blog_id INT NOT NULL UNIQUE,
+        position INT NOT NULL,
+        PRIMARY KEY (series_id, blog_id),
+        FOREIGN KEY (series_id) REFERENCES series(id) ON DELETE CASCADE,
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS media (
+        id INT AUTO_INCREMENT PRIMARY KEY,
         author VARCHAR(100) NOT NULL,
+        filename VARCHAR(255) NOT NULL,
+        content_type VARCHAR(100) NOT NULL,
+        size BIGINT NOT NULL,
+        width INT NOT NULL DEFAULT 0,
+        height INT NOT NULL DEFAULT 0,
+        variants VARCHAR(100) NOT NULL DEFAULT '',
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        INDEX idx_media_author (author)
+    );`, `
+    CREATE TABLE IF NOT EXISTS blog_media (
+        blog_id INT NOT NULL,
+        media_id INT NOT NULL,
+        PRIMARY KEY (blog_id, media_id),
+        FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE,
+        FOREIGN KEY (media_id) REFERENCES media(id)
+    );`, `
+    CREATE TABLE IF NOT EXISTS outbox (
+        id BIGINT AUTO_INCREMENT PRIMARY KEY,
+        event_type VARCHAR(64) NOT NULL,
+        payload MEDIUMTEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        published_at TIMESTAMP NULL,
+        attempts INT NOT NULL DEFAULT 0,
+        last_error TEXT NULL,
+        claim_token CHAR(32) NULL,
+        claimed_until TIMESTAMP NULL,
+        failed_at TIMESTAMP NULL,
+        INDEX idx_outbox_pending (published_at, id)
+    );`, `
+    CREATE TABLE IF NOT EXISTS webhooks (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        url VARCHAR(2048) NOT NULL,
+        event_types VARCHAR(255) NOT NULL,
+        secret VARCHAR(128) NOT NULL,
+        created_by VARCHAR(100) NOT NULL,
         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );`
-	_, err = db.Exec(createTableQuery)
-	if err != nil {
-		log.Fatalf("Failed to create blogs table: %v", err)
+    );`, `
+    CREATE TABLE IF NOT EXISTS webhook_deliveries (
+        id BIGINT AUTO_INCREMENT PRIMARY KEY,
+        webhook_id INT NOT NULL,
+        event_id BIGINT NULL,
+        event_type VARCHAR(64) NOT NULL,
+        payload MEDIUMTEXT NOT NULL,
+        status VARCHAR(16) NOT NULL DEFAULT 'pending',
+        attempts INT NOT NULL DEFAULT 0,
+        next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        last_status_code INT NULL,
+        last_error TEXT NULL,
+        last_duration_ms INT NOT NULL DEFAULT 0,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        delivered_at TIMESTAMP NULL,
+        UNIQUE KEY uq_webhook_deliveries_event (webhook_id, event_id),
+        INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+        FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+    );`}
+	for _, createTableQuery := range schema {
+		if _, err = db.Exec(createTableQuery); err != nil {
+			log.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+
+	// Add columns introduced after a table was first created
+	columns := []struct{ table, column, definition string }{
+		{"blogs", "content_format", "VARCHAR(16) NOT NULL DEFAULT 'plain'"},
+		{"blogs", "content_html", "MEDIUMTEXT NOT NULL"},
+		{"blogs", "slug", "VARCHAR(120) NULL UNIQUE"},
+		{"blogs", "visibility", "VARCHAR(16) NOT NULL DEFAULT 'public'"},
+		{"blogs", "password_hash", "VARCHAR(60) NULL"},
+		{"blogs", "updated_at", "TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"},
+		{"blogs", "summary", "TEXT NOT NULL"},
+		{"blogs", "excerpt", "TEXT NOT NULL"},
+		{"blogs", "word_count", "INT NOT NULL DEFAULT 0"},
+		{"blogs", "reading_time", "INT NOT NULL DEFAULT 0"},
+		{"outbox", "claim_token", "CHAR(32) NULL"},
+		{"outbox", "claimed_until", "TIMESTAMP NULL"},
+		{"outbox", "failed_at", "TIMESTAMP NULL"},
+	}
+	for _, c := range columns {
+		if err = addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			log.Fatalf("Failed to add column %s.%s: %v", c.table, c.column, err)
+		}
 	}
 
-	log.Println("Connected to the MySQL database and ensured blogs table exists")
+	log.Println("Connected to the MySQL database and ensured tables exist")
 
 	// Inject the DB connection into the blog package
 	blog.SetDB(db)
 
+	if err = blog.RenderMissingContent(); err != nil {
+		log.Fatalf("Failed to render existing blog content: %v", err)
+	}
+	if err = blog.AssignMissingSlugs(); err != nil {
+		log.Fatalf("Failed to assign slugs to existing blogs: %v", err)
+	}
+
+	// Uploaded media is kept on the local disk below BLOB_STORAGE_DIR
+	blobDir := os.Getenv("BLOB_STORAGE_DIR")
+	if blobDir == "" {
+		blobDir = "data/blobs"
+	}
+	if store, err = storage.NewLocalStore(blobDir); err != nil {
+		log.Fatalf("Failed to open blob storage: %v", err)
+	}
+	blog.SetBlobStore(store)
+
+	// Personal access tokens are validated against the user management service
+	if url := os.Getenv("USER_MANAGEMENT_URL"); url != "" {
+		blog.SetUserServiceURL(url)
+	}
+
+	// Links in feeds point at SITE_URL
+	if url := os.Getenv("SITE_URL"); url != "" {
+		blog.SetSiteURL(url)
+	}
+
+	// X-Forwarded-For is only believed from TRUSTED_PROXIES, a comma-separated list of addresses or CIDR ranges
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if err := blog.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+
+	// Subcommands such as import, export, backup and restore run against the prepared database and exit
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1], os.Args[2:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	// Background workers stop on SIGINT or SIGTERM. Buffered views are flushed
+	// once more after the server has finished its last requests.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	viewsCtx, stopViews := context.WithCancel(context.Background())
+	blog.StartMediaCleanupWorker(ctx, time.Hour)
+	viewsFlushed := blog.StartViewFlushWorker(viewsCtx, 10*time.Second)
+	// Rank trending posts before serving so requests never compute them
+	if err := blog.RefreshTrending(); err != nil {
+		log.Printf("Failed to compute trending posts: %v", err)
+	}
+	blog.StartTrendingWorker(ctx, 5*time.Minute)
+	go blog.IndexMissingRelated(ctx)
+
+	// Domain events are relayed from the outbox to webhooks and, if set, to EVENT_BROKER
+	bus := events.NewBus()
+	blog.SubscribeWebhooks(bus)
+	blog.SubscribeRelated(bus)
+	var broker events.Broker = bus
+	if url := os.Getenv("EVENT_BROKER"); url != "" && url != "inprocess" {
+		external, err := events.NewBroker(url)
+		if err != nil {
+			log.Fatalf("Invalid event broker: %v", err)
+		}
+		broker = events.Multi{bus, external}
+	}
+	defer broker.Close()
+	events.NewRelay(db, "blogs", broker).Start(ctx, time.Second)
+	blog.StartWebhookWorker(ctx, 5*time.Second)
+
 	// Routes
-	http.HandleFunc("/blogs", ProtectedRoute(blog.GetBlogs))          // GET all blogs
-	http.HandleFunc("/blogs/create", ProtectedRoute(blog.CreateBlog)) // POST a new blog
-	http.HandleFunc("/blogs/update", ProtectedRoute(blog.UpdateBlog)) // PUT update a blog
-	http.HandleFunc("/blogs/delete", ProtectedRoute(blog.DeleteBlog)) // DELETE a blog
+	http.HandleFunc("/blogs", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetBlogs)))           // GET all blogs
+	http.HandleFunc("/blogs/create", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.CreateBlog))) // POST a new blog
+	http.HandleFunc("/blogs/update", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.UpdateBlog))) // PUT update a blog
+	http.HandleFunc("/blogs/delete", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.DeleteBlog))) // DELETE a blog
+	http.HandleFunc("/blogs/export", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.ExportBlogs)))
+	http.HandleFunc("GET /blogs/export/{format}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.ExportPostsHandler)))
+	http.HandleFunc("GET /blogs/activity", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.ExportUserData)))
+	http.HandleFunc("GET /blogs/reacted", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetReacted)))
+	http.HandleFunc("PUT /blogs/{id}/reactions/{type}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.React)))
+	http.HandleFunc("DELETE /blogs/{id}/reactions/{type}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.Unreact)))
+	http.HandleFunc("POST /blogs/{id}/comments", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.AddCommentHandler)))
+	http.HandleFunc("DELETE /blogs/{id}/comments/{comment}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.DeleteCommentHandler)))
+	http.HandleFunc("/media", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.ListMedia))(w, r)
+		case http.MethodPost:
+			blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.UploadMedia))(w, r)
+		case http.MethodDelete:
+			blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.DeleteMedia))(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	http.HandleFunc("GET /analytics/blogs/{id}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetBlogStats)))
+	http.HandleFunc("GET /feed", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetFeed)))
+	http.HandleFunc("GET /bookmarks", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetSaved)))
+	http.HandleFunc("PUT /bookmarks/order", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.ReorderSaved)))
+	http.HandleFunc("PUT /bookmarks/{id}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.SavePost)))
+	http.HandleFunc("DELETE /bookmarks/{id}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.UnsavePost)))
+	http.HandleFunc("GET /reading-lists", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetReadingListsHandler)))
+	http.HandleFunc("POST /reading-lists", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.CreateReadingListHandler)))
+	http.HandleFunc("GET /reading-lists/{list}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetSaved)))
+	http.HandleFunc("DELETE /reading-lists/{list}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.DeleteReadingListHandler)))
+	http.HandleFunc("PUT /reading-lists/{list}/order", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.ReorderSaved)))
+	http.HandleFunc("PUT /reading-lists/{list}/items/{id}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.SavePost)))
+	http.HandleFunc("DELETE /reading-lists/{list}/items/{id}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.UnsavePost)))
+	http.HandleFunc("PUT /blogs/{id}/collaborators/{username}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.InviteCollaboratorHandler)))
+	http.HandleFunc("DELETE /blogs/{id}/collaborators/{username}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.RemoveCollaboratorHandler)))
+	http.HandleFunc("GET /invitations", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetInvitationsHandler)))
+	http.HandleFunc("POST /invitations/{id}/accept", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.AcceptInvitationHandler)))
+	http.HandleFunc("POST /series", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.CreateSeriesHandler)))
+	http.HandleFunc("DELETE /series/{series}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.DeleteSeriesHandler)))
+	http.HandleFunc("PUT /series/{series}/order", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.ReorderSeriesHandler)))
+	http.HandleFunc("PUT /series/{series}/posts/{id}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.AddSeriesPostHandler)))
+	http.HandleFunc("DELETE /series/{series}/posts/{id}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsWrite, blog.RemoveSeriesPostHandler)))
+	http.HandleFunc("GET /webhooks", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.GetWebhooksHandler)))
+	http.HandleFunc("POST /webhooks", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.CreateWebhookHandler)))
+	http.HandleFunc("GET /webhooks/{webhook}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.GetWebhookHandler)))
+	http.HandleFunc("DELETE /webhooks/{webhook}", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.DeleteWebhookHandler)))
+	http.HandleFunc("GET /webhooks/{webhook}/deliveries", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.GetDeliveriesHandler)))
+	http.HandleFunc("POST /webhooks/{webhook}/deliveries/{delivery}/retry", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.RetryDeliveryHandler)))
+	http.HandleFunc("POST /webhooks/{webhook}/test", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.TestWebhookHandler)))
+	http.HandleFunc("POST /admin/import", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.ImportBlogs)))
+	http.HandleFunc("/admin/authors/blogs", blog.ProtectedRoute(blog.RequireScope(blog.ScopeAdmin, blog.RemoveAuthorBlogs)))
+
+	// Public routes
+	http.HandleFunc("/blogs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		blog.OptionalAuth(blog.GetBlog)(w, r)
+	})
+	http.HandleFunc("GET /blogs/trending", blog.GetTrending)
+	http.HandleFunc("GET /blogs/{id}/{view}", func(w http.ResponseWriter, r *http.Request) {
+		// Registered as a wildcard so it does not conflict with /blogs/by-slug/{slug}
+		switch r.PathValue("view") {
+		case "related":
+			blog.OptionalAuth(blog.GetRelated)(w, r)
+		case "collaborators":
+			blog.ProtectedRoute(blog.RequireScope(blog.ScopeBlogsRead, blog.GetCollaboratorsHandler))(w, r)
+		case "comments":
+			blog.OptionalAuth(blog.GetCommentsHandler)(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	http.HandleFunc("POST /blogs/{id}/unlock", blog.UnlockBlog)
+	http.HandleFunc("GET /blogs/by-slug/{slug}", blog.OptionalAuth(blog.GetBlogBySlugHandler))
+	http.HandleFunc("GET /series/{series}", blog.OptionalAuth(blog.GetSeriesHandler))
+	http.HandleFunc("GET /authors/{username}/blogs", blog.OptionalAuth(blog.GetAuthorBlogs))
+	http.HandleFunc("GET /tags/{tag}/blogs", blog.OptionalAuth(blog.GetTagBlogs))
+	http.HandleFunc("GET /media/{id}/{variant}", blog.OptionalAuth(blog.ServeMedia))
+	http.HandleFunc("GET /feeds/{format}", blog.ServeFeed)
+	http.HandleFunc("GET /feeds/authors/{username}/{format}", blog.ServeAuthorFeed)
+	http.HandleFunc("GET /feeds/tags/{tag}/{format}", blog.ServeTagFeed)
+	http.HandleFunc("GET /sitemap.xml", blog.ServeSitemap)
+	http.HandleFunc("GET /sitemaps/{page}", blog.ServeSitemapPage)
 	http.HandleFunc("/swagger/", httpSwagger.WrapHandler)
 
 	log.Println("Starting blog management service on port 8001...")
-	err = http.ListenAndServe(":8001", nil)
-	if err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	server := &http.Server{Addr: ":8001"}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down blog management service...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to finish open requests: %v", err)
+	}
+	stopViews()
+	<-viewsFlushed
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already there.
+func addColumnIfMissing(table, column, definition string) error {
+	var count int
+	query := `SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`
+	if err := db.QueryRow(query, table, column).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
 	}
+	_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
 }
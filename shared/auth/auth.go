@@ -0,0 +1,54 @@
+// Package auth holds the request authorization shared by the services. Each
+// service authenticates requests itself and stores its own claims type in the
+// request context.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// ScopeAdmin is the scope of Admin-only endpoints. RequireScope also demands
+// the Admin role for it, from sessions as well as personal access tokens.
+const ScopeAdmin = "admin"
+
+// RoleAdmin is the role of administrators.
+const RoleAdmin = "Admin"
+
+// Claims are the claims of an authenticated request.
+type Claims interface {
+	// HasScope reports whether the claims grant scope.
+	HasScope(scope string) bool
+	// IsAdmin reports whether the caller has the Admin role.
+	IsAdmin() bool
+}
+
+// HasScope reports whether a caller holding scopes may use scope. Sessions
+// created by /login carry no scopes and are not restricted; personal access
+// tokens are limited to the scopes they were granted.
+func HasScope(scopes []string, personalAccessToken bool, scope string) bool {
+	return !personalAccessToken || slices.Contains(scopes, scope)
+}
+
+// RequireScope rejects requests whose claims do not grant scope, and requests
+// for ScopeAdmin by callers who are not Admins. It must run after the
+// service's authentication, whose claims claimsFromContext returns.
+func RequireScope[C Claims](scope string, claimsFromContext func(context.Context) (C, error), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := claimsFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if scope == ScopeAdmin && !claims.IsAdmin() {
+			http.Error(w, "Forbidden: Admins only", http.StatusForbidden)
+			return
+		}
+		if !claims.HasScope(scope) {
+			http.Error(w, "Forbidden: token lacks the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testClaims struct {
+	role     string
+	scopes   []string
+	personal bool
+}
+
+func (c *testClaims) IsAdmin() bool {
+	return c.role == RoleAdmin
+}
+
+func (c *testClaims) HasScope(scope string) bool {
+	return HasScope(c.scopes, c.personal, scope)
+}
+
+type claimsKey struct{}
+
+func claimsFromContext(ctx context.Context) (*testClaims, error) {
+	claims, ok := ctx.Value(claimsKey{}).(*testClaims)
+	if !ok {
+		return nil, errors.New("no claims in context")
+	}
+	return claims, nil
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *testClaims
+		scope  string
+		want   int
+	}{
+		{name: "anonymous", scope: "blogs:read", want: http.StatusUnauthorized},
+		{name: "session", claims: &testClaims{}, scope: "blogs:write", want: http.StatusOK},
+		{name: "token with the scope", claims: &testClaims{scopes: []string{"blogs:read", "blogs:write"}, personal: true}, scope: "blogs:write", want: http.StatusOK},
+		{name: "token without the scope", claims: &testClaims{scopes: []string{"blogs:read"}, personal: true}, scope: "blogs:write", want: http.StatusForbidden},
+		{name: "Admin session", claims: &testClaims{role: RoleAdmin}, scope: ScopeAdmin, want: http.StatusOK},
+		{name: "writer session", claims: &testClaims{role: "Writer"}, scope: ScopeAdmin, want: http.StatusForbidden},
+		{name: "Admin token with the admin scope", claims: &testClaims{role: RoleAdmin, scopes: []string{ScopeAdmin}, personal: true}, scope: ScopeAdmin, want: http.StatusOK},
+		{name: "Admin token without the admin scope", claims: &testClaims{role: RoleAdmin, scopes: []string{"blogs:read"}, personal: true}, scope: ScopeAdmin, want: http.StatusForbidden},
+		{name: "writer token with the admin scope", claims: &testClaims{role: "Writer", scopes: []string{ScopeAdmin}, personal: true}, scope: ScopeAdmin, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireScope(tt.scope, claimsFromContext, func(w http.ResponseWriter, r *http.Request) {})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), claimsKey{}, tt.claims))
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,556 @@
+// Package backup writes database snapshots to portable archives and restores them.
+//
+// An archive is a ZIP file holding one JSON Lines file per table, with each row
+// a JSON array in column order, a copy of every uploaded file below blobs/,
+// and a manifest.json that records the archive format, the service and schema
+// version it was taken from, the columns, row count and SHA-256 checksum of
+// every table and the size and checksum of every file. Values are plain JSON,
+// so an archive does not depend on the database it was taken from.
+package backup
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shared/storage"
+)
+
+// FormatVersion is the version of the archive layout written by this package.
+// Version 2 added uploaded files.
+const FormatVersion = 2
+
+const manifestName = "manifest.json"
+
+var (
+	// ErrNotEmpty is returned when restoring into a database that already has
+	// rows, or blob storage that already has files.
+	ErrNotEmpty = errors.New("restore target is not empty")
+	// ErrIncompatible is returned for archives of another service, archive format or schema version.
+	ErrIncompatible = errors.New("backup is not compatible with this database")
+	// ErrCorrupt is returned when an archive is incomplete or fails its checksums.
+	ErrCorrupt = errors.New("backup archive is corrupt")
+)
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	Service       string    `json:"service"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Tables        []Table   `json:"tables"`
+	Blobs         []Blob    `json:"blobs,omitempty"`
+}
+
+// Table describes one table of an archive.
+type Table struct {
+	Name    string   `json:"name"`
+	File    string   `json:"file"`
+	Columns []Column `json:"columns"`
+	Rows    int64    `json:"rows"`
+	SHA256  string   `json:"sha256"`
+}
+
+// Blob describes one uploaded file of an archive.
+type Blob struct {
+	Key         string `json:"key"`
+	File        string `json:"file"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// Column is a column name and its database type, used to restore dates.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Binary values that are not valid UTF-8 are written as {"base64": "..."}.
+type binaryValue struct {
+	Base64 []byte `json:"base64"`
+}
+
+// Write takes a consistent snapshot of every table of db and writes it to w as
+// an archive of service at schemaVersion, followed by every file in blobs
+// unless blobs is nil. Files are copied after the tables are read, so uploads
+// made meanwhile may be included and files deleted meanwhile may be missing.
+func Write(ctx context.Context, db *sql.DB, blobs storage.BlobStore, w io.Writer, service string, schemaVersion int) (*Manifest, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	names, err := tableNames(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{FormatVersion: FormatVersion, Service: service, SchemaVersion: schemaVersion, CreatedAt: time.Now().UTC()}
+	archive := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := archive.Create("tables/" + name + ".jsonl")
+		if err != nil {
+			return nil, err
+		}
+		table, err := writeTable(ctx, tx, f, name)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", name, err)
+		}
+		manifest.Tables = append(manifest.Tables, table)
+	}
+	if blobs != nil {
+		err := blobs.Walk(ctx, func(key string) error {
+			blob, err := writeBlob(ctx, archive, blobs, key)
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil // Deleted since it was listed
+			} else if err != nil {
+				return fmt.Errorf("file %s: %w", key, err)
+			}
+			manifest.Blobs = append(manifest.Blobs, blob)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The manifest comes last because it holds the checksums of the tables
+	f, err := archive.Create(manifestName)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, archive.Close()
+}
+
+// tableNames lists the base tables of the current database.
+func tableNames(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// writeTable writes every row of a table as a line of JSON.
+func writeTable(ctx context.Context, tx *sql.Tx, w io.Writer, name string) (Table, error) {
+	table := Table{Name: name, File: "tables/" + name + ".jsonl"}
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM `"+name+"`")
+	if err != nil {
+		return table, err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return table, err
+	}
+	for _, t := range types {
+		table.Columns = append(table.Columns, Column{Name: t.Name(), Type: t.DatabaseTypeName()})
+	}
+
+	sum := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(w, sum))
+	encoder := json.NewEncoder(out)
+	values := make([]interface{}, len(types))
+	pointers := make([]interface{}, len(types))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return table, err
+		}
+		for i, v := range values {
+			values[i] = encodeValue(v)
+		}
+		if err := encoder.Encode(values); err != nil {
+			return table, err
+		}
+		table.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return table, err
+	}
+	if err := out.Flush(); err != nil {
+		return table, err
+	}
+	table.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return table, nil
+}
+
+// writeBlob copies the file stored under key into the archive.
+func writeBlob(ctx context.Context, archive *zip.Writer, blobs storage.BlobStore, key string) (Blob, error) {
+	r, info, err := blobs.Get(ctx, key)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer r.Close()
+	blob := Blob{Key: key, File: "blobs/" + key, ContentType: info.ContentType}
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: blob.File, Method: zip.Deflate, Modified: info.ModTime})
+	if err != nil {
+		return blob, err
+	}
+	sum := sha256.New()
+	if blob.Size, err = io.Copy(io.MultiWriter(f, sum), r); err != nil {
+		return blob, err
+	}
+	blob.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return blob, nil
+}
+
+// encodeValue converts a scanned value to its JSON form.
+func encodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return binaryValue{Base64: v}
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
+
+// Open reads the manifest of an archive and verifies the checksum and row
+// count of every table and the checksum and size of every file.
+func Open(archive *zip.Reader) (*Manifest, error) {
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	f, ok := files[manifestName]
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s", ErrCorrupt, manifestName)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, manifestName, err)
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("%w: archive format %d is not supported (this build reads up to %d)", ErrIncompatible, manifest.FormatVersion, FormatVersion)
+	}
+
+	for _, table := range manifest.Tables {
+		f, ok := files[table.File]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is missing", ErrCorrupt, table.File)
+		}
+		sum := sha256.New()
+		lines, err := countLines(f, sum)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, table.File, err)
+		}
+		if hex.EncodeToString(sum.Sum(nil)) != table.SHA256 || lines != table.Rows {
+			return nil, fmt.Errorf("%w: %s does not match its checksum", ErrCorrupt, table.File)
+		}
+	}
+	for _, blob := range manifest.Blobs {
+		f, ok := files[blob.File]
+		if !ok || blob.File != "blobs/"+blob.Key {
+			return nil, fmt.Errorf("%w: %s is missing", ErrCorrupt, blob.File)
+		}
+		sum := sha256.New()
+		size, err := hashFile(f, sum)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrCorrupt, blob.File, err)
+		}
+		if hex.EncodeToString(sum.Sum(nil)) != blob.SHA256 || size != blob.Size {
+			return nil, fmt.Errorf("%w: %s does not match its checksum", ErrCorrupt, blob.File)
+		}
+	}
+	return &manifest, nil
+}
+
+// hashFile hashes a file of an archive and returns its size.
+func hashFile(f *zip.File, sum hash.Hash) (int64, error) {
+	r, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(sum, r)
+}
+
+// Check reports whether an archive can be restored into the database of
+// service at schemaVersion.
+func (m *Manifest) Check(service string, schemaVersion int) error {
+	if m.Service != service {
+		return fmt.Errorf("%w: it is a backup of %s, not %s", ErrIncompatible, m.Service, service)
+	}
+	if m.SchemaVersion != schemaVersion {
+		return fmt.Errorf("%w: it was taken at schema version %d and this build is at %d; restore it with a build at schema version %d and upgrade afterwards",
+			ErrIncompatible, m.SchemaVersion, schemaVersion, m.SchemaVersion)
+	}
+	return nil
+}
+
+// countLines hashes a file of an archive and counts its lines.
+func countLines(f *zip.File, sum hash.Hash) (int64, error) {
+	r, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	var lines int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		sum.Write(buf[:n])
+		for _, c := range buf[:n] {
+			if c == '\n' {
+				lines++
+			}
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+}
+
+// Restore loads an archive of service at schemaVersion into db, and its files
+// into blobs unless blobs is nil. Every table must already exist with the
+// archived columns and be empty, and so must blobs; the archive is verified
+// before anything is written, and all rows are loaded in a single transaction.
+// Files are stored before the transaction commits and removed again if the
+// restore fails.
+func Restore(ctx context.Context, db *sql.DB, blobs storage.BlobStore, archive *zip.Reader, service string, schemaVersion int) (manifest *Manifest, err error) {
+	manifest, err = Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.Check(service, schemaVersion); err != nil {
+		return nil, err
+	}
+	if blobs != nil {
+		if err := checkBlobTarget(ctx, blobs); err != nil {
+			return nil, err
+		}
+	}
+
+	// Foreign key checks are switched off for the connection so that tables
+	// can be loaded in any order
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkTarget(ctx, tx, manifest); err != nil {
+		return nil, err
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	for _, table := range manifest.Tables {
+		if err := restoreTable(ctx, tx, files[table.File], table); err != nil {
+			return nil, fmt.Errorf("table %s: %w", table.Name, err)
+		}
+	}
+	if blobs != nil {
+		var stored []string
+		defer func() {
+			if err != nil {
+				for _, key := range stored {
+					blobs.Delete(context.Background(), key)
+				}
+			}
+		}()
+		for _, blob := range manifest.Blobs {
+			if err := restoreBlob(ctx, blobs, files[blob.File], blob); err != nil {
+				return nil, fmt.Errorf("file %s: %w", blob.Key, err)
+			}
+			stored = append(stored, blob.Key)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// errStop ends a walk early.
+var errStop = errors.New("stop")
+
+// checkBlobTarget makes sure blobs holds no files.
+func checkBlobTarget(ctx context.Context, blobs storage.BlobStore) error {
+	err := blobs.Walk(ctx, func(key string) error {
+		return errStop
+	})
+	if errors.Is(err, errStop) {
+		return fmt.Errorf("%w: blob storage has files", ErrNotEmpty)
+	}
+	return err
+}
+
+// restoreBlob stores one file of an archive.
+func restoreBlob(ctx context.Context, blobs storage.BlobStore, f *zip.File, blob Blob) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return blobs.Put(ctx, blob.Key, r, blob.ContentType)
+}
+
+// checkTarget makes sure the database has the archived tables and columns
+// and holds no rows.
+func checkTarget(ctx context.Context, tx *sql.Tx, manifest *Manifest) error {
+	names, err := tableNames(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var found int
+		err := tx.QueryRowContext(ctx, "SELECT 1 FROM `"+name+"` LIMIT 1").Scan(&found)
+		if err == nil {
+			return fmt.Errorf("%w: table %s has rows", ErrNotEmpty, name)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, name := range names {
+		existing[name] = true
+	}
+	for _, table := range manifest.Tables {
+		if !existing[table.Name] {
+			return fmt.Errorf("%w: table %s does not exist", ErrIncompatible, table.Name)
+		}
+		rows, err := tx.QueryContext(ctx, "SELECT * FROM `"+table.Name+"` LIMIT 0")
+		if err != nil {
+			return err
+		}
+		columns, err := rows.Columns()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		have := map[string]bool{}
+		for _, c := range columns {
+			have[c] = true
+		}
+		for _, c := range table.Columns {
+			if !have[c.Name] {
+				return fmt.Errorf("%w: column %s.%s does not exist", ErrIncompatible, table.Name, c.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreTable inserts the rows of one table.
+func restoreTable(ctx context.Context, tx *sql.Tx, f *zip.File, table Table) error {
+	if table.Rows == 0 {
+		return nil
+	}
+	names := make([]string, len(table.Columns))
+	for i, c := range table.Columns {
+		names[i] = "`" + c.Name + "`"
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO `"+table.Name+"` ("+strings.Join(names, ", ")+") VALUES ("+placeholders+")")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for line := int64(1); ; line++ {
+		var row []interface{}
+		if err := decoder.Decode(&row); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: row %d: %v", ErrCorrupt, line, err)
+		}
+		if len(row) != len(table.Columns) {
+			return fmt.Errorf("%w: row %d has %d values for %d columns", ErrCorrupt, line, len(row), len(table.Columns))
+		}
+		for i, v := range row {
+			if row[i], err = decodeValue(v, table.Columns[i]); err != nil {
+				return fmt.Errorf("%w: row %d, column %s: %v", ErrCorrupt, line, table.Columns[i].Name, err)
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return fmt.Errorf("row %d: %w", line, err)
+		}
+	}
+}
+
+// decodeValue converts a JSON value back to a query argument for a column.
+func decodeValue(v interface{}, column Column) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String(), nil
+	case map[string]interface{}:
+		encoded, ok := v["base64"].(string)
+		if !ok {
+			return nil, errors.New("unexpected object")
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	case string:
+		switch column.Type {
+		case "DATE", "DATETIME", "TIMESTAMP":
+			return time.Parse(time.RFC3339Nano, v)
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,308 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive builds an in-memory archive of service blogs at schema version
+// 5 with one table and one file, after letting edit change its manifest and
+// files. The manifest is encoded afterwards unless edit removed it.
+func testArchive(t *testing.T, edit func(m *Manifest, files map[string]string)) *zip.Reader {
+	t.Helper()
+	rows := "[1,\"hello\"]\n[2,\"world\"]\n"
+	blob := "GIF89a"
+	manifest := &Manifest{
+		FormatVersion: FormatVersion,
+		Service:       "blogs",
+		SchemaVersion: 5,
+		CreatedAt:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Tables: []Table{{
+			Name:    "posts",
+			File:    "tables/posts.jsonl",
+			Columns: []Column{{Name: "id", Type: "INT"}, {Name: "title", Type: "VARCHAR"}},
+			Rows:    2,
+			SHA256:  sha256Hex(rows),
+		}},
+		Blobs: []Blob{{Key: "media/a.gif", File: "blobs/media/a.gif", ContentType: "image/gif", Size: int64(len(blob)), SHA256: sha256Hex(blob)}},
+	}
+	files := map[string]string{manifestName: "", "tables/posts.jsonl": rows, "blobs/media/a.gif": blob}
+	if edit != nil {
+		edit(manifest, files)
+	}
+	if _, ok := files[manifestName]; ok {
+		encoded, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[manifestName] = string(encoded)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(m *Manifest, files map[string]string)
+		wantErr error
+		wantMsg string
+	}{
+		{name: "valid"},
+		{
+			name:    "no manifest",
+			edit:    func(m *Manifest, files map[string]string) { delete(files, manifestName) },
+			wantErr: ErrCorrupt,
+			wantMsg: "no manifest.json",
+		},
+		{
+			name:    "newer archive format",
+			edit:    func(m *Manifest, files map[string]string) { m.FormatVersion = FormatVersion + 1 },
+			wantErr: ErrIncompatible,
+			wantMsg: "not supported",
+		},
+		{
+			name:    "table file missing",
+			edit:    func(m *Manifest, files map[string]string) { delete(files, "tables/posts.jsonl") },
+			wantErr: ErrCorrupt,
+			wantMsg: "tables/posts.jsonl is missing",
+		},
+		{
+			name: "table checksum mismatch",
+			edit: func(m *Manifest, files map[string]string) {
+				files["tables/posts.jsonl"] = "[1,\"hello\"]\n[2,\"w0rld\"]\n"
+			},
+			wantErr: ErrCorrupt,
+			wantMsg: "does not match its checksum",
+		},
+		{
+			name:    "row count mismatch",
+			edit:    func(m *Manifest, files map[string]string) { m.Tables[0].Rows = 3 },
+			wantErr: ErrCorrupt,
+			wantMsg: "does not match its checksum",
+		},
+		{
+			name:    "file missing",
+			edit:    func(m *Manifest, files map[string]string) { delete(files, "blobs/media/a.gif") },
+			wantErr: ErrCorrupt,
+			wantMsg: "blobs/media/a.gif is missing",
+		},
+		{
+			name:    "file outside its key",
+			edit:    func(m *Manifest, files map[string]string) { m.Blobs[0].Key = "avatars/a.gif" },
+			wantErr: ErrCorrupt,
+			wantMsg: "is missing",
+		},
+		{
+			name:    "file size mismatch",
+			edit:    func(m *Manifest, files map[string]string) { m.Blobs[0].Size++ },
+			wantErr: ErrCorrupt,
+			wantMsg: "does not match its checksum",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := Open(testArchive(t, tt.edit))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Fatalf("Open() error = %v, want %v containing %q", err, tt.wantErr, tt.wantMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if manifest.Service != "blogs" || len(manifest.Tables) != 1 || manifest.rows() != 2 || len(manifest.Blobs) != 1 {
+				t.Errorf("Open() = %+v", manifest)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	manifest := &Manifest{FormatVersion: FormatVersion, Service: "blogs", SchemaVersion: 5}
+	tests := []struct {
+		name          string
+		service       string
+		schemaVersion int
+		wantMsg       string
+	}{
+		{name: "same service and schema", service: "blogs", schemaVersion: 5},
+		{name: "other service", service: "users", schemaVersion: 5, wantMsg: "it is a backup of blogs, not users"},
+		{name: "older build", service: "blogs", schemaVersion: 4, wantMsg: "taken at schema version 5 and this build is at 4"},
+		{name: "newer build", service: "blogs", schemaVersion: 6, wantMsg: "restore it with a build at schema version 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := manifest.Check(tt.service, tt.schemaVersion)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("Check() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrIncompatible) || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Check() error = %v, want ErrIncompatible containing %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	manifest := &Manifest{Tables: []Table{{Name: "posts", Columns: []Column{{Name: "id"}, {Name: "title"}}}}}
+	tests := []struct {
+		name    string
+		tables  map[string]fakeTable
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:   "empty target",
+			tables: map[string]fakeTable{"posts": {columns: []string{"id", "title", "created_at"}}, "tags": {columns: []string{"id"}}},
+		},
+		{
+			name:    "table has rows",
+			tables:  map[string]fakeTable{"posts": {columns: []string{"id", "title"}}, "tags": {columns: []string{"id"}, rows: 1}},
+			wantErr: ErrNotEmpty,
+			wantMsg: "table tags has rows",
+		},
+		{
+			name:    "table missing",
+			tables:  map[string]fakeTable{"tags": {columns: []string{"id"}}},
+			wantErr: ErrIncompatible,
+			wantMsg: "table posts does not exist",
+		},
+		{
+			name:    "column missing",
+			tables:  map[string]fakeTable{"posts": {columns: []string{"id"}}},
+			wantErr: ErrIncompatible,
+			wantMsg: "column posts.title does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := sql.OpenDB(fakeDB{tt.tables})
+			defer db.Close()
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			err = checkTarget(context.Background(), tx, manifest)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("checkTarget() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("checkTarget() error = %v, want %v containing %q", err, tt.wantErr, tt.wantMsg)
+			}
+		})
+	}
+}
+
+// fakeTable is a table of fakeDB: its columns and how many rows it holds.
+type fakeTable struct {
+	columns []string
+	rows    int
+}
+
+// fakeDB is a database/sql driver that answers the queries checkTarget
+// makes from a fixed set of tables.
+type fakeDB struct {
+	tables map[string]fakeTable
+}
+
+func (d fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn(d), nil }
+func (d fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn fakeDB
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c fakeConn) Commit() error                             { return nil }
+func (c fakeConn) Rollback() error                           { return nil }
+
+type fakeStmt struct {
+	conn  fakeConn
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "information_schema.tables") {
+		rows := &fakeRows{columns: []string{"table_name"}}
+		for name := range s.conn.tables {
+			rows.values = append(rows.values, []driver.Value{name})
+		}
+		return rows, nil
+	}
+	name := strings.Split(s.query, "`")[1]
+	table, ok := s.conn.tables[name]
+	if !ok {
+		return nil, errors.New("no such table " + name)
+	}
+	if strings.HasPrefix(s.query, "SELECT 1 ") {
+		rows := &fakeRows{columns: []string{"1"}}
+		if table.rows > 0 {
+			rows.values = [][]driver.Value{{int64(1)}}
+		}
+		return rows, nil
+	}
+	return &fakeRows{columns: table.columns}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// handlerAttempts is how often a Bus calls a handler with an event before it
+// gives up on it.
+const handlerAttempts = 5
+
+// Handler receives events from a Bus. An error makes the bus call the handler
+// with the event again later, without affecting other handlers or brokers.
+type Handler func(ctx context.Context, e Event) error
+
+// Bus is an in-process broker that hands events to the handlers subscribed
+// to it, one after another. A handler that fails is called again after 1, 2,
+// 4 and 8 seconds; its retries are kept in memory only, so handlers should be
+// able to catch up on their own after a restart.
+type Bus struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]Handler
+	closed   bool
+	backoff  time.Duration        // Delay before the first retry, doubled for each further one
+	pending  map[*time.Timer]bool // Retries waiting for their delay
+	retries  sync.WaitGroup       // Retries scheduled or running
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{handlers: map[int]Handler{}, backoff: time.Second, pending: map[*time.Timer]bool{}}
+}
+
+// Subscribe calls handler with every published event until the returned
+// function is called.
+func (b *Bus) Subscribe(handler Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+// Publish calls every handler with e. Handlers that fail are retried in the
+// background, so Publish always accepts the event.
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h(ctx, e); err != nil {
+			b.retry(h, e, 1, err)
+		}
+	}
+	return nil
+}
+
+// retry calls h with e again after a delay that doubles with every attempt,
+// until it succeeds, it was called handlerAttempts times or the bus is closed.
+func (b *Bus) retry(h Handler, e Event, attempts int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || attempts >= handlerAttempts {
+		log.Printf("Giving up on event %s %d after %d attempts: %v", e.Subject(), e.ID, attempts, err)
+		return
+	}
+	b.retries.Add(1)
+	// The timer is only registered once AfterFunc returns; the callback waits
+	// for that by taking the lock first
+	var timer *time.Timer
+	timer = time.AfterFunc(b.backoff<<(attempts-1), func() {
+		defer b.retries.Done()
+		b.mu.Lock()
+		delete(b.pending, timer)
+		b.mu.Unlock()
+		if err := h(context.Background(), e); err != nil {
+			b.retry(h, e, attempts+1, err)
+		}
+	})
+	b.pending[timer] = true
+}
+
+// Close drops the retries still waiting for their delay and waits for those
+// already running.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	for timer := range b.pending {
+		if timer.Stop() {
+			b.retries.Done()
+		}
+	}
+	clear(b.pending)
+	b.mu.Unlock()
+	b.retries.Wait()
+	return nil
+}
+
+// Multi publishes each event to several brokers in turn.
+type Multi []Broker
+
+// Publish hands e to every broker, stopping at the first that fails. The
+// relay then publishes e again to all of them, so each sees it at least once.
+// A Bus never fails, since it retries its handlers itself.
+func (m Multi) Publish(ctx context.Context, e Event) error {
+	for _, b := range m {
+		if err := b.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every broker.
+func (m Multi) Close() error {
+	var errs []error
+	for _, b := range m {
+		errs = append(errs, b.Close())
+	}
+	return errors.Join(errs...)
+}
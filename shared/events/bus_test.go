@@ -0,0 +1,93 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBusRetriesFailingHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int // Calls that fail before the handler succeeds
+		wantCalls int
+	}{
+		{name: "succeeds at once", failures: 0, wantCalls: 1},
+		{name: "succeeds on a retry", failures: 2, wantCalls: 3},
+		{name: "gives up", failures: 100, wantCalls: handlerAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewBus()
+			bus.backoff = time.Millisecond
+
+			var mu sync.Mutex
+			failing, healthy := 0, 0
+			done := make(chan struct{})
+			bus.Subscribe(func(ctx context.Context, e Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				failing++
+				if failing == tt.wantCalls {
+					close(done)
+				}
+				if failing <= tt.failures {
+					return errors.New("database is down")
+				}
+				return nil
+			})
+			bus.Subscribe(func(ctx context.Context, e Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				healthy++
+				return nil
+			})
+
+			if err := bus.Publish(context.Background(), testEvent); err != nil {
+				t.Fatalf("Publish() error = %v, want nil while handlers retry", err)
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler was not retried")
+			}
+			bus.Close()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if failing != tt.wantCalls {
+				t.Errorf("failing handler called %d times, want %d", failing, tt.wantCalls)
+			}
+			if healthy != 1 {
+				t.Errorf("healthy handler called %d times, want 1", healthy)
+			}
+		})
+	}
+}
+
+func TestBusCloseDropsPendingRetries(t *testing.T) {
+	bus := NewBus()
+	bus.backoff = time.Hour
+	calls := 0
+	bus.Subscribe(func(ctx context.Context, e Event) error {
+		calls++
+		return errors.New("database is down")
+	})
+	bus.Publish(context.Background(), testEvent)
+
+	closed := make(chan struct{})
+	go func() {
+		bus.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() waited for a pending retry")
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
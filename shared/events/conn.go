@@ -0,0 +1,106 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ioTimeout bounds each exchange with a broker.
+const ioTimeout = 10 * time.Second
+
+// lazyConn is a TCP connection to a broker that is dialed on first use and
+// dialed again after any error.
+type lazyConn struct {
+	addr      string
+	tlsConfig *tls.Config // Used by startTLS
+	handshake func(c *lazyConn) error
+
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// do runs fn with the connection, which is closed if fn fails.
+func (c *lazyConn) do(ctx context.Context, fn func(rw *bufio.ReadWriter) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline := time.Now().Add(ioTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if c.conn == nil {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+		if err != nil {
+			return err
+		}
+		c.conn, c.rw = conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		conn.SetDeadline(deadline)
+		if err := c.handshake(c); err != nil {
+			c.close()
+			return err
+		}
+	}
+	c.conn.SetDeadline(deadline)
+	if err := fn(c.rw); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// startTLS switches the open connection to TLS with tlsConfig.
+func (c *lazyConn) startTLS() error {
+	conn := tls.Client(c.conn, c.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.rw = conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	return nil
+}
+
+// newTLSConfig returns the TLS settings for a broker at host, trusting the
+// certificates in the PEM file caFile in addition to the system's, if set.
+func newTLSConfig(host, caFile string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+func (c *lazyConn) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.rw = nil, nil
+	return err
+}
+
+// Close closes the connection if it is open.
+func (c *lazyConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.close()
+}
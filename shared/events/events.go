@@ -0,0 +1,68 @@
+// Package events records domain events in a transactional outbox and relays
+// them to a message broker.
+//
+// Events are written to the outbox table in the same transaction as the
+// change they describe, so an event exists exactly when its change was
+// committed. A Relay then claims unpublished rows in order, publishes them
+// and marks them published; rows the broker keeps rejecting are retried with
+// a growing delay and finally dead-lettered. Delivery is at least once:
+// consumers should ignore events whose source and ID they have already seen.
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Event is a domain event as published to a broker.
+type Event struct {
+	ID         int64           `json:"id"`     // Position in the outbox, increasing per source
+	Source     string          `json:"source"` // Service that emitted the event, such as "blogs"
+	Type       string          `json:"type"`   // Such as "BlogCreated"
+	Data       json.RawMessage `json:"data"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// Subject is the broker subject of an event, such as "blogs.BlogCreated".
+func (e Event) Subject() string {
+	return e.Source + "." + e.Type
+}
+
+// Record adds an event to the outbox as part of tx. It is published once tx commits.
+func Record(tx *sql.Tx, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO outbox (event_type, payload) VALUES (?, ?)`, eventType, payload)
+	return err
+}
+
+// Broker publishes events to subscribers. Implementations must be safe for
+// concurrent use.
+type Broker interface {
+	// Publish delivers an event, returning once the broker has accepted it.
+	Publish(ctx context.Context, e Event) error
+	// Close releases the broker's connections.
+	Close() error
+}
+
+// NewBroker returns the broker named by a URL: "nats://host:port" or
+// "tls://host:port" (see NewNATS), "redis://host:port" or "rediss://host:port"
+// (see NewRedis), or "" and "inprocess" for a Bus.
+func NewBroker(url string) (Broker, error) {
+	switch {
+	case url == "" || url == "inprocess":
+		return NewBus(), nil
+	case strings.HasPrefix(url, "nats://"), strings.HasPrefix(url, "tls://"):
+		return NewNATS(url)
+	case strings.HasPrefix(url, "redis://"), strings.HasPrefix(url, "rediss://"):
+		return NewRedis(url)
+	default:
+		return nil, fmt.Errorf("unsupported event broker %q: use inprocess, nats://host:port or redis://host:port", url)
+	}
+}
@@ -0,0 +1,195 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeNATS is a NATS server for a single connection that records the
+// CONNECT options and the messages it receives.
+type fakeNATS struct {
+	tlsConfig   *tls.Config // Switch to TLS after INFO when set
+	tlsRequired bool        // Announce tls_required in INFO
+	reject      string      // Answer CONNECT with this -ERR when set
+
+	connect  natsConnect
+	messages []string // Subject and payload of each PUB
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"tls_required\":%t}\r\n", f.tlsRequired)
+	if f.tlsConfig != nil {
+		tlsConn := tls.Server(conn, f.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		conn = tlsConn
+	}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb, args, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch verb {
+		case "CONNECT":
+			json.Unmarshal([]byte(args), &f.connect)
+			if f.reject != "" {
+				fmt.Fprintf(conn, "-ERR '%s'\r\n", f.reject)
+				return
+			}
+		case "PUB":
+			subject, size, _ := strings.Cut(args, " ")
+			n, _ := strconv.Atoi(size)
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			f.messages = append(f.messages, subject+" "+string(payload[:n]))
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		}
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	serverTLS, caFile := testTLS(t)
+	payload, _ := json.Marshal(testEvent)
+	message := "blogs.BlogCreated " + string(payload)
+
+	tests := []struct {
+		name    string
+		url     string // %s is replaced by the server address
+		server  fakeNATS
+		connect natsConnect
+		wantErr string
+	}{
+		{
+			name:    "anonymous",
+			url:     "nats://%s",
+			connect: natsConnect{Name: "events-relay"},
+		},
+		{
+			name:    "user and password",
+			url:     "nats://relay:s3cret@%s",
+			connect: natsConnect{Name: "events-relay", User: "relay", Pass: "s3cret"},
+		},
+		{
+			name:    "token",
+			url:     "nats://t0ken@%s",
+			connect: natsConnect{Name: "events-relay", AuthToken: "t0ken"},
+		},
+		{
+			name:    "TLS required by the server",
+			url:     "nats://%s?ca=" + caFile,
+			server:  fakeNATS{tlsConfig: serverTLS, tlsRequired: true},
+			connect: natsConnect{Name: "events-relay", TLSRequired: true},
+		},
+		{
+			name:    "TLS required by the client",
+			url:     "tls://relay:s3cret@%s?ca=" + caFile,
+			server:  fakeNATS{tlsConfig: serverTLS},
+			connect: natsConnect{Name: "events-relay", TLSRequired: true, User: "relay", Pass: "s3cret"},
+		},
+		{
+			name:    "untrusted certificate",
+			url:     "tls://%s",
+			server:  fakeNATS{tlsConfig: serverTLS},
+			wantErr: "certificate",
+		},
+		{
+			name:    "rejected credentials",
+			url:     "nats://relay:wrong@%s",
+			server:  fakeNATS{reject: "Authorization Violation"},
+			wantErr: "nats: 'Authorization Violation'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			addr, done := serveOnce(t, server.serve)
+			n, err := NewNATS(fmt.Sprintf(tt.url, addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = n.Publish(context.Background(), testEvent)
+			n.Close()
+			<-done
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Publish() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			if server.connect != tt.connect {
+				t.Errorf("CONNECT = %+v, want %+v", server.connect, tt.connect)
+			}
+			if want := []string{message}; !reflect.DeepEqual(server.messages, want) {
+				t.Errorf("messages = %q, want %q", server.messages, want)
+			}
+		})
+	}
+}
+
+func TestNewNATSInvalidURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{"nats://", "no host"},
+		{"http://localhost:4222", "unsupported scheme"},
+		{"nats://localhost?ca=/does/not/exist.pem", "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if _, err := NewNATS(tt.url); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewNATS(%q) error = %v, want one containing %q", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNATSFlush(t *testing.T) {
+	tests := []struct {
+		name      string
+		replies   string
+		wantErr   string
+		wantWrite string
+	}{
+		{name: "pong", replies: "PONG\r\n"},
+		{name: "ok and info before pong", replies: "+OK\r\nINFO {}\r\nPONG\r\n"},
+		{name: "server ping", replies: "PING\r\nPONG\r\n", wantWrite: "PONG\r\n"},
+		{name: "error", replies: "-ERR 'Maximum Payload Violation'\r\n", wantErr: "nats: 'Maximum Payload Violation'"},
+		{name: "connection closed", replies: "+OK\r\n", wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var written strings.Builder
+			rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader(tt.replies)), bufio.NewWriter(&written))
+			err := natsFlush(rw)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("natsFlush() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("natsFlush() error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if written.String() != tt.wantWrite {
+				t.Errorf("written = %q, want %q", written.String(), tt.wantWrite)
+			}
+		})
+	}
+}
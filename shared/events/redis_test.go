@@ -0,0 +1,205 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRedis is a Redis server for a single connection that records the
+// commands it receives.
+type fakeRedis struct {
+	tlsConfig *tls.Config // Serve over TLS when set
+	reject    string      // Answer AUTH with this error when set
+
+	commands [][]string
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	if f.tlsConfig != nil {
+		conn = tls.Server(conn, f.tlsConfig)
+	}
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+		f.commands = append(f.commands, args)
+		switch {
+		case args[0] == "AUTH" && f.reject != "":
+			fmt.Fprintf(conn, "-%s\r\n", f.reject)
+		case args[0] == "XADD":
+			io.WriteString(conn, "$15\r\n1714564800000-0\r\n")
+		default:
+			io.WriteString(conn, "+OK\r\n")
+		}
+	}
+}
+
+// readRedisCommand reads a command sent as an array of bulk strings.
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "*"), "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, "$"), "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestRedisPublish(t *testing.T) {
+	serverTLS, caFile := testTLS(t)
+	xadd := []string{"XADD", "events:blogs", "MAXLEN", "~", "100000", "*",
+		"id", "7", "type", "BlogCreated", "occurred_at", "2024-05-01T12:00:00Z", "data", `{"id":3,"title":"Hello"}`}
+
+	tests := []struct {
+		name     string
+		url      string // %s is replaced by the server address
+		server   fakeRedis
+		commands [][]string
+		wantErr  string
+	}{
+		{
+			name:     "anonymous",
+			url:      "redis://%s",
+			commands: [][]string{xadd},
+		},
+		{
+			name:     "password and database",
+			url:      "redis://:s3cret@%s/2",
+			commands: [][]string{{"AUTH", "s3cret"}, {"SELECT", "2"}, xadd},
+		},
+		{
+			name:     "ACL user",
+			url:      "redis://relay:s3cret@%s",
+			commands: [][]string{{"AUTH", "relay", "s3cret"}, xadd},
+		},
+		{
+			name:     "TLS",
+			url:      "rediss://relay:s3cret@%s?ca=" + caFile,
+			server:   fakeRedis{tlsConfig: serverTLS},
+			commands: [][]string{{"AUTH", "relay", "s3cret"}, xadd},
+		},
+		{
+			name:    "untrusted certificate",
+			url:     "rediss://%s",
+			server:  fakeRedis{tlsConfig: serverTLS},
+			wantErr: "certificate",
+		},
+		{
+			name:    "rejected credentials",
+			url:     "redis://:wrong@%s",
+			server:  fakeRedis{reject: "WRONGPASS invalid username-password pair"},
+			wantErr: "redis: WRONGPASS invalid username-password pair",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			addr, done := serveOnce(t, server.serve)
+			r, err := NewRedis(fmt.Sprintf(tt.url, addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = r.Publish(context.Background(), testEvent)
+			r.Close()
+			<-done
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Publish() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			if !reflect.DeepEqual(server.commands, tt.commands) {
+				t.Errorf("commands = %q, want %q", server.commands, tt.commands)
+			}
+		})
+	}
+}
+
+func TestNewRedisInvalidURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{"redis://", "no host"},
+		{"nats://localhost:6379", "unsupported scheme"},
+		{"redis://localhost/cache", "invalid database"},
+		{"rediss://localhost?ca=/does/not/exist.pem", "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if _, err := NewRedis(tt.url); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewRedis(%q) error = %v, want one containing %q", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedisReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    string
+		wantErr string
+	}{
+		{name: "simple string", reply: "+OK\r\n", want: "OK"},
+		{name: "integer", reply: ":42\r\n", want: "42"},
+		{name: "bulk string", reply: "$15\r\n1714564800000-0\r\n", want: "1714564800000-0"},
+		{name: "bulk string with CRLF", reply: "$4\r\na\r\nb\r\n", want: "a\r\nb"},
+		{name: "null bulk string", reply: "$-1\r\n", want: ""},
+		{name: "array", reply: "*2\r\n$1\r\na\r\n:1\r\n", want: ""},
+		{name: "error", reply: "-ERR unknown command\r\n", wantErr: "redis: ERR unknown command"},
+		{name: "error inside array", reply: "*1\r\n-ERR nope\r\n", wantErr: "redis: ERR nope"},
+		{name: "unknown type", reply: "?\r\n", wantErr: "unexpected reply"},
+		{name: "truncated bulk string", reply: "$10\r\nabc\r\n", wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader(tt.reply)), bufio.NewWriter(io.Discard))
+			got, err := redisReply(rw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("redisReply() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("redisReply() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("redisReply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
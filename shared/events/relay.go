@@ -0,0 +1,188 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+)
+
+// Relay settings.
+const (
+	relayBatchSize   = 100
+	relayLease       = 5 * time.Minute    // How long a relay owns the events it claimed
+	relayMaxAttempts = 10                 // Events the broker rejects this often are dead-lettered
+	retention        = 7 * 24 * time.Hour // Published events are kept this long
+)
+
+// retryDelay is how long an event the broker rejected attempts times waits
+// before it is published again: 1, 2, 4, ... minutes, at most an hour.
+func retryDelay(attempts int) time.Duration {
+	return min(time.Minute<<min(attempts-1, 6), time.Hour)
+}
+
+// pendingEvent is a claimed event and how often publishing it failed before.
+type pendingEvent struct {
+	Event
+	attempts int
+}
+
+// Relay publishes the events of the outbox to a broker.
+type Relay struct {
+	db     *sql.DB
+	source string
+	broker Broker
+}
+
+// NewRelay returns a relay that publishes the outbox of db as events of source.
+func NewRelay(db *sql.DB, source string, broker Broker) *Relay {
+	return &Relay{db: db, source: source, broker: broker}
+}
+
+// Start publishes new events every interval until ctx is cancelled.
+func (r *Relay) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastCleanup := time.Time{}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Keep going while full batches come back so a backlog drains quickly
+			for {
+				n, err := r.Flush(ctx)
+				if err != nil {
+					log.Printf("Failed to publish events: %v", err)
+				}
+				if err != nil || n < relayBatchSize {
+					break
+				}
+			}
+			if time.Since(lastCleanup) > time.Hour {
+				if err := r.Cleanup(ctx); err != nil {
+					log.Printf("Failed to remove published events: %v", err)
+				}
+				lastCleanup = time.Now()
+			}
+		}
+	}()
+}
+
+// Flush publishes one batch of unpublished events in outbox order and returns
+// how many were published. It stops at the first event the broker rejects.
+// That event is held back for retryDelay, so it does not hold back the events
+// after it, and is dead-lettered once it failed relayMaxAttempts times: it
+// then stays in the outbox with failed_at set until an operator resets it.
+// The batch is claimed for relayLease in a short transaction first, so relays
+// of several instances do not publish the same event and no rows stay locked
+// while the broker is called. Claims of a relay that stops mid-batch simply
+// expire.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	token, pending, err := r.claim(ctx)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	var published []interface{}
+	var publishErr error
+	for _, e := range pending {
+		if publishErr = r.broker.Publish(ctx, e.Event); publishErr != nil {
+			break
+		}
+		published = append(published, e.ID)
+	}
+
+	// Record the outcome even when ctx was cancelled meanwhile, so that
+	// published events are not published again
+	ctx = context.WithoutCancel(ctx)
+	if len(published) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(published)), ", ")
+		if _, err := r.db.ExecContext(ctx, `UPDATE outbox SET published_at = CURRENT_TIMESTAMP, last_error = NULL, claim_token = NULL, claimed_until = NULL
+			WHERE claim_token = ? AND id IN (`+placeholders+`)`, append([]interface{}{token}, published...)...); err != nil {
+			return 0, err
+		}
+	}
+	if publishErr != nil {
+		failed := pending[len(published)]
+		attempts := failed.attempts + 1
+		dead := attempts >= relayMaxAttempts
+		if dead {
+			log.Printf("Dead-lettered event %d (%s) after %d attempts: %v", failed.ID, failed.Type, attempts, publishErr)
+		}
+		if _, err := r.db.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = ?, claim_token = NULL,
+			claimed_until = CURRENT_TIMESTAMP + INTERVAL ? SECOND, failed_at = IF(?, CURRENT_TIMESTAMP, NULL)
+			WHERE claim_token = ? AND id = ?`,
+			publishErr.Error(), int(retryDelay(attempts)/time.Second), dead, token, failed.ID); err != nil {
+			return len(published), err
+		}
+	}
+	// Hand back what was not published so the next flush retries it
+	if _, err := r.db.ExecContext(ctx, `UPDATE outbox SET claim_token = NULL, claimed_until = NULL WHERE claim_token = ?`, token); err != nil {
+		return len(published), err
+	}
+	return len(published), publishErr
+}
+
+// claim takes one batch of unpublished events that no other relay holds and
+// that are neither waiting for a retry nor dead-lettered, and marks them with
+// a new claim token, which it returns with the events.
+func (r *Relay) claim(ctx context.Context) (string, []pendingEvent, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(buf)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, event_type, payload, created_at, attempts FROM outbox
+		WHERE published_at IS NULL AND failed_at IS NULL AND (claimed_until IS NULL OR claimed_until < CURRENT_TIMESTAMP)
+		ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`, relayBatchSize)
+	if err != nil {
+		return "", nil, err
+	}
+	var pending []pendingEvent
+	var ids []interface{}
+	for rows.Next() {
+		e := pendingEvent{Event: Event{Source: r.source}}
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.Type, &payload, &e.OccurredAt, &e.attempts); err != nil {
+			rows.Close()
+			return "", nil, err
+		}
+		e.Data = payload
+		pending = append(pending, e)
+		ids = append(ids, e.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+	if len(pending) == 0 {
+		return "", nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := append([]interface{}{token, int(relayLease / time.Second)}, ids...)
+	if _, err := tx.ExecContext(ctx, `UPDATE outbox SET claim_token = ?, claimed_until = CURRENT_TIMESTAMP + INTERVAL ? SECOND
+		WHERE id IN (`+placeholders+`)`, args...); err != nil {
+		return "", nil, err
+	}
+	return token, pending, tx.Commit()
+}
+
+// Cleanup removes events that were published more than a week ago.
+func (r *Relay) Cleanup(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM outbox WHERE published_at < ?`, time.Now().Add(-retention))
+	return err
+}
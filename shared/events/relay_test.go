@@ -0,0 +1,451 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		{relayMaxAttempts, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRelayFlush(t *testing.T) {
+	outbox := newFakeOutbox(3)
+	broker := &fakeBroker{}
+	relay := newTestRelay(t, outbox, broker)
+
+	n, err := relay.Flush(context.Background())
+	if err != nil || n != 3 {
+		t.Fatalf("Flush() = %d, %v, want 3, nil", n, err)
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(broker.ids(), want) {
+		t.Errorf("published %v, want %v", broker.ids(), want)
+	}
+	for _, row := range outbox.rows {
+		if row.publishedAt == nil || row.claimToken != "" || row.claimedUntil != nil {
+			t.Errorf("row %d = %+v, want published and unclaimed", row.id, *row)
+		}
+	}
+	if n, err := relay.Flush(context.Background()); err != nil || n != 0 {
+		t.Errorf("second Flush() = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func TestRelayClaimLease(t *testing.T) {
+	ctx := context.Background()
+	outbox := newFakeOutbox(2)
+	other := newTestRelay(t, outbox, &fakeBroker{})
+
+	// A relay holds its claim for relayLease; other relays skip the batch meanwhile
+	holder := newTestRelay(t, outbox, &fakeBroker{})
+	token, pending, err := holder.claim(ctx)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("claim() = %d events, %v, want 2, nil", len(pending), err)
+	}
+	for _, row := range outbox.rows {
+		if row.claimToken != token {
+			t.Errorf("row %d claimed by %q, want %q", row.id, row.claimToken, token)
+		}
+	}
+	outbox.advance(relayLease - time.Second)
+	if n, err := other.Flush(ctx); err != nil || n != 0 {
+		t.Fatalf("Flush() during the lease = %d, %v, want 0, nil", n, err)
+	}
+
+	// Once the lease expired, another relay takes the events over
+	outbox.advance(2 * time.Second)
+	if n, err := other.Flush(ctx); err != nil || n != 2 {
+		t.Fatalf("Flush() after the lease = %d, %v, want 2, nil", n, err)
+	}
+}
+
+func TestRelayLateRelayKeepsOutcome(t *testing.T) {
+	ctx := context.Background()
+	outbox := newFakeOutbox(2)
+	takeover := &fakeBroker{}
+	other := newTestRelay(t, outbox, takeover)
+
+	// The slow relay's lease expires while it publishes its first event, and
+	// the other relay publishes the whole batch meanwhile
+	var takenOverAt time.Time
+	slow := newTestRelay(t, outbox, &fakeBroker{fail: func(e Event) error {
+		if e.ID == 1 {
+			outbox.advance(relayLease + time.Second)
+			takenOverAt = outbox.clock()
+			if n, err := other.Flush(ctx); err != nil || n != 2 {
+				t.Errorf("takeover Flush() = %d, %v, want 2, nil", n, err)
+			}
+			outbox.advance(time.Second)
+		}
+		return nil
+	}})
+	if _, err := slow.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if want := []int64{1, 2}; !reflect.DeepEqual(takeover.ids(), want) {
+		t.Errorf("takeover published %v, want %v", takeover.ids(), want)
+	}
+	// The slow relay's claim token no longer matches, so it changes nothing
+	for _, row := range outbox.rows {
+		if row.publishedAt == nil || !row.publishedAt.Equal(takenOverAt) || row.claimToken != "" {
+			t.Errorf("row %d = %+v, want published at %v by the takeover and unclaimed", row.id, *row, takenOverAt)
+		}
+	}
+}
+
+func TestRelayRetriesRejectedEvent(t *testing.T) {
+	ctx := context.Background()
+	outbox := newFakeOutbox(3)
+	down := true
+	broker := &fakeBroker{fail: func(e Event) error {
+		if e.ID == 1 && down {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}}
+	relay := newTestRelay(t, outbox, broker)
+
+	if n, err := relay.Flush(ctx); err == nil || n != 0 {
+		t.Fatalf("Flush() = %d, %v, want 0 and an error", n, err)
+	}
+	row := outbox.rows[0]
+	if row.attempts != 1 || row.lastError != "broker unavailable" || row.claimToken != "" || row.failedAt != nil {
+		t.Fatalf("rejected row = %+v, want one attempt, the error and no claim", *row)
+	}
+	if want := outbox.clock().Add(time.Minute); row.claimedUntil == nil || !row.claimedUntil.Equal(want) {
+		t.Fatalf("rejected row held back until %v, want %v", row.claimedUntil, want)
+	}
+
+	// The rejected event waits for its retry without holding back the others
+	if n, err := relay.Flush(ctx); err != nil || n != 2 {
+		t.Fatalf("Flush() = %d, %v, want 2, nil", n, err)
+	}
+	if n, err := relay.Flush(ctx); err != nil || n != 0 {
+		t.Fatalf("Flush() before the retry = %d, %v, want 0, nil", n, err)
+	}
+
+	down = false
+	outbox.advance(time.Minute + time.Second)
+	if n, err := relay.Flush(ctx); err != nil || n != 1 {
+		t.Fatalf("Flush() after the retry delay = %d, %v, want 1, nil", n, err)
+	}
+	if want := []int64{2, 3, 1}; !reflect.DeepEqual(broker.ids(), want) {
+		t.Errorf("published %v, want %v", broker.ids(), want)
+	}
+	if row.publishedAt == nil || row.lastError != "" {
+		t.Errorf("retried row = %+v, want published without an error", *row)
+	}
+}
+
+func TestRelayDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	outbox := newFakeOutbox(1)
+	calls := 0
+	relay := newTestRelay(t, outbox, &fakeBroker{fail: func(e Event) error {
+		calls++
+		return errors.New("payload too large")
+	}})
+
+	for attempt := 1; attempt <= relayMaxAttempts; attempt++ {
+		if _, err := relay.Flush(ctx); err == nil {
+			t.Fatalf("Flush() %d error = nil, want the broker's", attempt)
+		}
+		outbox.advance(retryDelay(attempt) + time.Second)
+	}
+	row := outbox.rows[0]
+	if row.failedAt == nil || row.attempts != relayMaxAttempts || row.publishedAt != nil {
+		t.Fatalf("row = %+v, want dead-lettered after %d attempts", *row, relayMaxAttempts)
+	}
+
+	outbox.advance(24 * time.Hour)
+	if n, err := relay.Flush(ctx); err != nil || n != 0 {
+		t.Errorf("Flush() of a dead-lettered event = %d, %v, want 0, nil", n, err)
+	}
+	if calls != relayMaxAttempts {
+		t.Errorf("broker called %d times, want %d", calls, relayMaxAttempts)
+	}
+}
+
+func TestRelayCleanup(t *testing.T) {
+	outbox := newFakeOutbox(3)
+	outbox.now = time.Now()
+	old, recent := outbox.now.Add(-retention-time.Hour), outbox.now.Add(-time.Hour)
+	outbox.rows[0].publishedAt = &old
+	outbox.rows[1].publishedAt = &recent
+	outbox.rows[2].failedAt = &old // Dead-lettered events stay until an operator deals with them
+
+	if err := newTestRelay(t, outbox, &fakeBroker{}).Cleanup(context.Background()); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	var kept []int64
+	for _, row := range outbox.rows {
+		kept = append(kept, row.id)
+	}
+	if want := []int64{2, 3}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept rows %v, want %v", kept, want)
+	}
+}
+
+func newTestRelay(t *testing.T, outbox *fakeOutbox, broker Broker) *Relay {
+	db := sql.OpenDB(outbox)
+	t.Cleanup(func() { db.Close() })
+	return NewRelay(db, "blogs", broker)
+}
+
+// fakeBroker records the IDs of the events it accepts. fail, when set,
+// decides whether to reject each event.
+type fakeBroker struct {
+	mu        sync.Mutex
+	published []int64
+	fail      func(e Event) error
+}
+
+func (b *fakeBroker) Publish(ctx context.Context, e Event) error {
+	if b.fail != nil {
+		if err := b.fail(e); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, e.ID)
+	return nil
+}
+
+func (b *fakeBroker) Close() error { return nil }
+
+func (b *fakeBroker) ids() []int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.published
+}
+
+// outboxRow is a row of the outbox table.
+type outboxRow struct {
+	id           int64
+	eventType    string
+	payload      string
+	createdAt    time.Time
+	publishedAt  *time.Time
+	attempts     int
+	lastError    string
+	claimToken   string
+	claimedUntil *time.Time
+	failedAt     *time.Time
+}
+
+// fakeOutbox is a database/sql driver holding an outbox table in memory. It
+// answers exactly the statements of Relay, with CURRENT_TIMESTAMP taken from
+// a clock the test advances and FOR UPDATE SKIP LOCKED honoured between
+// transactions; any other statement fails.
+type fakeOutbox struct {
+	mu    sync.Mutex
+	now   time.Time
+	rows  []*outboxRow
+	locks map[int64]*fakeOutboxConn // Rows locked by an open transaction
+}
+
+func newFakeOutbox(events int) *fakeOutbox {
+	o := &fakeOutbox{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), locks: map[int64]*fakeOutboxConn{}}
+	for i := 1; i <= events; i++ {
+		o.rows = append(o.rows, &outboxRow{id: int64(i), eventType: "BlogCreated", payload: fmt.Sprintf(`{"id":%d}`, i), createdAt: o.now})
+	}
+	return o
+}
+
+func (o *fakeOutbox) clock() time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.now
+}
+
+func (o *fakeOutbox) advance(d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.now = o.now.Add(d)
+}
+
+func (o *fakeOutbox) Connect(context.Context) (driver.Conn, error) {
+	return &fakeOutboxConn{outbox: o}, nil
+}
+
+func (o *fakeOutbox) Driver() driver.Driver { return nil }
+
+type fakeOutboxConn struct {
+	outbox *fakeOutbox
+	inTx   bool
+}
+
+func (c *fakeOutboxConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeOutboxStmt{c, strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeOutboxConn) Close() error { return nil }
+
+func (c *fakeOutboxConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+
+func (c *fakeOutboxConn) Commit() error   { return c.end() }
+func (c *fakeOutboxConn) Rollback() error { return c.end() }
+
+// end releases the row locks of the connection's transaction.
+func (c *fakeOutboxConn) end() error {
+	c.outbox.mu.Lock()
+	defer c.outbox.mu.Unlock()
+	for id, owner := range c.outbox.locks {
+		if owner == c {
+			delete(c.outbox.locks, id)
+		}
+	}
+	c.inTx = false
+	return nil
+}
+
+type fakeOutboxStmt struct {
+	conn  *fakeOutboxConn
+	query string
+}
+
+func (s fakeOutboxStmt) Close() error  { return nil }
+func (s fakeOutboxStmt) NumInput() int { return -1 }
+
+func (s fakeOutboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	const claimable = "SELECT id, event_type, payload, created_at, attempts FROM outbox " +
+		"WHERE published_at IS NULL AND failed_at IS NULL AND (claimed_until IS NULL OR claimed_until < CURRENT_TIMESTAMP) " +
+		"ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED"
+	if s.query != claimable {
+		return nil, fmt.Errorf("unexpected query %q", s.query)
+	}
+	o := s.conn.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	rows := &fakeOutboxRows{}
+	for _, row := range o.rows {
+		if int64(len(rows.values)) == args[0].(int64) {
+			break
+		}
+		if row.publishedAt != nil || row.failedAt != nil || (row.claimedUntil != nil && !row.claimedUntil.Before(o.now)) {
+			continue
+		}
+		if owner, ok := o.locks[row.id]; ok && owner != s.conn {
+			continue // SKIP LOCKED
+		}
+		if s.conn.inTx {
+			o.locks[row.id] = s.conn
+		}
+		rows.values = append(rows.values, []driver.Value{row.id, row.eventType, []byte(row.payload), row.createdAt, int64(row.attempts)})
+	}
+	return rows, nil
+}
+
+func (s fakeOutboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	o := s.conn.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.now
+	inIDs := func(row *outboxRow, ids []driver.Value) bool {
+		for _, id := range ids {
+			if id.(int64) == row.id {
+				return true
+			}
+		}
+		return false
+	}
+	seconds := func(v driver.Value) *time.Time {
+		t := now.Add(time.Duration(v.(int64)) * time.Second)
+		return &t
+	}
+
+	var match func(row *outboxRow) bool
+	var update func(row *outboxRow)
+	switch {
+	case strings.HasPrefix(s.query, "UPDATE outbox SET claim_token = ?, claimed_until = CURRENT_TIMESTAMP + INTERVAL ? SECOND WHERE id IN ("):
+		match = func(row *outboxRow) bool { return inIDs(row, args[2:]) }
+		update = func(row *outboxRow) { row.claimToken, row.claimedUntil = args[0].(string), seconds(args[1]) }
+	case strings.HasPrefix(s.query, "UPDATE outbox SET published_at = CURRENT_TIMESTAMP, last_error = NULL, claim_token = NULL, claimed_until = NULL WHERE claim_token = ? AND id IN ("):
+		match = func(row *outboxRow) bool { return row.claimToken == args[0] && inIDs(row, args[1:]) }
+		update = func(row *outboxRow) {
+			row.publishedAt, row.lastError, row.claimToken, row.claimedUntil = &now, "", "", nil
+		}
+	case s.query == "UPDATE outbox SET attempts = attempts + 1, last_error = ?, claim_token = NULL, "+
+		"claimed_until = CURRENT_TIMESTAMP + INTERVAL ? SECOND, failed_at = IF(?, CURRENT_TIMESTAMP, NULL) WHERE claim_token = ? AND id = ?":
+		match = func(row *outboxRow) bool { return row.claimToken == args[3] && row.id == args[4].(int64) }
+		update = func(row *outboxRow) {
+			row.attempts++
+			row.lastError, row.claimToken, row.claimedUntil = args[0].(string), "", seconds(args[1])
+			if args[2].(bool) {
+				row.failedAt = &now
+			}
+		}
+	case s.query == "UPDATE outbox SET claim_token = NULL, claimed_until = NULL WHERE claim_token = ?":
+		match = func(row *outboxRow) bool { return row.claimToken == args[0] }
+		update = func(row *outboxRow) { row.claimToken, row.claimedUntil = "", nil }
+	case s.query == "DELETE FROM outbox WHERE published_at < ?":
+		var kept []*outboxRow
+		for _, row := range o.rows {
+			if row.publishedAt == nil || !row.publishedAt.Before(args[0].(time.Time)) {
+				kept = append(kept, row)
+			}
+		}
+		deleted := len(o.rows) - len(kept)
+		o.rows = kept
+		return driver.RowsAffected(deleted), nil
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", s.query)
+	}
+
+	var n int64
+	for _, row := range o.rows {
+		if match(row) {
+			update(row)
+			n++
+		}
+	}
+	return driver.RowsAffected(n), nil
+}
+
+type fakeOutboxRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeOutboxRows) Columns() []string {
+	return []string{"id", "event_type", "payload", "created_at", "attempts"}
+}
+
+func (r *fakeOutboxRows) Close() error { return nil }
+
+func (r *fakeOutboxRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,7 @@
+module shared
+
+go 1.23.1
+
+require github.com/go-sql-driver/mysql v1.8.1
+
+require filippo.io/edwards25519 v1.1.0 // indirect
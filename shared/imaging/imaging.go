@@ -0,0 +1,56 @@
+// Package imaging scales uploaded images down to thumbnails and variants.
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// Resize scales src to width x height pixels. Each destination pixel is the
+// average of the source pixels it covers, which gives good quality when
+// shrinking photos.
+func Resize(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy0, sy1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < width; x++ {
+			sx0, sx1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// Square returns the centre square of src, without copying its pixels.
+func Square(src image.Image) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	corner := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	return cropped{src, image.Rectangle{Min: corner, Max: corner.Add(image.Pt(side, side))}}
+}
+
+// cropped is an image restricted to part of its bounds.
+type cropped struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+func (c cropped) Bounds() image.Rectangle {
+	return c.bounds
+}
@@ -0,0 +1,70 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// stripes returns an image whose columns alternate between black and white.
+func stripes(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x%2 == 1 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           image.Image
+		width, height int
+		want          uint8 // Gray level of every destination pixel
+	}{
+		{name: "averages pairs of columns", src: stripes(8, 4), width: 4, height: 4, want: 127},
+		{name: "averages blocks", src: stripes(8, 8), width: 2, height: 2, want: 127},
+		{name: "keeps a single column", src: stripes(1, 4), width: 1, height: 2, want: 0},
+		{name: "enlarges by repeating", src: image.NewUniform(color.White), width: 3, height: 3, want: 255},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			if u, ok := src.(*image.Uniform); ok {
+				src = cropped{u, image.Rect(0, 0, 1, 1)}
+			}
+			dst := Resize(src, tt.width, tt.height)
+			if got := dst.Bounds(); got != image.Rect(0, 0, tt.width, tt.height) {
+				t.Fatalf("bounds = %v, want %dx%d", got, tt.width, tt.height)
+			}
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					if got := color.GrayModel.Convert(dst.At(x, y)).(color.Gray).Y; got != tt.want {
+						t.Fatalf("pixel (%d, %d) = %d, want %d", x, y, got, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSquare(t *testing.T) {
+	tests := []struct {
+		bounds image.Rectangle
+		want   image.Rectangle
+	}{
+		{image.Rect(0, 0, 400, 300), image.Rect(50, 0, 350, 300)},
+		{image.Rect(0, 0, 300, 400), image.Rect(0, 50, 300, 350)},
+		{image.Rect(10, 10, 20, 20), image.Rect(10, 10, 20, 20)},
+		{image.Rect(0, 0, 5, 2), image.Rect(1, 0, 3, 2)},
+	}
+	for _, tt := range tests {
+		if got := Square(image.NewRGBA(tt.bounds)).Bounds(); got != tt.want {
+			t.Errorf("Square(%v) bounds = %v, want %v", tt.bounds, got, tt.want)
+		}
+	}
+}
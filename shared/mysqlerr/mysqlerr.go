@@ -0,0 +1,17 @@
+// Package mysqlerr classifies errors returned by the MySQL driver.
+package mysqlerr
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErDupEntry is the MySQL error number for a unique key violation.
+const ErDupEntry = 1062
+
+// IsDuplicateEntry reports whether err is a MySQL unique key violation.
+func IsDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == ErDupEntry
+}
@@ -0,0 +1,35 @@
+// Package storage provides blob storage for uploaded files such as post media and avatars.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when a blob does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys that are empty or try to escape the store.
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore stores opaque blobs under slash-separated keys such as
+// "media/12/800.jpg". Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores the content of r under key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get opens the blob stored under key. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn with the key of every stored blob, in lexical order.
+	Walk(ctx context.Context, fn func(key string) error) error
+}
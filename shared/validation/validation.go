@@ -0,0 +1,45 @@
+// Package validation reports invalid request fields the same way in every
+// service.
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors maps request field names to a description of what is wrong with them.
+type Errors map[string]string
+
+// Error implements the error interface.
+func (v Errors) Error() string {
+	fields := make([]string, 0, len(v))
+	for field, msg := range v {
+		fields = append(fields, field+": "+msg)
+	}
+	return "validation failed: " + strings.Join(fields, "; ")
+}
+
+// Write responds with 400 and the field-level errors as JSON.
+func Write(w http.ResponseWriter, errs Errors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Validation failed",
+		"fields": errs,
+	})
+}
+
+// AtMost describes a value longer than max units, such as characters or bytes.
+func AtMost(max int, unit string) string {
+	return fmt.Sprintf("must be at most %d %s", max, unit)
+}
+
+// Between describes a value outside the range min to max, in units if given.
+func Between(min, max int, unit string) string {
+	if unit == "" {
+		return fmt.Sprintf("must be between %d and %d", min, max)
+	}
+	return fmt.Sprintf("must be between %d and %d %s", min, max, unit)
+}
@@ -0,0 +1,47 @@
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{AtMost(255, "characters"), "must be at most 255 characters"},
+		{AtMost(65535, "bytes"), "must be at most 65535 bytes"},
+		{Between(3, 50, "characters"), "must be between 3 and 50 characters"},
+		{Between(1, 365, ""), "must be between 1 and 365"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, Errors{"title": "is required"})
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "Validation failed" || !reflect.DeepEqual(body.Fields, map[string]string{"title": "is required"}) {
+		t.Errorf("body = %+v", body)
+	}
+}
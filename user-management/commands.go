@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"shared/backup"
+)
+
+// runCommand runs a maintenance subcommand against the prepared database and
+// returns the process exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "backup":
+		return backup.RunBackup(serviceName, db, store, schemaVersion, args)
+	case "restore":
+		return backup.RunRestore(serviceName, db, store, schemaVersion, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q; available: backup, restore\n", name)
+		return 2
+	}
+}
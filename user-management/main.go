@@ -1,18 +1,37 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 	_ "user-management/docs" // For Swagger documentation
 	"user-management/user"
 
+	"shared/events"
+	"shared/storage"
+
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
 var db *sql.DB
 
+// store keeps uploaded files; backups include it.
+var store storage.BlobStore
+
+// schemaVersion identifies the tables and columns created at startup. Bump it
+// whenever the schema or columns below change; backups are only restored into
+// a database at the same schema version.
+const schemaVersion = 4
+
+// serviceName names the database in backups.
+const serviceName = "user_management"
+
 // @title User Management API
 // @version 1.0
 // @description This is a simple User Management API for handling user registration, login, and RBAC.
@@ -21,7 +40,7 @@ var db *sql.DB
 
 func main() {
 	// MySQL connection string
-	dsn := "root:@tcp(127.0.0.1:3306)/user_management" // Replace with your MySQL credentials
+	dsn := "root:@tcp(127.0.0.1:3306)/user_management?parseTime=true" // Replace with your MySQL credentials
 	var err error
 	db, err = sql.Open("mysql", dsn)
 	if err != nil {
@@ -34,26 +53,126 @@ func main() {
 		log.Fatalf("Failed to ping the database: %v", err)
 	}
 
-	// Create the users table if it doesn't exist
-	createTableQuery := `
+	// Create the tables if they don't exist
+	schema := []string{`
     CREATE TABLE IF NOT EXISTS users (
         id INT AUTO_INCREMENT PRIMARY KEY,
         username VARCHAR(50) NOT NULL UNIQUE,
         password TEXT NOT NULL,
         full_name VARCHAR(100) NOT NULL,
         bio TEXT DEFAULT '',
-        role ENUM('Writer', 'Admin') DEFAULT 'Writer'
-    );`
-	_, err = db.Exec(createTableQuery)
-	if err != nil {
-		log.Fatalf("Failed to create users table: %v", err)
+        role ENUM('Writer', 'Admin') DEFAULT 'Writer',
+        avatar_id VARCHAR(32) NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        deletion_requested_at TIMESTAMP NULL
+    );`, `
+    CREATE TABLE IF NOT EXISTS personal_access_tokens (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        user_id INT NOT NULL,
+        name VARCHAR(100) NOT NULL,
+        token_hash CHAR(64) NOT NULL UNIQUE,
+        token_prefix VARCHAR(16) NOT NULL,
+        scopes VARCHAR(255) NOT NULL,
+        expires_at TIMESTAMP NULL,
+        last_used_at TIMESTAMP NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS follows (
+        follower_id INT NOT NULL,
+        followee_id INT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (follower_id, followee_id),
+        INDEX idx_follows_followee (followee_id),
+        FOREIGN KEY (follower_id) REFERENCES users(id) ON DELETE CASCADE,
+        FOREIGN KEY (followee_id) REFERENCES users(id) ON DELETE CASCADE
+    );`, `
+    CREATE TABLE IF NOT EXISTS outbox (
+        id BIGINT AUTO_INCREMENT PRIMARY KEY,
+        event_type VARCHAR(64) NOT NULL,
+        payload MEDIUMTEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        published_at TIMESTAMP NULL,
+        attempts INT NOT NULL DEFAULT 0,
+        last_error TEXT NULL,
+        claim_token CHAR(32) NULL,
+        claimed_until TIMESTAMP NULL,
+        failed_at TIMESTAMP NULL,
+        INDEX idx_outbox_pending (published_at, id)
+    );`}
+	for _, createTableQuery := range schema {
+		if _, err = db.Exec(createTableQuery); err != nil {
+			log.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+
+	// Add columns introduced after a table was first created. A backfill query
+	// runs once, right after its column was added.
+	columns := []struct{ table, column, definition, backfill string }{
+		{"users", "deletion_requested_at", "TIMESTAMP NULL", ""},
+		// Existing users would otherwise appear to have joined when the column
+		// was added; use the earliest trace they left instead
+		{"users", "created_at", "TIMESTAMP DEFAULT CURRENT_TIMESTAMP", `
+            UPDATE users u SET u.created_at = LEAST(u.created_at,
+                COALESCE((SELECT MIN(t.created_at) FROM personal_access_tokens t WHERE t.user_id = u.id), u.created_at),
+                COALESCE((SELECT MIN(f.created_at) FROM follows f WHERE f.follower_id = u.id OR f.followee_id = u.id), u.created_at),
+                COALESCE(u.deletion_requested_at, u.created_at))`},
+		{"users", "avatar_id", "VARCHAR(32) NULL", ""},
+		{"outbox", "claim_token", "CHAR(32) NULL", ""},
+		{"outbox", "claimed_until", "TIMESTAMP NULL", ""},
+		{"outbox", "failed_at", "TIMESTAMP NULL", ""},
+	}
+	for _, c := range columns {
+		added, err := addColumnIfMissing(c.table, c.column, c.definition)
+		if err != nil {
+			log.Fatalf("Failed to add column %s.%s: %v", c.table, c.column, err)
+		}
+		if added && c.backfill != "" {
+			if _, err := db.Exec(c.backfill); err != nil {
+				log.Fatalf("Failed to backfill column %s.%s: %v", c.table, c.column, err)
+			}
+		}
 	}
 
-	log.Println("Connected to the MySQL database and ensured users table exists")
+	log.Println("Connected to the MySQL database and ensured tables exist")
 
 	// Inject the DB connection into the user package
 	user.SetDB(db)
 
+	// Uploaded files are kept on the local disk below BLOB_STORAGE_DIR
+	blobDir := os.Getenv("BLOB_STORAGE_DIR")
+	if blobDir == "" {
+		blobDir = "data/blobs"
+	}
+	if store, err = storage.NewLocalStore(blobDir); err != nil {
+		log.Fatalf("Failed to open blob storage: %v", err)
+	}
+	user.SetBlobStore(store)
+
+	if url := os.Getenv("BLOG_SERVICE_URL"); url != "" {
+		user.SetBlogServiceURL(url)
+	}
+	if err = configureAccountDeletion(); err != nil {
+		log.Fatalf("Invalid account deletion settings: %v", err)
+	}
+
+	// Subcommands such as backup and restore run against the prepared database and exit
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1], os.Args[2:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	user.StartDeletionWorker(context.Background(), time.Hour)
+
+	// Domain events are relayed from the outbox to EVENT_BROKER, in-process by default
+	broker, err := events.NewBroker(os.Getenv("EVENT_BROKER"))
+	if err != nil {
+		log.Fatalf("Invalid event broker: %v", err)
+	}
+	defer broker.Close()
+	events.NewRelay(db, "users", broker).Start(context.Background(), time.Second)
+
 	// Routes
 	http.HandleFunc("/register", user.RegisterUser)
 	http.HandleFunc("/login", user.LoginUser)
@@ -62,15 +181,50 @@ func main() {
 	http.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			user.ProtectedRoute(user.GetProfile)(w, r)
+			user.ProtectedRoute(user.RequireScope(user.ScopeProfileRead, user.GetProfile))(w, r)
+		case http.MethodPut:
+			user.ProtectedRoute(user.RequireScope(user.ScopeProfileWrite, user.UpdateProfile))(w, r)
+		case http.MethodDelete:
+			user.ProtectedRoute(user.DeleteProfile)(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	http.HandleFunc("/profile/avatar", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
 		case http.MethodPut:
-			user.ProtectedRoute(user.UpdateProfile)(w, r)
+			user.ProtectedRoute(user.RequireScope(user.ScopeProfileWrite, user.UploadAvatar))(w, r)
+		case http.MethodDelete:
+			user.ProtectedRoute(user.RequireScope(user.ScopeProfileWrite, user.DeleteAvatar))(w, r)
 		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
+	http.HandleFunc("/profile/export", user.ProtectedRoute(user.ExportProfile))
+	http.HandleFunc("/profile/restore", user.ProtectedRoute(user.RestoreProfile))
 
-	http.HandleFunc("/admin", user.ProtectedRoute(AdminOnly)) // Protected admin route
+	// Personal access tokens for automation
+	http.HandleFunc("/profile/tokens", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			user.ProtectedRoute(user.GetTokens)(w, r)
+		case http.MethodPost:
+			user.ProtectedRoute(user.CreateToken)(w, r)
+		case http.MethodDelete:
+			user.ProtectedRoute(user.RevokeToken)(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	http.HandleFunc("GET /users/{username}", user.GetPublicProfile) // Public author profile
+	http.HandleFunc("GET /users/{username}/following", user.GetFollowingHandler)
+	http.HandleFunc("GET /users/{username}/followers", user.GetFollowersHandler)
+	http.HandleFunc("PUT /users/{username}/follow", user.ProtectedRoute(user.RequireScope(user.ScopeProfileWrite, user.FollowHandler)))
+	http.HandleFunc("DELETE /users/{username}/follow", user.ProtectedRoute(user.RequireScope(user.ScopeProfileWrite, user.UnfollowHandler)))
+	http.HandleFunc("GET /avatars/{id}/{file}", user.ServeAvatar)
+	http.HandleFunc("/tokens/introspect", user.ProtectedRoute(user.IntrospectToken))
+
+	http.HandleFunc("/admin", user.ProtectedRoute(user.RequireScope(user.ScopeAdmin, AdminOnly))) // Protected admin route
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("User Management Service is running"))
@@ -100,3 +254,37 @@ func AdminOnly(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Welcome, Admin!"))
 }
+
+// addColumnIfMissing adds a column to an existing table unless it is already
+// there. It reports whether the column was added.
+func addColumnIfMissing(table, column, definition string) (bool, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`
+	if err := db.QueryRow(query, table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err == nil, err
+}
+
+// configureAccountDeletion reads the account deletion settings from the environment:
+// ACCOUNT_DELETION_GRACE_DAYS (default 30) and DELETED_USER_POSTS_POLICY
+// ("anonymize", the default, or "delete").
+func configureAccountDeletion() error {
+	graceDays := 30
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return errors.New("ACCOUNT_DELETION_GRACE_DAYS must be a non-negative number of days")
+		}
+		graceDays = days
+	}
+	policy := os.Getenv("DELETED_USER_POSTS_POLICY")
+	if policy == "" {
+		policy = user.PostsPolicyAnonymize
+	}
+	return user.SetAccountDeletionPolicy(time.Duration(graceDays)*24*time.Hour, policy)
+}
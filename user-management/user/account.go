@@ -0,0 +1,227 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// What happens to a deleted user's blog posts.
+const (
+	PostsPolicyAnonymize = "anonymize" // Keep the posts but attribute them to a placeholder author
+	PostsPolicyDelete    = "delete"    // Delete the posts together with the account
+)
+
+// Account deletion settings, see SetAccountDeletionPolicy.
+var (
+	deletionGracePeriod = 30 * 24 * time.Hour
+	deletedPostsPolicy  = PostsPolicyAnonymize
+)
+
+// blogServiceURL is the base URL of the blog service.
+var blogServiceURL = "http://localhost:8001"
+
+var blogServiceClient = &http.Client{Timeout: 10 * time.Second}
+
+// SetBlogServiceURL sets the base URL of the blog service.
+func SetBlogServiceURL(url string) {
+	blogServiceURL = strings.TrimSuffix(url, "/")
+}
+
+// SetAccountDeletionPolicy configures how long deleted accounts can still be
+// restored and what happens to their blog posts once they are erased.
+func SetAccountDeletionPolicy(gracePeriod time.Duration, postsPolicy string) error {
+	if postsPolicy != PostsPolicyAnonymize && postsPolicy != PostsPolicyDelete {
+		return fmt.Errorf("unknown posts policy %q", postsPolicy)
+	}
+	deletionGracePeriod = gracePeriod
+	deletedPostsPolicy = postsPolicy
+	return nil
+}
+
+// ScheduleDeletion marks the user's account for deletion once the grace period
+// has passed and returns the time it will be erased.
+func ScheduleDeletion(username string) (time.Time, error) {
+	now := time.Now()
+	_, err := db.Exec(`UPDATE users SET deletion_requested_at = ? WHERE username = ?`, now, username)
+	return now.Add(deletionGracePeriod), err
+}
+
+// CancelDeletion clears a pending deletion request. It reports whether one was pending.
+func CancelDeletion(username string) (bool, error) {
+	res, err := db.Exec(`UPDATE users SET deletion_requested_at = NULL WHERE username = ? AND deletion_requested_at IS NOT NULL`, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// StartDeletionWorker periodically erases accounts whose grace period has expired.
+func StartDeletionWorker(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := PurgeDeletedAccounts(ctx); err != nil {
+				log.Printf("Failed to purge deleted accounts: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// PurgeDeletedAccounts erases every account whose deletion grace period has expired.
+// The user's blog posts are handled first so a failure leaves the account in place
+// to be retried later.
+func PurgeDeletedAccounts(ctx context.Context) error {
+	cutoff := time.Now().Add(-deletionGracePeriod)
+	rows, err := db.QueryContext(ctx, `SELECT username FROM users WHERE deletion_requested_at IS NOT NULL AND deletion_requested_at <= ?`, cutoff)
+	if err != nil {
+		return err
+	}
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			rows.Close()
+			return err
+		}
+		usernames = append(usernames, username)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, username := range usernames {
+		if err := removeAuthorBlogs(ctx, username); err != nil {
+			log.Printf("Failed to remove blogs of %s, will retry: %v", username, err)
+			continue
+		}
+		if avatarID, err := GetAvatarID(username); err == nil && avatarID != "" {
+			if err := DeleteAvatarFiles(ctx, avatarID); err != nil {
+				log.Printf("Failed to delete avatar of %s: %v", username, err)
+			}
+		}
+		erased, err := deleteAccount(ctx, username)
+		if err != nil {
+			return err
+		}
+		if erased {
+			log.Printf("Account erased: %s", username)
+		}
+	}
+	return nil
+}
+
+// deleteAccount removes a user whose deletion is still requested and records
+// a UserDeleted event. It reports false if the deletion was cancelled.
+func deleteAccount(ctx context.Context, username string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var event UserEvent
+	err = tx.QueryRowContext(ctx, `SELECT id, username, role FROM users WHERE username = ? AND deletion_requested_at IS NOT NULL FOR UPDATE`, username).
+		Scan(&event.ID, &event.Username, &event.Role)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, event.ID); err != nil {
+		return false, err
+	}
+	if err := recordUserEvent(tx, EventUserDeleted, event); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// fetchBlogExport returns the raw JSON the blog service answers for one of its
+// export endpoints, such as the caller's posts or activity, authenticating with
+// the caller's own token.
+func fetchBlogExport(ctx context.Context, authHeader, path string) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, blogServiceURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := blogServiceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("blog service returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(body), nil
+}
+
+// removeAuthorBlogs asks the blog service to anonymise or delete a user's posts.
+func removeAuthorBlogs(ctx context.Context, username string) error {
+	token, err := generateServiceJWT()
+	if err != nil {
+		return err
+	}
+	query := url.Values{"username": {username}, "policy": {deletedPostsPolicy}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, blogServiceURL+"/admin/authors/blogs?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := blogServiceClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("blog service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// generateServiceJWT issues a short-lived Admin token the service uses to call
+// the blog service on its own behalf.
+func generateServiceJWT() (string, error) {
+	claims := &JWTClaims{
+		Username: "user-management",
+		Role:     "Admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+}
+
+// getDeletionRequestedAt returns when the user asked for their account to be deleted, or nil.
+func getDeletionRequestedAt(username string) (*time.Time, error) {
+	var requestedAt sql.NullTime
+	err := db.QueryRow(`SELECT deletion_requested_at FROM users WHERE username = ?`, username).Scan(&requestedAt)
+	if err != nil {
+		return nil, err
+	}
+	return nullTimePtr(requestedAt), nil
+}
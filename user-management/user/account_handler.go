@@ -0,0 +1,185 @@
+package user
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AccountExport is the profile part of a data export. Login sessions are
+// signed tokens that are not stored, so there is no session or login history
+// to include; the last use of each personal access token is.
+type AccountExport struct {
+	Profile             User                  `json:"profile"`
+	JoinedAt            time.Time             `json:"joined_at"`
+	DeletionRequestedAt *time.Time            `json:"deletion_requested_at"`
+	AccessTokens        []PersonalAccessToken `json:"access_tokens"`
+	Following           []Follow              `json:"following"`
+	Followers           []Follow              `json:"followers"`
+	ExportedAt          time.Time             `json:"exported_at"`
+}
+
+// ExportProfile streams a ZIP archive of everything stored about the logged-in user.
+// @Summary Export account data
+// @Description Download a ZIP archive of the authenticated user's data: profile.json holds the profile, personal access tokens and follows,
+// @Description blogs.json their blog posts and activity.json their comments, reactions, bookmarks, reading lists, collaborations, series and uploads.
+// @Description Login sessions are not stored, so there is no login history to export.
+// @Tags Profile
+// @Produce  application/zip
+// @Success 200 {file} file
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /profile/export [get]
+func ExportProfile(w http.ResponseWriter, r *http.Request) {
+	claims, userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	export := AccountExport{ExportedAt: time.Now()}
+	query := `SELECT id, username, full_name, bio, role, COALESCE(avatar_id, ''), created_at FROM users WHERE id = ?`
+	err := db.QueryRow(query, userID).Scan(&export.Profile.ID, &export.Profile.Username, &export.Profile.FullName, &export.Profile.Bio, &export.Profile.Role, &export.Profile.AvatarID, &export.JoinedAt)
+	if err != nil {
+		log.Printf("Failed to export profile: %v", err)
+		http.Error(w, "Failed to export profile", http.StatusInternalServerError)
+		return
+	}
+	export.Profile.AvatarURLs = AvatarURLs(export.Profile.AvatarID)
+	if export.DeletionRequestedAt, err = getDeletionRequestedAt(claims.Username); err != nil {
+		log.Printf("Failed to export profile: %v", err)
+		http.Error(w, "Failed to export profile", http.StatusInternalServerError)
+		return
+	}
+	if export.AccessTokens, err = GetTokensForUser(userID); err != nil {
+		log.Printf("Failed to export tokens: %v", err)
+		http.Error(w, "Failed to export profile", http.StatusInternalServerError)
+		return
+	}
+	if export.Following, err = GetFollowing(claims.Username); err == nil {
+		export.Followers, err = GetFollowers(claims.Username)
+	}
+	if err != nil {
+		log.Printf("Failed to export follows: %v", err)
+		http.Error(w, "Failed to export profile", http.StatusInternalServerError)
+		return
+	}
+
+	// Fetch from the blog service before writing anything so an outage is reported properly
+	blogs, err := fetchBlogExport(r.Context(), r.Header.Get("Authorization"), "/blogs/export")
+	if err != nil {
+		log.Printf("Failed to fetch blogs for export: %v", err)
+		http.Error(w, "Failed to fetch blog posts", http.StatusBadGateway)
+		return
+	}
+	activity, err := fetchBlogExport(r.Context(), r.Header.Get("Authorization"), "/blogs/activity")
+	if err != nil {
+		log.Printf("Failed to fetch blog activity for export: %v", err)
+		http.Error(w, "Failed to fetch blog activity", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+claims.Username+`-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export},
+		{"blogs.json", blogs},
+		{"activity.json", activity},
+	}
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			log.Printf("Failed to write export: %v", err)
+			return
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			log.Printf("Failed to write export: %v", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write export: %v", err)
+		return
+	}
+
+	log.Printf("Account data exported for user: %s", claims.Username)
+}
+
+// DeleteProfile schedules the logged-in user's account for deletion.
+// @Summary Delete account
+// @Description Schedule the authenticated user's account for deletion. It can be restored until the grace period ends, after which the account is erased and its blog posts are anonymised or deleted.
+// @Tags Profile
+// @Produce  json
+// @Success 202 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile [delete]
+func DeleteProfile(w http.ResponseWriter, r *http.Request) {
+	claims, _, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	erasedAt, err := ScheduleDeletion(claims.Username)
+	if err != nil {
+		log.Printf("Failed to schedule account deletion: %v", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Account deletion scheduled for user: %s", claims.Username)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":   "Account scheduled for deletion",
+		"erased_at": erasedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// RestoreProfile cancels a pending account deletion.
+// @Summary Restore account
+// @Description Cancel a pending deletion of the authenticated user's account
+// @Tags Profile
+// @Produce  json
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile/restore [post]
+func RestoreProfile(w http.ResponseWriter, r *http.Request) {
+	claims, _, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	restored, err := CancelDeletion(claims.Username)
+	if err != nil {
+		log.Printf("Failed to cancel account deletion: %v", err)
+		http.Error(w, "Failed to restore account", http.StatusInternalServerError)
+		return
+	}
+	if !restored {
+		http.Error(w, "Account is not scheduled for deletion", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Account deletion cancelled for user: %s", claims.Username)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Account restored successfully",
+	})
+}
@@ -0,0 +1,136 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register GIF decoding for avatar uploads
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"shared/imaging"
+	"shared/storage"
+)
+
+// Avatar upload limits.
+const (
+	maxAvatarBytes     = 5 << 20 // 5 MB
+	maxAvatarDimension = 4096    // Reject larger images before decoding them
+)
+
+// AvatarSizes are the square thumbnail sizes, in pixels, generated for every
+// avatar, largest first.
+var AvatarSizes = []int{256, 128, 64}
+
+// allowedAvatarTypes are the sniffed content types accepted for avatar uploads.
+var allowedAvatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Errors returned for unacceptable avatar uploads.
+var (
+	ErrAvatarType = errors.New("avatar must be a JPEG, PNG or GIF image")
+	ErrAvatarSize = errors.New("avatar dimensions must be at most 4096x4096 pixels")
+)
+
+var blobStore storage.BlobStore
+
+// SetBlobStore sets the store used for uploaded files.
+func SetBlobStore(store storage.BlobStore) {
+	blobStore = store
+}
+
+// avatarKey returns the blob key of one thumbnail of an avatar.
+func avatarKey(avatarID string, size int) string {
+	return fmt.Sprintf("avatars/%s/%d.png", avatarID, size)
+}
+
+// AvatarURLs returns the URLs of every thumbnail of an avatar keyed by size, or
+// nil if the user has no avatar.
+func AvatarURLs(avatarID string) map[string]string {
+	if avatarID == "" {
+		return nil
+	}
+	urls := make(map[string]string, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		urls[strconv.Itoa(size)] = fmt.Sprintf("/avatars/%s/%d.png", avatarID, size)
+	}
+	return urls
+}
+
+// SaveAvatar validates an uploaded image, stores its thumbnails and returns the
+// new avatar ID. Every upload gets a fresh ID so thumbnail URLs never change
+// content and can be cached indefinitely.
+func SaveAvatar(ctx context.Context, data []byte) (string, error) {
+	if !allowedAvatarTypes[http.DetectContentType(data)] {
+		return "", ErrAvatarType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrAvatarType
+	}
+	if cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		return "", ErrAvatarSize
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrAvatarType
+	}
+
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	avatarID := hex.EncodeToString(idBytes)
+
+	// Each size is scaled down from the previous one to keep large uploads cheap
+	thumb := imaging.Square(img)
+	for _, size := range AvatarSizes {
+		thumb = imaging.Resize(thumb, size, size)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, thumb); err != nil {
+			return "", err
+		}
+		if err := blobStore.Put(ctx, avatarKey(avatarID, size), &buf, "image/png"); err != nil {
+			DeleteAvatarFiles(ctx, avatarID)
+			return "", err
+		}
+	}
+	return avatarID, nil
+}
+
+// DeleteAvatarFiles removes every thumbnail of an avatar from the blob store.
+func DeleteAvatarFiles(ctx context.Context, avatarID string) error {
+	var firstErr error
+	for _, size := range AvatarSizes {
+		if err := blobStore.Delete(ctx, avatarKey(avatarID, size)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// GetAvatarID returns the current avatar ID of a user, or "" if they have none.
+func GetAvatarID(username string) (string, error) {
+	var avatarID string
+	err := db.QueryRow(`SELECT COALESCE(avatar_id, '') FROM users WHERE username = ?`, username).Scan(&avatarID)
+	return avatarID, err
+}
+
+// SetAvatarID stores a user's avatar ID; an empty ID removes the avatar.
+func SetAvatarID(username, avatarID string) error {
+	var value interface{}
+	if avatarID != "" {
+		value = avatarID
+	}
+	_, err := db.Exec(`UPDATE users SET avatar_id = ? WHERE username = ?`, value, username)
+	return err
+}
@@ -0,0 +1,197 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"shared/storage"
+)
+
+// UploadAvatar replaces the logged-in user's avatar.
+// @Summary Upload avatar
+// @Description Upload a JPEG, PNG or GIF image of at most 5 MB as the authenticated user's avatar. It is cropped to a square and resized to 256, 128 and 64 pixel thumbnails.
+// @Tags Profile
+// @Accept  multipart/form-data
+// @Produce  json
+// @Param   avatar  formData  file  true  "Avatar image"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile/avatar [put]
+func UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Leave room for the multipart headers around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64<<10)
+	file, _, err := r.FormFile("avatar")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Avatar must be at most 5 MB", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "Missing avatar file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	if n, err := io.Copy(&buf, io.LimitReader(file, maxAvatarBytes+1)); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	} else if n > maxAvatarBytes {
+		http.Error(w, "Avatar must be at most 5 MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	avatarID, err := SaveAvatar(r.Context(), buf.Bytes())
+	if errors.Is(err, ErrAvatarType) || errors.Is(err, ErrAvatarSize) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		log.Printf("Failed to store avatar: %v", err)
+		http.Error(w, "Failed to store avatar", http.StatusInternalServerError)
+		return
+	}
+
+	oldAvatarID, err := GetAvatarID(claims.Username)
+	if err == nil {
+		err = SetAvatarID(claims.Username, avatarID)
+	}
+	if err != nil {
+		log.Printf("Failed to update avatar: %v", err)
+		DeleteAvatarFiles(r.Context(), avatarID)
+		http.Error(w, "Failed to update avatar", http.StatusInternalServerError)
+		return
+	}
+	if oldAvatarID != "" {
+		if err := DeleteAvatarFiles(r.Context(), oldAvatarID); err != nil {
+			log.Printf("Failed to delete old avatar %s: %v", oldAvatarID, err)
+		}
+	}
+
+	log.Printf("Avatar updated for user: %s", claims.Username)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Avatar updated successfully",
+		"avatar_urls": AvatarURLs(avatarID),
+	})
+}
+
+// DeleteAvatar removes the logged-in user's avatar.
+// @Summary Delete avatar
+// @Description Remove the authenticated user's avatar
+// @Tags Profile
+// @Produce  json
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile/avatar [delete]
+func DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	avatarID, err := GetAvatarID(claims.Username)
+	if err == nil {
+		err = SetAvatarID(claims.Username, "")
+	}
+	if err != nil {
+		log.Printf("Failed to delete avatar: %v", err)
+		http.Error(w, "Failed to delete avatar", http.StatusInternalServerError)
+		return
+	}
+	if avatarID != "" {
+		if err := DeleteAvatarFiles(r.Context(), avatarID); err != nil {
+			log.Printf("Failed to delete avatar files %s: %v", avatarID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Avatar deleted successfully",
+	})
+}
+
+// ServeAvatar serves one avatar thumbnail. Avatar URLs change whenever the
+// avatar does, so responses may be cached forever.
+// @Summary Get avatar image
+// @Description Serve an avatar thumbnail; the URLs are listed in profile responses
+// @Tags Profile
+// @Produce  image/png
+// @Param   id    path  string  true  "Avatar ID"
+// @Param   file  path  string  true  "Thumbnail file, e.g. 128.png"
+// @Success 200 {file} file
+// @Success 304 {string} string "Not Modified"
+// @Failure 404 {string} string "Avatar not found"
+// @Router /avatars/{id}/{file} [get]
+func ServeAvatar(w http.ResponseWriter, r *http.Request) {
+	avatarID := r.PathValue("id")
+	size, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("file"), ".png"))
+	if err != nil || !strings.HasSuffix(r.PathValue("file"), ".png") || !isAvatarSize(size) || !isHex(avatarID) {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	etag := `"` + avatarID + "-" + strconv.Itoa(size) + `"`
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, info, err := blobStore.Get(r.Context(), avatarKey(avatarID, size))
+	if errors.Is(err, storage.ErrNotFound) {
+		w.Header().Del("Cache-Control")
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to read avatar: %v", err)
+		w.Header().Del("Cache-Control")
+		http.Error(w, "Failed to read avatar", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+func isAvatarSize(size int) bool {
+	for _, s := range AvatarSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
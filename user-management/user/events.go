@@ -0,0 +1,28 @@
+package user
+
+import (
+	"database/sql"
+	"time"
+
+	"shared/events"
+)
+
+// User event types.
+const (
+	EventUserRegistered = "UserRegistered"
+	EventUserDeleted    = "UserDeleted"
+)
+
+// UserEvent is the data of user events.
+type UserEvent struct {
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	FullName  string     `json:"full_name,omitempty"`
+	Role      string     `json:"role,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// recordUserEvent records an event about a user in tx.
+func recordUserEvent(tx *sql.Tx, eventType string, data UserEvent) error {
+	return events.Record(tx, eventType, data)
+}
@@ -0,0 +1,218 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxFollowing is the most writers a user may follow.
+const maxFollowing = 5000
+
+// Errors returned when following a writer.
+var (
+	ErrFollowSelf   = errors.New("users cannot follow themselves")
+	ErrFollowLimit  = errors.New("following limit reached")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// Follow is one user a user follows or is followed by.
+type Follow struct {
+	Username string    `json:"username"`
+	Since    time.Time `json:"since"`
+}
+
+// activeUserID returns the ID of a user who has not asked to be deleted.
+func activeUserID(username string) (int, error) {
+	var id int
+	err := db.QueryRow(`SELECT id FROM users WHERE username = ? AND deletion_requested_at IS NULL`, username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	return id, err
+}
+
+// FollowUser makes follower follow followee. Following someone twice changes nothing.
+func FollowUser(follower, followee string) error {
+	if follower == followee {
+		return ErrFollowSelf
+	}
+	followerID, err := activeUserID(follower)
+	if err != nil {
+		return err
+	}
+	followeeID, err := activeUserID(followee)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM follows WHERE follower_id = ?`, followerID).Scan(&count); err != nil {
+		return err
+	}
+	if count >= maxFollowing {
+		return ErrFollowLimit
+	}
+	_, err = db.Exec(`INSERT IGNORE INTO follows (follower_id, followee_id) VALUES (?, ?)`, followerID, followeeID)
+	return err
+}
+
+// UnfollowUser stops follower from following followee.
+func UnfollowUser(follower, followee string) error {
+	_, err := db.Exec(`DELETE f FROM follows f
+		JOIN users a ON a.id = f.follower_id JOIN users b ON b.id = f.followee_id
+		WHERE a.username = ? AND b.username = ?`, follower, followee)
+	return err
+}
+
+// GetFollowing lists the users username follows, most recent first. Users
+// pending deletion are left out.
+func GetFollowing(username string) ([]Follow, error) {
+	return queryFollows(`SELECT b.username, f.created_at FROM follows f
+		JOIN users a ON a.id = f.follower_id JOIN users b ON b.id = f.followee_id
+		WHERE a.username = ? AND b.deletion_requested_at IS NULL ORDER BY f.created_at DESC, b.username`, username)
+}
+
+// GetFollowers lists the users following username, most recent first.
+func GetFollowers(username string) ([]Follow, error) {
+	return queryFollows(`SELECT a.username, f.created_at FROM follows f
+		JOIN users a ON a.id = f.follower_id JOIN users b ON b.id = f.followee_id
+		WHERE b.username = ? AND a.deletion_requested_at IS NULL ORDER BY f.created_at DESC, a.username`, username)
+}
+
+func queryFollows(query, username string) ([]Follow, error) {
+	rows, err := db.Query(query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []Follow{}
+	for rows.Next() {
+		var f Follow
+		if err := rows.Scan(&f.Username, &f.Since); err != nil {
+			return nil, err
+		}
+		follows = append(follows, f)
+	}
+	return follows, rows.Err()
+}
+
+// FollowHandler makes the logged-in user follow a writer.
+// @Summary Follow a user
+// @Description Follow a writer; their posts then appear in the blog service's home feed. Following someone twice changes nothing.
+// @Tags Follows
+// @Produce  json
+// @Param   username  path  string  true  "Username to follow"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /users/{username}/follow [put]
+func FollowHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch err := FollowUser(claims.Username, r.PathValue("username")); err {
+	case nil:
+	case ErrFollowSelf:
+		http.Error(w, "You cannot follow yourself", http.StatusBadRequest)
+		return
+	case ErrUserNotFound:
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case ErrFollowLimit:
+		http.Error(w, "You follow too many users", http.StatusConflict)
+		return
+	default:
+		log.Printf("Failed to follow user: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Following " + r.PathValue("username"),
+	})
+}
+
+// UnfollowHandler makes the logged-in user stop following a writer.
+// @Summary Unfollow a user
+// @Description Stop following a writer
+// @Tags Follows
+// @Produce  json
+// @Param   username  path  string  true  "Username to unfollow"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /users/{username}/follow [delete]
+func UnfollowHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := UnfollowUser(claims.Username, r.PathValue("username")); err != nil {
+		log.Printf("Failed to unfollow user: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "No longer following " + r.PathValue("username"),
+	})
+}
+
+// GetFollowingHandler lists who a user follows.
+// @Summary List followed users
+// @Description List the users someone follows, most recent first. Used by the blog service to build home feeds.
+// @Tags Follows
+// @Produce  json
+// @Param   username  path  string  true  "Username"
+// @Success 200 {array} Follow
+// @Failure 404 {object} map[string]string
+// @Router /users/{username}/following [get]
+func GetFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	writeFollows(w, r, GetFollowing)
+}
+
+// GetFollowersHandler lists who follows a user.
+// @Summary List followers
+// @Description List the users following someone, most recent first
+// @Tags Follows
+// @Produce  json
+// @Param   username  path  string  true  "Username"
+// @Success 200 {array} Follow
+// @Failure 404 {object} map[string]string
+// @Router /users/{username}/followers [get]
+func GetFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	writeFollows(w, r, GetFollowers)
+}
+
+func writeFollows(w http.ResponseWriter, r *http.Request, list func(string) ([]Follow, error)) {
+	username := r.PathValue("username")
+	if _, err := activeUserID(username); err == ErrUserNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	follows, err := list(username)
+	if err != nil {
+		log.Printf("Failed to list follows: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(follows)
+}
@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"strings"
 
+	"shared/mysqlerr"
+	"shared/validation"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -68,6 +71,7 @@ type ProfileRequest struct {
 // @Param   user  body  RegistrationRequest  true  "User Registration"
 // @Success 201 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /register [post]
 // RegisterUser handles the registration of a new user.
@@ -79,6 +83,11 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if errs := req.Validate(); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
+
 	// Hash the password
 	user := User{
 		Username: req.Username,
@@ -91,9 +100,11 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert the new user into the database
-	insertQuery := `INSERT INTO users (username, password, full_name, bio, role) VALUES (?, ?, ?, ?, ?)`
-	_, err = db.Exec(insertQuery, req.Username, user.Password, user.FullName, user.Bio, user.Role)
-	if err != nil {
+	err = user.Create()
+	if mysqlerr.IsDuplicateEntry(err) {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	} else if err != nil {
 		log.Printf("Failed to insert user: %v", err)
 		http.Error(w, "Failed to register user", http.StatusInternalServerError)
 		return
@@ -178,8 +189,8 @@ func GetProfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var user User
-	query := `SELECT id, username, full_name, bio, role FROM users WHERE username = ?`
-	err = db.QueryRow(query, claims.Username).Scan(&user.ID, &user.Username, &user.FullName, &user.Bio, &user.Role)
+	query := `SELECT id, username, full_name, bio, role, COALESCE(avatar_id, '') FROM users WHERE username = ?`
+	err = db.QueryRow(query, claims.Username).Scan(&user.ID, &user.Username, &user.FullName, &user.Bio, &user.Role, &user.AvatarID)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Profile not found", http.StatusNotFound)
 		return
@@ -187,12 +198,41 @@ func GetProfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
+	user.AvatarURLs = AvatarURLs(user.AvatarID)
 
 	// Return the user profile as JSON
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
 }
 
+// GetPublicProfile retrieves the public profile of any user.
+// @Summary Get a public author profile
+// @Description Get the public profile of a user: full name, bio, avatar and join date
+// @Tags Profile
+// @Produce  json
+// @Param   username  path  string  true  "Username"
+// @Success 200 {object} PublicProfile
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /users/{username} [get]
+func GetPublicProfile(w http.ResponseWriter, r *http.Request) {
+	var profile PublicProfile
+	var avatarID string
+	query := `SELECT username, full_name, bio, created_at, COALESCE(avatar_id, '') FROM users WHERE username = ? AND deletion_requested_at IS NULL`
+	err := db.QueryRow(query, r.PathValue("username")).Scan(&profile.Username, &profile.FullName, &profile.Bio, &profile.JoinedAt, &avatarID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	profile.AvatarURLs = AvatarURLs(avatarID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(profile)
+}
+
 // UpdateProfile allows users to update their profile.
 // @Summary Update user profile
 // @Description Update the profile of the authenticated user
@@ -219,6 +259,11 @@ func UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if errs := req.Validate(); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
+
 	// Update the user's profile in the database
 	updateQuery := `UPDATE users SET full_name = ?, bio = ? WHERE username = ?`
 	_, err = db.Exec(updateQuery, req.FullName, req.Bio, claims.Username)
@@ -247,6 +292,23 @@ func ProtectedRoute(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+		// Personal access tokens are looked up in the database instead of being verified as JWTs
+		if strings.HasPrefix(tokenString, TokenPrefix) {
+			claims, err := AuthenticateToken(tokenString)
+			if err == ErrTokenInvalid {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				log.Printf("Failed to authenticate token: %v", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			r = r.WithContext(ContextWithClaims(r.Context(), claims))
+			next(w, r)
+			return
+		}
+
 		claims := &JWTClaims{}
 
 		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"time"
 
+	"shared/auth"
+
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -16,15 +18,41 @@ type User struct {
 	FullName string `json:"full_name"`
 	Bio      string `json:"bio"`
 	Role     string `json:"role"` // Role can be 'Writer' or 'Admin'
+	AvatarID string `json:"-"`
+	// AvatarURLs maps thumbnail sizes to image URLs; empty when the user has no avatar
+	AvatarURLs map[string]string `json:"avatar_urls,omitempty"`
+}
+
+// PublicProfile is the part of a user's profile anyone may see.
+type PublicProfile struct {
+	Username string    `json:"username"`
+	FullName string    `json:"full_name"`
+	Bio      string    `json:"bio"`
+	JoinedAt time.Time `json:"joined_at"`
+	// AvatarURLs maps thumbnail sizes to image URLs; empty when the user has no avatar
+	AvatarURLs map[string]string `json:"avatar_urls,omitempty"`
 }
 
 // JWTClaims defines the claims for the JWT token.
 type JWTClaims struct {
-	Username string `json:"username"`
-	Role     string `json:"role"` // Include user role in the token
+	Username string   `json:"username"`
+	Role     string   `json:"role"`             // Include user role in the token
+	Scopes   []string `json:"scopes,omitempty"` // Only set for personal access tokens
+	TokenID  int      `json:"-"`                // ID of the personal access token, 0 for login sessions
 	jwt.RegisteredClaims
 }
 
+// HasScope reports whether the claims grant the given scope. Sessions created
+// by /login carry no scopes and are not restricted.
+func (c *JWTClaims) HasScope(scope string) bool {
+	return auth.HasScope(c.Scopes, c.TokenID != 0, scope)
+}
+
+// IsAdmin reports whether the caller has the Admin role.
+func (c *JWTClaims) IsAdmin() bool {
+	return c.Role == auth.RoleAdmin
+}
+
 // Secret key used to sign tokens
 var jwtKey = []byte("my_secret_key")
 
@@ -47,6 +75,35 @@ func (u *User) CheckPassword(password string) error {
 	return nil
 }
 
+// Create inserts a new user and records a UserRegistered event.
+func (u *User) Create() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertQuery := `INSERT INTO users (username, password, full_name, bio, role) VALUES (?, ?, ?, ?, ?)`
+	res, err := tx.Exec(insertQuery, u.Username, u.Password, u.FullName, u.Bio, u.Role)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	u.ID = int(id)
+	var createdAt time.Time
+	if err := tx.QueryRow(`SELECT created_at FROM users WHERE id = ?`, id).Scan(&createdAt); err != nil {
+		return err
+	}
+	event := UserEvent{ID: u.ID, Username: u.Username, FullName: u.FullName, Role: u.Role, CreatedAt: &createdAt}
+	if err := recordUserEvent(tx, EventUserRegistered, event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // GenerateJWT generates a JWT token for the user.
 func (u *User) GenerateJWT() (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
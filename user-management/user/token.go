@@ -0,0 +1,166 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"shared/auth"
+)
+
+// Scopes that can be granted to a personal access token. Tokens issued by /login
+// carry no scopes and are allowed everything their role permits.
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeBlogsRead    = "blogs:read"
+	ScopeBlogsWrite   = "blogs:write"
+	ScopeAdmin        = auth.ScopeAdmin
+)
+
+// ValidScopes lists every scope a personal access token may be granted.
+var ValidScopes = []string{ScopeProfileRead, ScopeProfileWrite, ScopeBlogsRead, ScopeBlogsWrite, ScopeAdmin}
+
+// TokenPrefix marks a bearer token as a personal access token rather than a JWT.
+const TokenPrefix = "pat_"
+
+// Token lifetime limits in days.
+const (
+	defaultTokenLifetimeDays = 90
+	maxTokenLifetimeDays     = 365
+)
+
+// maxTokenName is the longest token name, in characters.
+const maxTokenName = 100
+
+// ErrTokenInvalid is returned when a personal access token is unknown or expired.
+var ErrTokenInvalid = errors.New("invalid or expired token")
+
+// PersonalAccessToken is a long-lived credential created by a user for automation.
+// Only the SHA-256 hash of the secret is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the token, to help users identify it
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// generateTokenSecret returns a new random personal access token.
+func generateTokenSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash under which a token is stored.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken generates a token for the user, stores its hash and returns the
+// plaintext secret. The secret cannot be recovered afterwards.
+func (t *PersonalAccessToken) CreateToken() (string, error) {
+	secret, err := generateTokenSecret()
+	if err != nil {
+		return "", err
+	}
+	t.Prefix = secret[:len(TokenPrefix)+6]
+	t.CreatedAt = time.Now()
+
+	query := `INSERT INTO personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := db.Exec(query, t.UserID, t.Name, hashToken(secret), t.Prefix, strings.Join(t.Scopes, " "), t.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	t.ID = int(id)
+	return secret, nil
+}
+
+// GetTokensForUser lists the personal access tokens belonging to a user.
+func GetTokensForUser(userID int) ([]PersonalAccessToken, error) {
+	query := `SELECT id, user_id, name, token_prefix, scopes, expires_at, last_used_at, created_at
+        FROM personal_access_tokens WHERE user_id = ? ORDER BY created_at DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		var t PersonalAccessToken
+		var scopes string
+		var expiresAt, lastUsedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Fields(scopes)
+		t.ExpiresAt = nullTimePtr(expiresAt)
+		t.LastUsedAt = nullTimePtr(lastUsedAt)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeTokenFromModel deletes a personal access token owned by the user. It reports
+// whether a token was deleted.
+func RevokeTokenFromModel(userID, tokenID int) (bool, error) {
+	res, err := db.Exec(`DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?`, tokenID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AuthenticateToken resolves a personal access token to the claims of its owner
+// and records when it was last used.
+func AuthenticateToken(secret string) (*JWTClaims, error) {
+	var claims JWTClaims
+	var scopes string
+	var expiresAt sql.NullTime
+	query := `SELECT t.id, t.scopes, t.expires_at, u.username, u.role
+        FROM personal_access_tokens t JOIN users u ON u.id = t.user_id
+        WHERE t.token_hash = ?`
+	err := db.QueryRow(query, hashToken(secret)).Scan(&claims.TokenID, &scopes, &expiresAt, &claims.Username, &claims.Role)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		return nil, ErrTokenInvalid
+	}
+	claims.Scopes = strings.Fields(scopes)
+
+	// Only touch last_used_at once a minute so busy scripts don't write on every request
+	_, err = db.Exec(`UPDATE personal_access_tokens SET last_used_at = NOW()
+        WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)`, claims.TokenID)
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
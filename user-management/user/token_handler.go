@@ -0,0 +1,252 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"shared/auth"
+	"shared/validation"
+)
+
+// CreateTokenRequest represents the structure for creating a personal access token
+type CreateTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays *int     `json:"expires_in_days,omitempty"` // Defaults to 90 when omitted, at most 365
+}
+
+// CreateTokenResponse is returned once when a token is created; the token itself is never shown again
+type CreateTokenResponse struct {
+	Token string              `json:"token"`
+	Info  PersonalAccessToken `json:"info"`
+}
+
+// TokenIntrospection describes the identity behind a bearer token
+type TokenIntrospection struct {
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Scopes   []string `json:"scopes"`
+}
+
+// Validate checks the token name, scopes and lifetime.
+func (req CreateTokenRequest) Validate(role string) validation.Errors {
+	errs := validation.Errors{}
+	if strings.TrimSpace(req.Name) == "" {
+		errs["name"] = "is required"
+	} else if utf8.RuneCountInString(req.Name) > maxTokenName {
+		errs["name"] = validation.AtMost(maxTokenName, "characters")
+	}
+
+	if len(req.Scopes) == 0 {
+		errs["scopes"] = "at least one scope is required"
+	}
+	for _, scope := range req.Scopes {
+		if !isValidScope(scope) {
+			errs["scopes"] = "unknown scope " + strconv.Quote(scope)
+			break
+		}
+		if scope == ScopeAdmin && role != "Admin" {
+			errs["scopes"] = "only Admins may grant the admin scope"
+			break
+		}
+	}
+
+	if req.ExpiresInDays != nil && (*req.ExpiresInDays < 1 || *req.ExpiresInDays > maxTokenLifetimeDays) {
+		errs["expires_in_days"] = validation.Between(1, maxTokenLifetimeDays, "")
+	}
+	return errs
+}
+
+func isValidScope(scope string) bool {
+	for _, s := range ValidScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionUserID resolves the user behind a login session. Personal access
+// tokens are rejected so a leaked token cannot be used to mint new ones or to
+// export and delete the account.
+func sessionUserID(w http.ResponseWriter, r *http.Request) (*JWTClaims, int, bool) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, 0, false
+	}
+	if claims.TokenID != 0 {
+		http.Error(w, "This action requires a login session, not a personal access token", http.StatusForbidden)
+		return nil, 0, false
+	}
+
+	var userID int
+	err = db.QueryRow(`SELECT id FROM users WHERE username = ?`, claims.Username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return nil, 0, false
+	} else if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return nil, 0, false
+	}
+	return claims, userID, true
+}
+
+// GetTokens lists the personal access tokens of the logged-in user.
+// @Summary List personal access tokens
+// @Description List the personal access tokens of the authenticated user. Token secrets are never returned.
+// @Tags Tokens
+// @Produce  json
+// @Success 200 {array} PersonalAccessToken
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile/tokens [get]
+func GetTokens(w http.ResponseWriter, r *http.Request) {
+	_, userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := GetTokensForUser(userID)
+	if err != nil {
+		log.Printf("Failed to list tokens: %v", err)
+		http.Error(w, "Failed to list tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateToken creates a personal access token for the logged-in user.
+// @Summary Create a personal access token
+// @Description Create a named, scoped personal access token. The token is only shown in this response.
+// @Tags Tokens
+// @Accept  json
+// @Produce  json
+// @Param   token  body  CreateTokenRequest  true  "Token"
+// @Success 201 {object} CreateTokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile/tokens [post]
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	claims, userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if errs := req.Validate(claims.Role); len(errs) > 0 {
+		validation.Write(w, errs)
+		return
+	}
+
+	days := defaultTokenLifetimeDays
+	if req.ExpiresInDays != nil {
+		days = *req.ExpiresInDays
+	}
+	expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	token := PersonalAccessToken{
+		UserID:    userID,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: &expiresAt,
+	}
+	secret, err := token.CreateToken()
+	if err != nil {
+		log.Printf("Failed to create token: %v", err)
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Personal access token %d created for user: %s", token.ID, claims.Username)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateTokenResponse{Token: secret, Info: token})
+}
+
+// RevokeToken deletes one of the logged-in user's personal access tokens.
+// @Summary Revoke a personal access token
+// @Description Revoke a personal access token of the authenticated user
+// @Tags Tokens
+// @Produce  json
+// @Param   id  query  int  true  "Token ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /profile/tokens [delete]
+func RevokeToken(w http.ResponseWriter, r *http.Request) {
+	claims, userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := RevokeTokenFromModel(userID, tokenID)
+	if err != nil {
+		log.Printf("Failed to revoke token: %v", err)
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Personal access token %d revoked for user: %s", tokenID, claims.Username)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Token revoked successfully",
+	})
+}
+
+// IntrospectToken describes the caller's bearer token. Other services use it to
+// validate personal access tokens.
+// @Summary Introspect a bearer token
+// @Description Returns the username, role and scopes behind the bearer token in the Authorization header
+// @Tags Tokens
+// @Produce  json
+// @Success 200 {object} TokenIntrospection
+// @Failure 401 {object} map[string]string
+// @Router /tokens/introspect [get]
+func IntrospectToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := ClaimsFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TokenIntrospection{
+		Username: claims.Username,
+		Role:     claims.Role,
+		Scopes:   claims.Scopes,
+	})
+}
+
+// RequireScope rejects personal access tokens that were not granted scope.
+// It must be wrapped by ProtectedRoute.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return auth.RequireScope(scope, ClaimsFromContext, next)
+}
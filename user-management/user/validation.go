@@ -0,0 +1,95 @@
+package user
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"shared/validation"
+)
+
+// Limits applied to user input. They mirror the column sizes of the users table
+// and the 72 byte input limit of bcrypt.
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 50
+	minPasswordLength = 8
+	maxPasswordLength = 72
+	maxFullNameLength = 100
+	maxBioLength      = 2000
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Validate checks a registration request against the username, password and full name rules.
+func (req RegistrationRequest) Validate() validation.Errors {
+	errs := validation.Errors{}
+	if msg := validateUsername(req.Username); msg != "" {
+		errs["username"] = msg
+	}
+	if msg := validatePassword(req.Password); msg != "" {
+		errs["password"] = msg
+	}
+	if msg := validateFullName(req.FullName); msg != "" {
+		errs["full_name"] = msg
+	}
+	return errs
+}
+
+// Validate checks a profile update request against the full name and bio rules.
+func (req ProfileRequest) Validate() validation.Errors {
+	errs := validation.Errors{}
+	if msg := validateFullName(req.FullName); msg != "" {
+		errs["full_name"] = msg
+	}
+	if utf8.RuneCountInString(req.Bio) > maxBioLength {
+		errs["bio"] = validation.AtMost(maxBioLength, "characters")
+	}
+	return errs
+}
+
+func validateUsername(username string) string {
+	n := utf8.RuneCountInString(username)
+	switch {
+	case n == 0:
+		return "is required"
+	case n < minUsernameLength || n > maxUsernameLength:
+		return validation.Between(minUsernameLength, maxUsernameLength, "characters")
+	case !usernamePattern.MatchString(username):
+		return "may only contain letters, digits, '_', '.' and '-'"
+	}
+	return ""
+}
+
+func validatePassword(password string) string {
+	if password == "" {
+		return "is required"
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return validation.Between(minPasswordLength, maxPasswordLength, "bytes")
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return "must contain at least one letter and one digit"
+	}
+	return ""
+}
+
+func validateFullName(fullName string) string {
+	if strings.TrimSpace(fullName) == "" {
+		return "is required"
+	}
+	if utf8.RuneCountInString(fullName) > maxFullNameLength {
+		return validation.AtMost(maxFullNameLength, "characters")
+	}
+	return ""
+}